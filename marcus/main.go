@@ -2,110 +2,350 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
 	"marcus/pkg"
+	"marcus/pkg/api"
+	"marcus/pkg/cli"
+	"marcus/pkg/config"
+	"marcus/pkg/dashboard"
+	"marcus/pkg/health"
+	"marcus/pkg/metrics"
+	"marcus/pkg/ratelimit"
+	"marcus/pkg/settings"
 	"marcus/pkg/tts"
+	"marcus/pkg/usage"
+	"marcus/pkg/util"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/disgoorg/disgo"
 	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/cache"
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/events"
 	"github.com/disgoorg/disgo/gateway"
 	"github.com/disgoorg/disgo/voice"
 	"github.com/disgoorg/godave/golibdave"
+	"github.com/disgoorg/snowflake/v2"
 )
 
 var logger *slog.Logger
 
 func main() {
+	configPath := flag.String("config", os.Getenv("MARCUS_CONFIG"), "path to a YAML config file, environment variables override its settings")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), cli.Usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
 
-	level := slog.LevelInfo
-	if os.Getenv("DEBUG") != "" {
-		level = slog.LevelDebug
+	if flag.NArg() > 0 {
+		os.Exit(cli.Main(*configPath, flag.Args()))
 	}
 
+	level := &slog.LevelVar{}
 	logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
 		Level: level,
 	}))
 
-	botToken := os.Getenv("DISCORD_BOT_TOKEN")
-	if botToken == "" {
-		slog.Error("please provide DISCORD_BOT_TOKEN in the environment variables")
+	cfg, err := config.NewStore(*configPath, logger.With("component", "config"))
+	if err != nil {
+		logger.Error("failed to load config", "err", err)
 		os.Exit(1)
 	}
 
-	if os.Getenv("magic_key") == "" {
-		slog.Error("please provide Magic Key in the environment variables")
-		os.Exit(1)
+	setLogLevel := func(c *config.Config) {
+		level.Set(slog.LevelInfo)
+		if c.Debug {
+			level.Set(slog.LevelDebug)
+		}
 	}
+	setLogLevel(cfg.Get())
+	cfg.OnReload(setLogLevel)
+	cfg.Watch(10 * time.Second)
 
-	m := NewMarcus()
+	m := NewMarcus(cfg)
+	checker := health.NewChecker(logger.With("component", "health"), cfg)
 
-	client, err := disgo.New(botToken,
+	var dash *dashboard.Dashboard
+	if cfg.Get().HTTP.AdminToken != "" {
+		dash = dashboard.New(logger.With("component", "dashboard"), cfg)
+		dash.Memes = m.Memes
+		dash.Settings = m.Settings
+		dash.ElevenLabsUsage = m.ElevenLabsUsage
+		dash.AIUsage = m.AIUsage
+	}
+
+	client, err := disgo.New(cfg.Get().Discord.Token,
 		bot.WithGatewayConfigOpts(
 			gateway.WithIntents(
+				gateway.IntentGuilds,
 				gateway.IntentGuildMessages,
 				gateway.IntentGuildVoiceStates,
 				gateway.IntentMessageContent,
 			),
 		),
+		bot.WithEventListenerFunc(checker.OnEvent),
 		bot.WithEventListenerFunc(m.handleMessage),
+		bot.WithEventListenerFunc(m.handleVoiceJoin),
+		bot.WithEventListenerFunc(m.handleVoiceMove),
+		bot.WithEventListenerFunc(m.handleVoiceLeave),
+		bot.WithCacheConfigOpts(
+			cache.WithCaches(cache.FlagGuilds, cache.FlagChannels, cache.FlagRoles, cache.FlagVoiceStates, cache.FlagMembers),
+		),
 		bot.WithVoiceManagerConfigOpts(
 			voice.WithDaveSessionCreateFunc(golibdave.NewSession),
 		),
 	)
 	if err != nil {
-		slog.Error("error while building disgo", slog.Any("err", err))
+		logger.Error("error while building disgo", slog.Any("err", err))
 		return
 	}
 
-	defer client.Close(context.TODO())
+	m.Connections = tts.NewConnectionManager(logger.With("component", "voice"), client.VoiceManager, cfg)
+	checker.Connections = m.Connections
+	checker.SetClient(client)
+	if dash != nil {
+		dash.SetClient(client)
+	}
+
+	var restAPI *api.API
+	if cfg.Get().HTTP.APIToken != "" {
+		restAPI = api.New(logger.With("component", "api"), cfg)
+		restAPI.Memes = m.Memes
+		restAPI.Settings = m.Settings
+		restAPI.Connections = m.Connections
+		// the API is trusted, its new audio counts against the server's
+		// ElevenLabs budget under user 0
+		restAPI.NewTTS = func(guildID snowflake.ID) (*tts.TTS, error) {
+			return m.guildTTS(guildID, settings.Member{IsAdmin: true}, "the API")
+		}
+		restAPI.Start = func() (func(), bool) {
+			if !m.start() {
+				return nil, false
+			}
+			return m.running.Done, true
+		}
+		restAPI.SetClient(client)
+	}
+
+	var server *http.Server
+	if addr := cfg.Get().HTTP.Addr; addr != "" {
+		server = newHTTPServer(addr, checker, dash, restAPI)
+		go serveHTTP(server)
+	}
 
-	m.VoiceManager = client.VoiceManager
+	tts.CheckGenerators(logger.With("component", "tts"), cfg)
 
 	if err = client.OpenGateway(context.TODO()); err != nil {
-		slog.Error("errors while connecting to gateway", slog.Any("err", err))
+		logger.Error("errors while connecting to gateway", slog.Any("err", err))
+		client.Close(context.TODO())
 		return
 	}
 
-	slog.Info("Bot is now running. Press CTRL-C to exit.")
-	select {}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	prewarmer := &tts.Prewarmer{Config: cfg, Logger: logger.With("component", "prewarm")}
+	prewarmer.Start(ctx)
+
+	logger.Info("Bot is now running. Press CTRL-C to exit.")
+	<-ctx.Done()
+	// a second signal kills the process straight away
+	stop()
+
+	timeout := cfg.Get().ShutdownTimeout.Duration()
+	logger.Info("shutting down, waiting for clips and commands to finish", "timeout", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	m.Shutdown(shutdownCtx)
+
+	closeCtx, cancelClose := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelClose()
+	client.Close(closeCtx)
+	if server != nil {
+		if err := server.Shutdown(closeCtx); err != nil {
+			logger.Warn("failed to stop the HTTP server", "err", err)
+		}
+	}
+	logger.Info("shut down")
+}
+
+// newHTTPServer serves metrics, health checks and, when they're turned on,
+// the admin dashboard and REST API.
+func newHTTPServer(addr string, checker *health.Checker, dash *dashboard.Dashboard, restAPI *api.API) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metrics.Handler())
+	mux.HandleFunc("GET /healthz", checker.Healthz)
+	mux.HandleFunc("GET /readyz", checker.Readyz)
+	if dash != nil {
+		dash.Register(mux)
+	}
+	if restAPI != nil {
+		restAPI.Register(mux)
+	}
+	return &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+}
+
+// serveHTTP serves until the server is shut down.
+func serveHTTP(server *http.Server) {
+	logger.Info("serving HTTP", "addr", server.Addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("HTTP server stopped, metrics and health checks are unavailable", "addr", server.Addr, "err", err)
+	}
 }
 
 type Marcus struct {
-	Memes        *pkg.MemeSet
-	VoiceManager voice.Manager
+	Config      *config.Store
+	Memes       *pkg.MemeSet
+	Volumes     *tts.VolumeStore
+	Intros      *pkg.IntroStore
+	Settings    *settings.Store
+	Permissions *settings.PermissionStore
+	Limiter     *ratelimit.Limiter
+
+	ElevenLabsUsage *usage.Ledger
+	AIUsage         *usage.RequestLog
+	Connections     *tts.ConnectionManager
+
+	// running counts commands, the clips they play, intros and API play
+	// requests in progress, which are turned away once closing is set.
+	mu      sync.Mutex
+	closing bool
+	running sync.WaitGroup
 }
 
-func NewMarcus() *Marcus {
+func NewMarcus(cfg *config.Store) *Marcus {
 	m := &Marcus{
+		Config:  cfg,
+		Limiter: ratelimit.New(),
 		Memes: &pkg.MemeSet{
-			Map: &sync.Map{},
+			Map:    &sync.Map{},
+			Config: cfg,
 		},
 	}
 	m.Memes.MonitorMemes(logger)
+
+	volumes, err := tts.NewVolumeStore(cfg.Get().Storage.AudioDir, logger.With("component", "volume"))
+	if err != nil {
+		logger.Error("failed to load volume settings, volume control is disabled", "err", err)
+	} else {
+		m.Volumes = volumes
+	}
+
+	intros, err := pkg.NewIntroStore(cfg, logger.With("component", "intro"))
+	if err != nil {
+		logger.Error("failed to load intros, voice channel greetings are disabled", "err", err)
+	} else {
+		m.Intros = intros
+	}
+
+	guildSettings, err := settings.NewStore(cfg.Get().Storage.AudioDir, logger.With("component", "settings"))
+	if err != nil {
+		logger.Error("failed to load guild settings, every server will use the defaults", "err", err)
+	} else {
+		m.Settings = guildSettings
+	}
+
+	permissions, err := settings.NewPermissionStore(cfg.Get().Storage.AudioDir, logger.With("component", "permissions"))
+	if err != nil {
+		logger.Error("failed to load permissions, every server will use the defaults", "err", err)
+	} else {
+		m.Permissions = permissions
+	}
+
+	elevenLabsUsage, err := usage.NewLedger(filepath.Join(cfg.Get().Storage.AudioDir, "elevenlabs_usage.json"), logger.With("component", "usage"))
+	if err != nil {
+		logger.Error("failed to load the ElevenLabs usage ledger, character budgets are disabled", "err", err)
+	} else {
+		m.ElevenLabsUsage = elevenLabsUsage
+	}
+
+	aiUsage, err := usage.NewRequestLog(filepath.Join(cfg.Get().Storage.AudioDir, "openrouter_usage.jsonl"), logger.With("component", "usage"))
+	if err != nil {
+		logger.Error("failed to load the AI usage log, spending caps are disabled", "err", err)
+	} else {
+		m.AIUsage = aiUsage
+	}
 	return m
 }
 
+// start registers a command or intro, returning false once shutting down.
+// Call a.running.Done when it finishes.
+func (a *Marcus) start() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closing {
+		return false
+	}
+	a.running.Add(1)
+	return true
+}
+
+// Shutdown stops taking commands, then waits for running commands and the
+// clips they play to finish so their cache files, metadata and ledgers are
+// written. Voice connections are closed after, as each server's queue empties.
+func (a *Marcus) Shutdown(ctx context.Context) {
+	a.mu.Lock()
+	a.closing = true
+	a.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		a.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		logger.Warn("commands were still running at the shutdown deadline")
+	}
+
+	if a.Connections != nil {
+		if err := a.Connections.Shutdown(ctx); err != nil {
+			logger.Warn("voice connections didn't drain in time", "err", err)
+		}
+	}
+}
+
 func (a *Marcus) handleMessage(event *events.MessageCreate) {
 	if event.Message.Author.Bot {
 		return
 	}
+	if !a.start() {
+		return
+	}
+	defer a.running.Done()
 
-	ttsGen, err := tts.NewTTS(logger.With("component", "tts"), a.VoiceManager)
+	ttsGen, err := tts.NewTTS(logger.With("component", "tts"), a.Config, a.Connections)
 	if err != nil {
 		logger.Error(fmt.Sprintf("failed to create TTS generator: %v", err))
 		return
 	}
+	ttsGen.Volumes = a.Volumes
+	ttsGen.Playing = &a.running
 
 	c := pkg.Command{
-		MessageEvent: event,
-		Logger:       logger.With("ID", event.Message.ID, "author", event.Message.Author.Username, "channel", event.ChannelID),
-		TTS:          ttsGen,
-		MemeSet:      a.Memes,
+		Config:        a.Config,
+		MessageEvent:  event,
+		Logger:        logger.With("ID", event.Message.ID, "author", event.Message.Author.Username, "channel", event.ChannelID),
+		TTS:           ttsGen,
+		MemeSet:       a.Memes,
+		Intros:        a.Intros,
+		GuildSettings: a.Settings,
+		Permissions:   a.Permissions,
+		Limiter:       a.Limiter,
+
+		ElevenLabsUsage: a.ElevenLabsUsage,
+		AIUsage:         a.AIUsage,
 	}
 
 	err = c.Build().Execute()
@@ -116,3 +356,95 @@ func (a *Marcus) handleMessage(event *events.MessageCreate) {
 		}
 	}
 }
+
+func (a *Marcus) handleVoiceJoin(event *events.GuildVoiceJoin) {
+	a.greet(event.GenericGuildVoiceState)
+}
+
+func (a *Marcus) handleVoiceMove(event *events.GuildVoiceMove) {
+	a.greet(event.GenericGuildVoiceState)
+	a.leaveIfAlone(event.Client(), event.VoiceState.GuildID)
+}
+
+func (a *Marcus) handleVoiceLeave(event *events.GuildVoiceLeave) {
+	a.leaveIfAlone(event.Client(), event.VoiceState.GuildID)
+}
+
+// leaveIfAlone disconnects from the guild's voice channel once the only
+// members left in it are bots.
+func (a *Marcus) leaveIfAlone(client *bot.Client, guildID snowflake.ID) {
+	channelID := a.Connections.ChannelID(guildID)
+	if channelID == nil {
+		return
+	}
+
+	for state := range client.Caches.VoiceStates(guildID) {
+		if state.ChannelID == nil || *state.ChannelID != *channelID || state.UserID == client.ID() {
+			continue
+		}
+		if member, ok := client.Caches.Member(guildID, state.UserID); ok && member.User.Bot {
+			continue
+		}
+		return
+	}
+
+	a.Connections.Leave(guildID)
+}
+
+// guildTTS returns a TTS using the guild's settings that generates new
+// ElevenLabs audio on behalf of member, checking their permission and budget.
+func (a *Marcus) guildTTS(guildID snowflake.ID, member settings.Member, memberName string) (*tts.TTS, error) {
+	ttsGen, err := tts.NewTTS(logger.With("component", "tts"), a.Config, a.Connections)
+	if err != nil {
+		return nil, err
+	}
+	ttsGen.Volumes = a.Volumes
+	ttsGen.Playing = &a.running
+	ttsGen.UseGuildSettings(a.Settings.Get(guildID))
+
+	ttsGen.CanGenerate = func(generatorName, content string) error {
+		if generatorName != tts.ElevenLabsGeneratorName {
+			return nil
+		}
+		if !a.Permissions.Allowed(guildID, settings.PermElevenLabs, member) {
+			return fmt.Errorf("%w, %s doesn't have the '%s' permission", settings.ErrNotAllowed, memberName, settings.PermElevenLabs)
+		}
+		return pkg.ReserveElevenLabsBudget(a.Config, a.ElevenLabsUsage, guildID, member.UserID, content)
+	}
+	ttsGen.GenerationFailed = func(generatorName, content string) {
+		if generatorName == tts.ElevenLabsGeneratorName {
+			pkg.ReleaseElevenLabsBudget(a.ElevenLabsUsage, guildID, member.UserID, content, logger)
+		}
+	}
+	return ttsGen, nil
+}
+
+// greet plays a member's intro when they enter a voice channel.
+func (a *Marcus) greet(state *events.GenericGuildVoiceState) {
+	if a.Intros == nil || state.Member.User.Bot || state.VoiceState.ChannelID == nil {
+		return
+	}
+	if !a.Settings.Get(state.VoiceState.GuildID).GroupEnabled(settings.GroupIntro) {
+		return
+	}
+
+	// new intro audio is generated on behalf of the member who joined
+	member := settings.Member{
+		UserID:  state.Member.User.ID,
+		RoleIDs: state.Member.RoleIDs,
+		IsAdmin: util.MemberIsAdmin(state.Client(), state.Member),
+	}
+	ttsGen, err := a.guildTTS(state.VoiceState.GuildID, member, state.Member.EffectiveName())
+	if err != nil {
+		logger.Error(fmt.Sprintf("failed to create TTS generator: %v", err))
+		return
+	}
+
+	if !a.start() {
+		return
+	}
+	go func() {
+		defer a.running.Done()
+		a.Intros.Greet(ttsGen, a.Memes, state.VoiceState.GuildID, *state.VoiceState.ChannelID, state.VoiceState.UserID, state.Member.EffectiveName())
+	}()
+}
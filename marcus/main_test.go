@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"marcus/pkg"
+	"marcus/pkg/config"
+	"marcus/pkg/fake"
+	"marcus/pkg/settings"
+	"marcus/pkg/tts"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const (
+	testGuildID   snowflake.ID = 10
+	testUserID    snowflake.ID = 30
+	testGeneralID snowflake.ID = 40
+)
+
+func TestGreet(t *testing.T) {
+	logger = slog.New(slog.DiscardHandler)
+
+	tests := []struct {
+		name      string
+		groups    string
+		wantClips int
+	}{
+		{name: "plays the intro", wantClips: 1},
+		{name: "intro group turned off", groups: settings.GroupTTS + "," + settings.GroupMemes, wantClips: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := config.Default()
+			cfg.Storage.AudioDir = dir
+			cfg.Storage.MemesDir = filepath.Join(dir, "memes")
+			store := config.NewStaticStore(cfg)
+
+			if err := os.MkdirAll(cfg.Storage.MemesDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(cfg.Storage.MemesDir, "airhorn.wav"), []byte("airhorn"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			memes := &pkg.MemeSet{Map: &sync.Map{}, Config: store}
+			if err := memes.BuildMemeSet(); err != nil {
+				t.Fatalf("failed to load memes: %v", err)
+			}
+
+			intros, err := pkg.NewIntroStore(store, logger)
+			if err != nil {
+				t.Fatalf("failed to create intro store: %v", err)
+			}
+			if err := intros.Update(testGuildID, testUserID, func(intro *pkg.Intro) { intro.Meme = "airhorn" }); err != nil {
+				t.Fatalf("failed to set intro: %v", err)
+			}
+			guildSettings, err := settings.NewStore(dir, logger)
+			if err != nil {
+				t.Fatalf("failed to create settings store: %v", err)
+			}
+			if tt.groups != "" {
+				if _, err := guildSettings.Set(testGuildID, settings.KeyCommandGroups, tt.groups); err != nil {
+					t.Fatalf("failed to set command groups: %v", err)
+				}
+			}
+
+			voice := fake.NewVoiceManager()
+			connections := tts.NewConnectionManager(logger, voice, store)
+			connections.Encode = fake.Encode
+			t.Cleanup(func() { _ = connections.Shutdown(context.Background()) })
+
+			m := &Marcus{Config: store, Memes: memes, Intros: intros, Settings: guildSettings, Connections: connections}
+			channelID := testGeneralID
+			m.greet(&events.GenericGuildVoiceState{
+				GenericEvent: events.NewGenericEvent(fake.NewDiscord(t).Client(t), 0, 0),
+				VoiceState:   discord.VoiceState{GuildID: testGuildID, ChannelID: &channelID, UserID: testUserID},
+				Member:       discord.Member{User: discord.User{ID: testUserID, Username: "alice"}, GuildID: testGuildID},
+			})
+			m.running.Wait()
+
+			if clips := voice.Clips(); len(clips) != tt.wantClips {
+				t.Errorf("played %d clips, want %d", len(clips), tt.wantClips)
+			}
+		})
+	}
+}
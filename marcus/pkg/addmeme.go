@@ -3,6 +3,7 @@ package pkg
 import (
 	"fmt"
 	"io"
+	"marcus/pkg/settings"
 	"marcus/pkg/util"
 	"net/http"
 	"os"
@@ -28,6 +29,7 @@ func (c *Command) AddMeme() {
 	attachment := c.MessageEvent.Message.ReferencedMessage.Attachments[0]
 	if !strings.HasSuffix(attachment.Filename, ".wav") {
 		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, addMemeUsage, "failed to send usage for add-meme")
+		return
 	}
 
 	if strings.Contains(c.TTSOpts.Content, " ") {
@@ -35,6 +37,12 @@ func (c *Command) AddMeme() {
 		return
 	}
 
+	// replacing a meme someone else added needs more than the addmeme permission
+	if _, exists := c.MemeSet.GetMeme(c.TTSOpts.Content); exists && !c.allowed(settings.PermMemeAdmin) {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("'!%s' already exists, and you need the '%s' permission to replace it", c.TTSOpts.Content, settings.PermMemeAdmin), "failed to send add-meme")
+		return
+	}
+
 	req, err := http.NewRequest(http.MethodGet, attachment.URL, nil)
 	if err != nil {
 		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("failed to download referenced audio file: %v", err), "failed to send usage for add-meme")
@@ -58,12 +66,9 @@ func (c *Command) AddMeme() {
 		return
 	}
 
-	MemeLocation := os.Getenv("MEMES_LOCATION")
-	if MemeLocation == "" {
-		MemeLocation = "memes"
-	}
+	MemeLocation := c.Config.Get().Storage.MemesDir
 
-	file, err := os.OpenFile(fmt.Sprintf("%s/%s.wav", MemeLocation, c.TTSOpts.Content), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	file, err := os.OpenFile(fmt.Sprintf("%s/%s.wav", MemeLocation, c.TTSOpts.Content), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("failed to download referenced audio file, encountered error creating file: %v", err), "failed to send usage for add-meme")
 		return
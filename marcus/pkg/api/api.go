@@ -0,0 +1,315 @@
+// Package api is the REST API for playing memes and TTS from outside
+// Discord, such as from scripts, stream decks and home automation.
+package api
+
+import (
+	"cmp"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"marcus/pkg"
+	"marcus/pkg/config"
+	"marcus/pkg/settings"
+	"marcus/pkg/tts"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// maxBodySize is plenty for a line of text.
+const maxBodySize = 64 << 10
+
+// API serves the REST API under /api/v1/.
+type API struct {
+	Config      *config.Store
+	Memes       *pkg.MemeSet
+	Settings    *settings.Store
+	Connections *tts.ConnectionManager
+	Logger      *slog.Logger
+
+	// NewTTS returns a TTS set up for the guild, with its settings, volumes
+	// and ElevenLabs budgets applied.
+	NewTTS func(guildID snowflake.ID) (*tts.TTS, error)
+	// Start, when set, is called before a play request spends anything. It
+	// returns false once shutting down, otherwise done is called when the
+	// request's playback finishes.
+	Start func() (done func(), ok bool)
+
+	client atomic.Pointer[bot.Client]
+}
+
+func New(logger *slog.Logger, cfg *config.Store) *API {
+	return &API{Config: cfg, Logger: logger}
+}
+
+// SetClient lets the API look up voice channels.
+func (a *API) SetClient(client *bot.Client) {
+	a.client.Store(client)
+}
+
+// Register adds the API's routes to mux.
+func (a *API) Register(mux *http.ServeMux) {
+	mux.Handle("GET /api/v1/voices", a.auth(a.voices))
+	mux.Handle("GET /api/v1/memes", a.auth(a.memes))
+	mux.Handle("GET /api/v1/guilds/{guild}/queue", a.auth(a.queue))
+	mux.Handle("POST /api/v1/guilds/{guild}/memes", a.auth(a.playMeme))
+	mux.Handle("POST /api/v1/guilds/{guild}/tts", a.auth(a.playTTS))
+}
+
+func (a *API) auth(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := a.Config.Get().HTTP.APIToken
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+			return
+		}
+		next(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func (a *API) voices(w http.ResponseWriter, _ *http.Request) {
+	t, err := a.NewTTS(0)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string][]string{"voices": t.ListSupportedVoiceNames()})
+}
+
+func (a *API) memes(w http.ResponseWriter, _ *http.Request) {
+	names := []string{}
+	if a.Memes != nil {
+		a.Memes.Range(func(key, _ any) bool {
+			names = append(names, key.(string))
+			return true
+		})
+	}
+	slices.Sort(names)
+	writeJSON(w, http.StatusOK, map[string][]string{"memes": names})
+}
+
+func (a *API) queue(w http.ResponseWriter, r *http.Request) {
+	guildID, err := snowflake.Parse(r.PathValue("guild"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid guild ID")
+		return
+	}
+	writeJSON(w, http.StatusOK, a.Connections.Queue(guildID))
+}
+
+// playRequest is the body of the play endpoints.
+type playRequest struct {
+	// Channel is the voice channel's ID or name, defaulting to the server's
+	// voice-channel setting.
+	Channel string `json:"channel"`
+	Meme    string `json:"meme"`
+	Text    string `json:"text"`
+	Voice   string `json:"voice"`
+	// Wait responds once the clip has played, rather than once it's queued.
+	Wait bool `json:"wait"`
+}
+
+func (a *API) playMeme(w http.ResponseWriter, r *http.Request) {
+	req, guildID, channelID, ok := a.readPlayRequest(w, r, settings.GroupMemes)
+	if !ok {
+		return
+	}
+
+	name := strings.ToLower(strings.TrimSpace(req.Meme))
+	if a.Memes == nil {
+		writeError(w, http.StatusNotFound, "memes aren't loaded")
+		return
+	}
+	file, found := a.Memes.GetMeme(name)
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("there's no meme called '%s'", name))
+		return
+	}
+
+	t, err := a.NewTTS(guildID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	done, ok := a.start(w)
+	if !ok {
+		return
+	}
+	a.Memes.Played(guildID, name)
+	a.Logger.Info("playing meme from the API", "guildID", guildID, "channelID", channelID, "meme", name)
+	a.play(w, req.Wait, done, func() error {
+		return t.PlayFile(guildID, channelID, 0, file)
+	})
+}
+
+func (a *API) playTTS(w http.ResponseWriter, r *http.Request) {
+	req, guildID, channelID, ok := a.readPlayRequest(w, r, settings.GroupTTS)
+	if !ok {
+		return
+	}
+	if strings.TrimSpace(req.Text) == "" {
+		writeError(w, http.StatusBadRequest, "text is required")
+		return
+	}
+
+	t, err := a.NewTTS(guildID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if req.Voice != "" {
+		voice := strings.ToLower(strings.TrimSpace(req.Voice))
+		if _, err := t.GetGeneratorForVoice(voice); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("there's no voice called '%s'", voice))
+			return
+		}
+		t.Voice = voice
+	}
+
+	done, ok := a.start(w)
+	if !ok {
+		return
+	}
+	// generate before responding, so problems like a spent budget are reported
+	audio, err := t.Render(req.Text)
+	if err != nil {
+		done()
+		writeError(w, renderStatus(err), err.Error())
+		return
+	}
+
+	a.Logger.Info("playing TTS from the API", "guildID", guildID, "channelID", channelID, "voice", t.Voice)
+	a.play(w, req.Wait, done, func() error {
+		return t.PlayInChannel(guildID, channelID, 0, audio)
+	})
+}
+
+// renderStatus is the status for audio that couldn't be made: refusals are
+// the client's to fix, a generator failing is upstream's.
+func renderStatus(err error) int {
+	switch {
+	case errors.Is(err, tts.ErrTooLong):
+		return http.StatusBadRequest
+	case errors.Is(err, settings.ErrNotAllowed):
+		return http.StatusForbidden
+	case errors.Is(err, pkg.ErrOverBudget):
+		return http.StatusTooManyRequests
+	case errors.Is(err, tts.ErrGenerationFailed):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// readPlayRequest parses a play request for a guild that has the group
+// turned on, writing an error and returning false if it can't be played.
+func (a *API) readPlayRequest(w http.ResponseWriter, r *http.Request, group string) (playRequest, snowflake.ID, snowflake.ID, bool) {
+	var req playRequest
+	guildID, err := snowflake.Parse(r.PathValue("guild"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid guild ID")
+		return req, 0, 0, false
+	}
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+		return req, 0, 0, false
+	}
+
+	if !a.Settings.Get(guildID).GroupEnabled(group) {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("%s commands are turned off in this server", group))
+		return req, 0, 0, false
+	}
+
+	channelID, err := a.voiceChannel(guildID, cmp.Or(req.Channel, a.Settings.Get(guildID).VoiceChannel()))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return req, 0, 0, false
+	}
+	return req, guildID, channelID, true
+}
+
+// voiceChannel finds a voice channel in the guild by ID or name.
+func (a *API) voiceChannel(guildID snowflake.ID, channel string) (snowflake.ID, error) {
+	channel = strings.TrimSpace(channel)
+	if channel == "" {
+		return 0, errors.New("channel is required, as the server has no voice-channel set")
+	}
+
+	client := a.client.Load()
+	if client == nil {
+		return 0, errors.New("not connected to Discord yet")
+	}
+	channels, err := client.Rest.GetGuildChannels(guildID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the server's channels: %v", err)
+	}
+
+	for _, c := range channels {
+		if c.Type() != discord.ChannelTypeGuildVoice && c.Type() != discord.ChannelTypeGuildStageVoice {
+			continue
+		}
+		if c.ID().String() == channel || strings.EqualFold(c.Name(), channel) {
+			return c.ID(), nil
+		}
+	}
+	return 0, fmt.Errorf("there's no voice channel '%s' in the server", channel)
+}
+
+// start registers a play request, refusing it once shutting down.
+func (a *API) start(w http.ResponseWriter) (func(), bool) {
+	if a.Start == nil {
+		return func() {}, true
+	}
+	done, ok := a.Start()
+	if !ok {
+		writeError(w, http.StatusServiceUnavailable, tts.ErrShuttingDown.Error())
+	}
+	return done, ok
+}
+
+// play runs playback, waiting for it to finish only if asked to, then calls
+// done.
+func (a *API) play(w http.ResponseWriter, wait bool, done func(), play func() error) {
+	if !wait {
+		go func() {
+			defer done()
+			if err := play(); err != nil {
+				a.Logger.Error("failed to play from the API", "err", err)
+			}
+		}()
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+		return
+	}
+
+	err := play()
+	done()
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, tts.ErrShuttingDown) {
+			status = http.StatusServiceUnavailable
+		}
+		writeError(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "played"})
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"marcus/pkg"
+	"marcus/pkg/config"
+	"marcus/pkg/fake"
+	"marcus/pkg/settings"
+	"marcus/pkg/tts"
+	"marcus/pkg/usage"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const (
+	testToken                 = "secret"
+	testGuildID  snowflake.ID = 10
+	testVoiceID  snowflake.ID = 40
+	testGuildURL              = "/api/v1/guilds/10"
+)
+
+func TestAPI(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		token     string
+		body      string
+		groups    string
+		budget    int
+		refuse    error
+		generator error
+		closing   bool
+
+		wantStatus int
+		wantBody   string
+		wantClips  int
+	}{
+		{name: "no token", method: http.MethodGet, path: "/api/v1/memes", wantStatus: http.StatusUnauthorized, wantBody: `{"error":"missing or wrong bearer token"}`},
+		{name: "wrong token", method: http.MethodGet, path: "/api/v1/memes", token: "guess", wantStatus: http.StatusUnauthorized, wantBody: `{"error":"missing or wrong bearer token"}`},
+		{name: "list memes", method: http.MethodGet, path: "/api/v1/memes", token: testToken, wantStatus: http.StatusOK, wantBody: `{"memes":["airhorn"]}`},
+		{name: "meme", method: http.MethodPost, path: testGuildURL + "/memes", token: testToken, body: `{"meme": "airhorn", "channel": "General", "wait": true}`, wantStatus: http.StatusOK, wantBody: `{"status":"played"}`, wantClips: 1},
+		{name: "unknown meme", method: http.MethodPost, path: testGuildURL + "/memes", token: testToken, body: `{"meme": "nothing", "channel": "General"}`, wantStatus: http.StatusNotFound, wantBody: `{"error":"there's no meme called 'nothing'"}`},
+		{name: "memes turned off", method: http.MethodPost, path: testGuildURL + "/memes", token: testToken, body: `{"meme": "airhorn", "channel": "General"}`, groups: settings.GroupTTS, wantStatus: http.StatusForbidden, wantBody: `{"error":"memes commands are turned off in this server"}`},
+		{name: "queued meme", method: http.MethodPost, path: testGuildURL + "/memes", token: testToken, body: `{"meme": "airhorn", "channel": "General"}`, wantStatus: http.StatusAccepted, wantBody: `{"status":"queued"}`, wantClips: 1},
+		{name: "shutting down", method: http.MethodPost, path: testGuildURL + "/tts", token: testToken, body: `{"text": "hello", "channel": "General"}`, closing: true, wantStatus: http.StatusServiceUnavailable, wantBody: `{"error":"marcus is shutting down"}`},
+		{name: "invalid guild", method: http.MethodPost, path: "/api/v1/guilds/general/memes", token: testToken, body: `{"meme": "airhorn"}`, wantStatus: http.StatusBadRequest, wantBody: `{"error":"invalid guild ID"}`},
+		{name: "unknown channel", method: http.MethodPost, path: testGuildURL + "/memes", token: testToken, body: `{"meme": "airhorn", "channel": "Nowhere"}`, wantStatus: http.StatusBadRequest, wantBody: `{"error":"there's no voice channel 'Nowhere' in the server"}`},
+		{name: "tts", method: http.MethodPost, path: testGuildURL + "/tts", token: testToken, body: `{"text": "hello", "voice": "liam", "channel": "General", "wait": true}`, wantStatus: http.StatusOK, wantBody: `{"status":"played"}`, wantClips: 1},
+		{name: "unknown voice", method: http.MethodPost, path: testGuildURL + "/tts", token: testToken, body: `{"text": "hello", "voice": "tim", "channel": "General"}`, wantStatus: http.StatusBadRequest, wantBody: `{"error":"there's no voice called 'tim'"}`},
+		{name: "tts turned off", method: http.MethodPost, path: testGuildURL + "/tts", token: testToken, body: `{"text": "hello", "channel": "General"}`, groups: settings.GroupMemes, wantStatus: http.StatusForbidden, wantBody: `{"error":"tts commands are turned off in this server"}`},
+		{name: "too long", method: http.MethodPost, path: testGuildURL + "/tts", token: testToken, body: fmt.Sprintf(`{"text": %q, "channel": "General"}`, strings.Repeat("a", settings.DefaultMaxTTSLength)), wantStatus: http.StatusBadRequest},
+		{name: "over budget", method: http.MethodPost, path: testGuildURL + "/tts", token: testToken, body: `{"text": "hello", "channel": "General"}`, budget: 3, wantStatus: http.StatusTooManyRequests},
+		{name: "missing permission", method: http.MethodPost, path: testGuildURL + "/tts", token: testToken, body: `{"text": "hello", "channel": "General"}`, refuse: fmt.Errorf("%w, the API doesn't have the 'elevenlabs' permission", settings.ErrNotAllowed), wantStatus: http.StatusForbidden},
+		{name: "generator fails", method: http.MethodPost, path: testGuildURL + "/tts", token: testToken, body: `{"text": "hello", "channel": "General"}`, generator: errors.New("quota exceeded"), wantStatus: http.StatusBadGateway, wantBody: `{"error":"failed to generate TTS: quota exceeded"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.DiscardHandler)
+			dir := t.TempDir()
+			cfg := config.Default()
+			cfg.Storage.AudioDir = dir
+			cfg.Storage.MemesDir = filepath.Join(dir, "memes")
+			cfg.HTTP.APIToken = testToken
+			cfg.ElevenLabs.MonthlyGuildCharacters = tt.budget
+			store := config.NewStaticStore(cfg)
+
+			if err := os.MkdirAll(cfg.Storage.MemesDir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(cfg.Storage.MemesDir, "airhorn.wav"), []byte("airhorn"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			memes := &pkg.MemeSet{Map: &sync.Map{}, Config: store}
+			if err := memes.BuildMemeSet(); err != nil {
+				t.Fatalf("failed to load memes: %v", err)
+			}
+			// the walk stores the memes directory itself under its whole path
+			memes.Delete(strings.ReplaceAll(cfg.Storage.MemesDir, "/", "-"))
+
+			guildSettings, err := settings.NewStore(dir, logger)
+			if err != nil {
+				t.Fatalf("failed to create settings store: %v", err)
+			}
+			if tt.groups != "" {
+				if _, err := guildSettings.Set(testGuildID, settings.KeyCommandGroups, tt.groups); err != nil {
+					t.Fatalf("failed to set command groups: %v", err)
+				}
+			}
+			ledger, err := usage.NewLedger(filepath.Join(dir, "usage.json"), logger)
+			if err != nil {
+				t.Fatalf("failed to create ledger: %v", err)
+			}
+
+			discord := fake.NewDiscord(t)
+			discord.AddVoiceChannel(testGuildID, testVoiceID, "General")
+			voice := fake.NewVoiceManager()
+			connections := tts.NewConnectionManager(logger, voice, store)
+			connections.Encode = fake.Encode
+			t.Cleanup(func() { _ = connections.Shutdown(context.Background()) })
+
+			generator := &fake.Generator{Provider: tts.ElevenLabsGeneratorName, Voices: []string{"marcus", "liam"}, Err: tt.generator}
+			a := New(logger, store)
+			a.Memes = memes
+			a.Settings = guildSettings
+			a.Connections = connections
+			a.NewTTS = func(guildID snowflake.ID) (*tts.TTS, error) {
+				t := &tts.TTS{Config: store, Connections: connections, Logger: logger, Generators: []tts.Generator{generator}}
+				t.UseGuildSettings(guildSettings.Get(guildID))
+				t.CanGenerate = func(_, content string) error {
+					if tt.refuse != nil {
+						return tt.refuse
+					}
+					return pkg.ReserveElevenLabsBudget(store, ledger, guildID, 0, content)
+				}
+				return t, nil
+			}
+			var running sync.WaitGroup
+			a.Start = func() (func(), bool) {
+				if tt.closing {
+					return nil, false
+				}
+				running.Add(1)
+				return running.Done, true
+			}
+			a.SetClient(discord.Client(t))
+			mux := http.NewServeMux()
+			a.Register(mux)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			// queued clips are tracked until they've played
+			running.Wait()
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("%s %s = %d, want %d: %s", tt.method, tt.path, rec.Code, tt.wantStatus, rec.Body)
+			}
+			if body := strings.TrimSpace(rec.Body.String()); tt.wantBody != "" && body != tt.wantBody {
+				t.Errorf("%s %s responded %s, want %s", tt.method, tt.path, body, tt.wantBody)
+			}
+			if clips := voice.Clips(); len(clips) != tt.wantClips {
+				t.Errorf("played %d clips, want %d", len(clips), tt.wantClips)
+			}
+			if tt.closing && len(generator.Spoken()) > 0 {
+				t.Errorf("generated %q while shutting down", generator.Spoken())
+			}
+		})
+	}
+}
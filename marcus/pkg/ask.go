@@ -1,83 +1,116 @@
 package pkg
 
 import (
+	"marcus/pkg/config"
 	"marcus/pkg/util"
 
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/events"
 	"github.com/disgoorg/snowflake/v2"
-	"github.com/revrost/go-openrouter"
 
-	"context"
 	"fmt"
-	"os"
 	"strings"
 	"time"
 )
 
 type modelOpts struct {
-	model        string
+	persona      config.PersonaConfig
 	systemPrompt string
 	prompt       string
 }
 
-var (
-	modelMarcus = modelOpts{
-		model:        "tngtech/deepseek-r1t2-chimera:free",
-		systemPrompt: marcusSystemPrompt,
-	}
+// answer is a model's answer to a question and the tools it used on the way.
+type answer struct {
+	ChatResponse
+	tools []string
+}
 
-	modelGeneral = modelOpts{
-		model:        "google/gemma-3n-e2b-it:free",
+func (c *Command) AskAIQuestion() {
+	mOps := modelOpts{
+		persona:      c.Config.Get().AI.General,
 		systemPrompt: systemPrompt,
+		prompt:       c.TTSOpts.Content,
 	}
-)
 
-func (c *Command) AskAIQuestion() {
-	mOps := modelGeneral
-	mOps.prompt = c.TTSOpts.Content
-
-	thinkingMsg, response, reasoning := askedQuestion(c.MessageEvent, mOps)
+	thinkingMsg, result := c.askQuestion(mOps)
 	if thinkingMsg == nil {
 		return
 	}
 
-	respondToQuestion(c.MessageEvent, thinkingMsg.ID, "The AI Thought: ||```%s```||", response, reasoning)
+	respondToQuestion(c.MessageEvent, thinkingMsg.ID, "The AI Thought: ||```%s```||", result)
 
-	c.TTS.GenerateAndPlay(c.MessageEvent, response, c.TTSOpts.ChannelName)
+	c.TTS.GenerateAndPlay(c.request(), result.Content, c.TTSOpts.ChannelName)
 }
 
 func (c *Command) AskMarcusQuestion() {
-	mOps := modelMarcus
-	mOps.prompt = c.TTSOpts.Content
+	mOps := modelOpts{
+		persona:      c.Config.Get().AI.Marcus,
+		systemPrompt: marcusSystemPrompt,
+		prompt:       c.TTSOpts.Content,
+	}
+	if persona := c.guild.Persona(); persona != "" {
+		mOps.systemPrompt = systemPrompt + persona
+	}
 
-	thinkingMsg, response, reasoning := askedQuestion(c.MessageEvent, mOps)
+	thinkingMsg, result := c.askQuestion(mOps)
 	if thinkingMsg == nil {
 		return
 	}
 
-	respondToQuestion(c.MessageEvent, thinkingMsg.ID, "Marcus Thought: ||```%s```||", response, reasoning)
+	respondToQuestion(c.MessageEvent, thinkingMsg.ID, "Marcus Thought: ||```%s```||", result)
 
-	c.TTS.GenerateAndPlay(c.MessageEvent, response, c.TTSOpts.ChannelName)
+	c.TTS.GenerateAndPlay(c.request(), result.Content, c.TTSOpts.ChannelName)
 }
 
-func askedQuestion(e *events.MessageCreate, mOps modelOpts) (*discord.Message, string, string) {
-	var response, reasoning string
-	var err error
+// askQuestion shows a thinking message while the models are asked, returning
+// nil if no answer could be given. Failures are reported in the thinking message.
+func (c *Command) askQuestion(mOps modelOpts) (*discord.Message, answer) {
+	e := c.MessageEvent
+
+	if err := c.checkAISpend(); err != nil {
+		util.SendMessageWithError(e, e.ChannelID, err.Error(), "failed to refuse question")
+		return nil, answer{}
+	}
+
+	llm, err := c.llm(mOps.persona.Backend)
+	if err != nil {
+		c.Logger.Error("failed to choose AI backend", "backend", mOps.persona.Backend, "err", err)
+		util.SendMessageWithError(e, e.ChannelID, fmt.Sprintf("failed to respond to question: %v", err), "failed to respond to question")
+		return nil, answer{}
+	}
 
 	msg, err := util.SendMessageInChannel(e, e.ChannelID, "Thinking...")
 	if err != nil {
 		_, _ = util.SendMessageInChannel(e, e.ChannelID, fmt.Sprintf("failed to respond to question: %v", err))
-		return nil, "", ""
+		return nil, answer{}
 	}
 
-	stopThinking := startThinking(e, msg.ID)
-	response, reasoning, err = openRouterRequest(mOps)
+	messages := []ChatMessage{
+		{Role: RoleSystem, Content: fillPrompt(mOps.systemPrompt, c.promptVariables())},
+		{Role: RoleUser, Content: mOps.prompt},
+	}
 
-	stopThinking <- struct{}{}
+	tools := c.toolbox()
+	stopThinking := startThinking(e, msg.ID)
+	result, err := completeWithFallback(c.Logger, llm, mOps.persona.Models, c.Config.Get().AI.Retries, messages, tools)
 	close(stopThinking)
+	c.recordAIUsage(result)
+
+	if err != nil {
+		c.Logger.Error("failed to get an answer", "backend", llm.Name(), "models", mOps.persona.Models, "err", err)
+		util.EditMessageWithError(e, msg.ID, fmt.Sprintf("Couldn't get an answer from any model: %v", err), "failed to respond to question")
+		return nil, answer{}
+	}
 
-	return msg, response, reasoning
+	return msg, answer{ChatResponse: result, tools: tools.Calls()}
+}
+
+// llm returns the named backend, preferring one given in LLMs over the config.
+func (c *Command) llm(backend string) (LLM, error) {
+	if llm, ok := c.LLMs[backend]; ok {
+		return llm, nil
+	}
+	return newLLM(c.Config.Get(), backend)
 }
 
 func startThinking(e *events.MessageCreate, msgId snowflake.ID) chan struct{} {
@@ -94,7 +127,7 @@ func startThinking(e *events.MessageCreate, msgId snowflake.ID) chan struct{} {
 			select {
 			case <-timeoutTicker.C:
 				return
-			case _, _ = <-stop:
+			case <-stop:
 				return
 			default:
 				dotBuf.WriteString(".")
@@ -110,13 +143,17 @@ func startThinking(e *events.MessageCreate, msgId snowflake.ID) chan struct{} {
 	return stopThinking
 }
 
-func respondToQuestion(e *events.MessageCreate, thinkingMsgId snowflake.ID, reasoningHeader, response, reasoning string) {
-	if reasoning == "" {
+func respondToQuestion(e *events.MessageCreate, thinkingMsgId snowflake.ID, reasoningHeader string, result answer) {
+	response := fmt.Sprintf("%s\n-# answered by %s", result.Content, result.Model)
+	if len(result.tools) > 0 {
+		response += " using " + strings.Join(result.tools, ", ")
+	}
+	if result.Reasoning == "" {
 		util.EditMessageWithError(e, thinkingMsgId, response, "failed to respond to question")
 		return
 	}
 
-	fullReasoning := fmt.Sprintf(reasoningHeader, reasoning)
+	fullReasoning := fmt.Sprintf(reasoningHeader, result.Reasoning)
 
 	if len(fullReasoning) >= 2000 {
 		util.EditMessageWithError(e, thinkingMsgId, "We thought so hard we can't even show it in chat...", "failed to provide reasoning")
@@ -127,49 +164,12 @@ func respondToQuestion(e *events.MessageCreate, thinkingMsgId snowflake.ID, reas
 	util.SendMessageWithError(e, e.ChannelID, response, "failed to respond to question")
 }
 
-func openRouterRequest(opts modelOpts) (string, string, error) {
-	msgs := []openrouter.ChatCompletionMessage{
-		{
-			Role:    openrouter.ChatMessageRoleUser,
-			Content: openrouter.Content{Text: opts.systemPrompt},
-		},
-	}
-
-	msgs = append(msgs, openrouter.ChatCompletionMessage{
-		Role:    openrouter.ChatMessageRoleSystem,
-		Content: openrouter.Content{Text: opts.prompt},
-	})
-
-	client := openrouter.NewClient(
-		os.Getenv("OPEN_ROUTER_KEY"),
-	)
-	resp, err := client.CreateChatCompletion(
-		context.Background(),
-		openrouter.ChatCompletionRequest{
-			Model: opts.model,
-			Reasoning: &openrouter.ChatCompletionReasoning{
-				Effort: toPtr("medium"),
-			},
-			Messages: msgs,
-			WebSearchOptions: &openrouter.WebSearchOptions{
-				SearchContextSize: openrouter.SearchContextSizeHigh,
-			},
-		},
-	)
-	if err != nil {
-		return "", "", fmt.Errorf("failed to send open router request: %v", err)
-	}
-
-	response := resp.Choices[0].Message
-
-	return response.Content.Text, fromPtr(response.Reasoning), nil
-}
-
 const marcusSystemPrompt = systemPrompt + `
 Write Marcus's next reply in a fictional chat between Marcus and {{user}}. Write 1 reply only, avoid quotation marks. 
 Be proactive, creative, and respond directly to the question. Write at least 2 words, and up two sentences. 
 Always stay in character and avoid repetition. Don't use many large or complex words in your response. Do NOT include any actions in your response. 
 If it makes sense, you can include the users name in the response. You should only do this occasionally, and when it makes sense.
+The people in the voice channel are {{voice_members}}, and the memes played recently were {{recent_memes}}. You can bring them up if it's funny.
 
 Marcus is irreverent, funny, and inappropriate. He only speaks in very short responses, and is very blunt. He can also be considered 'edgey' or vulgar. 
 At times he speaks with great wisdom, but often mentions menial things in the process. He can be crass and has a somewhat aloof personality. 
@@ -208,6 +208,7 @@ Marcus: The wendy's dumpster out back.
 
 const systemPrompt = `
 Please respond to this question in 2000 characters or less. You CANNOT mention that you have to respect this rule in your response in any way.
+You are chatting with {{user}} in #{{channel}} on the {{server}} Discord server. It is {{time}}.
 
 `
 
@@ -0,0 +1,643 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"marcus/pkg/config"
+	"marcus/pkg/fake"
+	"marcus/pkg/ratelimit"
+	"marcus/pkg/settings"
+	"marcus/pkg/tts"
+	"marcus/pkg/usage"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// FakeLLM answers with scripted replies for each model, recording every request.
+type FakeLLM struct {
+	sync.Mutex
+	// Replies are used in order for each model, the last one repeating.
+	Replies  map[string][]FakeReply
+	Requests []ChatRequest
+}
+
+type FakeReply struct {
+	Response ChatResponse
+	Err      error
+}
+
+func (f *FakeLLM) Name() string {
+	return "fake"
+}
+
+func (f *FakeLLM) Complete(_ context.Context, req ChatRequest) (ChatResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	attempt := 0
+	for _, r := range f.Requests {
+		if r.Model == req.Model {
+			attempt++
+		}
+	}
+	f.Requests = append(f.Requests, req)
+
+	replies := f.Replies[req.Model]
+	if len(replies) == 0 {
+		return ChatResponse{}, &StatusError{StatusCode: http.StatusNotFound, Err: errors.New("no such model")}
+	}
+	reply := replies[min(attempt, len(replies)-1)]
+	return reply.Response, reply.Err
+}
+
+func (f *FakeLLM) models() []string {
+	f.Lock()
+	defer f.Unlock()
+
+	models := make([]string, 0, len(f.Requests))
+	for _, r := range f.Requests {
+		models = append(models, r.Model)
+	}
+	return models
+}
+
+const (
+	testGuildID   snowflake.ID = 10
+	testChannelID snowflake.ID = 20
+	testUserID    snowflake.ID = 30
+)
+
+// askTest is a command asking a fake LLM a question in a fake Discord.
+type askTest struct {
+	c         *Command
+	llm       *FakeLLM
+	discord   *fake.Discord
+	generator *fake.Generator
+	aiUsage   *usage.RequestLog
+}
+
+// testPromptVariables are what prompts are filled with in tests, where
+// nothing is cached.
+var testPromptVariables = map[string]string{
+	"user":          "tester",
+	"time":          "Sunday, June 1 2025, 8:30 PM UTC",
+	"server":        "unknown",
+	"channel":       "unknown",
+	"voice_members": "nobody",
+	"recent_memes":  "none",
+}
+
+func newAskTest(t *testing.T, marcus bool, replies map[string][]FakeReply, configure func(*config.Config)) *askTest {
+	retryBackoff = time.Millisecond
+	promptNow = func() time.Time { return time.Date(2025, time.June, 1, 20, 30, 0, 0, time.UTC) }
+
+	dir := t.TempDir()
+	logger := slog.New(slog.DiscardHandler)
+
+	cfg := config.Default()
+	cfg.Storage.AudioDir = dir
+	cfg.AI.General = config.PersonaConfig{Backend: config.BackendOpenAI, Models: []string{"general-a", "general-b"}}
+	cfg.AI.Marcus = config.PersonaConfig{Backend: config.BackendOpenAI, Models: []string{"marcus-a", "marcus-b"}}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	guildSettings, err := settings.NewStore(dir, logger)
+	if err != nil {
+		t.Fatalf("failed to create settings store: %v", err)
+	}
+
+	aiUsage, err := usage.NewRequestLog(filepath.Join(dir, "usage.jsonl"), logger)
+	if err != nil {
+		t.Fatalf("failed to create request log: %v", err)
+	}
+
+	a := &askTest{
+		llm:       &FakeLLM{Replies: replies},
+		discord:   fake.NewDiscord(t),
+		generator: &fake.Generator{Err: errors.New("fake generator can't make audio")},
+		aiUsage:   aiUsage,
+	}
+
+	command := "!ai what is the answer"
+	if marcus {
+		command = "!marcus what is the answer"
+	}
+	a.c = &Command{
+		Logger:        logger,
+		Config:        config.NewStaticStore(cfg),
+		TTS:           &tts.TTS{Config: config.NewStaticStore(cfg), Logger: logger, Generators: []tts.Generator{a.generator}},
+		GuildSettings: guildSettings,
+		AIUsage:       aiUsage,
+		LLMs:          map[string]LLM{config.BackendOpenAI: a.llm},
+		TTSOpts:       tts.Opts{Content: "what is the answer"},
+		MessageEvent:  a.discord.MessageCreate(t, testGuildID, testChannelID, testUserID, command),
+	}
+	a.c.guild = guildSettings.Get(testGuildID)
+	a.c.TTS.UseGuildSettings(a.c.guild)
+
+	return a
+}
+
+func (a *askTest) setPersona(t *testing.T, persona string) {
+	if _, err := a.c.GuildSettings.Set(testGuildID, settings.KeyPersona, persona); err != nil {
+		t.Fatalf("failed to set persona: %v", err)
+	}
+	a.c.guild = a.c.GuildSettings.Get(testGuildID)
+}
+
+func (a *askTest) ask(marcus bool) {
+	if marcus {
+		a.c.AskMarcusQuestion()
+	} else {
+		a.c.AskAIQuestion()
+	}
+}
+
+func TestAskQuestion(t *testing.T) {
+	const (
+		guildID = testGuildID
+		userID  = testUserID
+	)
+
+	rateLimited := &StatusError{StatusCode: http.StatusTooManyRequests, Err: errors.New("rate limited")}
+	serverError := &StatusError{StatusCode: http.StatusBadGateway, Err: errors.New("bad gateway")}
+	badKey := &StatusError{StatusCode: http.StatusUnauthorized, Err: errors.New("bad key")}
+	answer := func(content string) []FakeReply {
+		return []FakeReply{{Response: ChatResponse{Content: content, PromptTokens: 5, CompletionTokens: 7, Cost: 0.01}}}
+	}
+
+	tests := []struct {
+		name    string
+		marcus  bool
+		persona string
+		retries int
+		replies map[string][]FakeReply
+
+		wantModels   []string
+		wantMessages []string
+		wantSpoken   string
+		wantPrompt   string
+		wantRecorded bool
+	}{
+		{
+			name:         "general answers with the first model",
+			replies:      map[string][]FakeReply{"general-a": answer("forty two")},
+			wantModels:   []string{"general-a"},
+			wantMessages: []string{"forty two\n-# answered by general-a"},
+			wantSpoken:   "forty two",
+			wantPrompt:   systemPrompt,
+			wantRecorded: true,
+		},
+		{
+			name:   "marcus answers with reasoning",
+			marcus: true,
+			replies: map[string][]FakeReply{"marcus-a": {{Response: ChatResponse{
+				Content:   "not enough team flashes",
+				Reasoning: "hmm",
+				Model:     "marcus-a-2025",
+			}}}},
+			wantModels: []string{"marcus-a"},
+			wantMessages: []string{
+				"Marcus Thought: ||```hmm```||",
+				"not enough team flashes\n-# answered by marcus-a-2025",
+			},
+			wantSpoken:   "not enough team flashes",
+			wantPrompt:   marcusSystemPrompt,
+			wantRecorded: true,
+		},
+		{
+			name:         "marcus uses the guild persona",
+			marcus:       true,
+			persona:      "You are a pirate talking to {{user}}.",
+			replies:      map[string][]FakeReply{"marcus-a": answer("arr")},
+			wantModels:   []string{"marcus-a"},
+			wantMessages: []string{"arr\n-# answered by marcus-a"},
+			wantSpoken:   "arr",
+			wantPrompt:   systemPrompt + "You are a pirate talking to {{user}}.",
+			wantRecorded: true,
+		},
+		{
+			name:    "rate limited model is retried then skipped",
+			retries: 1,
+			replies: map[string][]FakeReply{
+				"general-a": {{Err: rateLimited}},
+				"general-b": answer("second time lucky"),
+			},
+			wantModels:   []string{"general-a", "general-a", "general-b"},
+			wantMessages: []string{"second time lucky\n-# answered by general-b"},
+			wantSpoken:   "second time lucky",
+			wantPrompt:   systemPrompt,
+			wantRecorded: true,
+		},
+		{
+			name:    "server error recovers on retry",
+			retries: 2,
+			replies: map[string][]FakeReply{
+				"general-a": append([]FakeReply{{Err: serverError}}, answer("back up")...),
+			},
+			wantModels:   []string{"general-a", "general-a"},
+			wantMessages: []string{"back up\n-# answered by general-a"},
+			wantSpoken:   "back up",
+			wantPrompt:   systemPrompt,
+			wantRecorded: true,
+		},
+		{
+			name:    "empty answer moves to the next model",
+			retries: 2,
+			replies: map[string][]FakeReply{
+				"general-a": answer("  "),
+				"general-b": answer("something"),
+			},
+			wantModels:   []string{"general-a", "general-b"},
+			wantMessages: []string{"something\n-# answered by general-b"},
+			wantSpoken:   "something",
+			wantPrompt:   systemPrompt,
+			wantRecorded: true,
+		},
+		{
+			name:    "bad api key stops at once",
+			retries: 2,
+			replies: map[string][]FakeReply{
+				"general-a": {{Err: badKey}},
+				"general-b": answer("never asked"),
+			},
+			wantModels:   []string{"general-a"},
+			wantMessages: []string{"Couldn't get an answer from any model: status 401: bad key"},
+			wantPrompt:   systemPrompt,
+		},
+		{
+			name: "every model failing is reported",
+			replies: map[string][]FakeReply{
+				"general-a": {{Err: serverError}},
+				"general-b": {{Err: rateLimited}},
+			},
+			wantModels:   []string{"general-a", "general-b"},
+			wantMessages: []string{"Couldn't get an answer from any model: general-a: status 502: bad gateway\ngeneral-b: status 429: rate limited"},
+			wantPrompt:   systemPrompt,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAskTest(t, tt.marcus, tt.replies, func(cfg *config.Config) {
+				cfg.AI.Retries = tt.retries
+			})
+			if tt.persona != "" {
+				a.setPersona(t, tt.persona)
+			}
+			llm, discordAPI, generator, aiUsage := a.llm, a.discord, a.generator, a.aiUsage
+
+			a.ask(tt.marcus)
+
+			if got := llm.models(); !slices.Equal(got, tt.wantModels) {
+				t.Errorf("models asked = %q, want %q", got, tt.wantModels)
+			}
+
+			// the first message is the thinking message, edited with the result
+			sent := discordAPI.Sent()
+			if tt.wantSpoken != "" {
+				// the fake generator's failure is reported last
+				sent = sent[:len(sent)-1]
+			}
+			if !slices.Equal(sent, tt.wantMessages) {
+				t.Errorf("messages = %q, want %q", sent, tt.wantMessages)
+			}
+
+			if len(llm.Requests) > 0 {
+				messages := llm.Requests[0].Messages
+				want := []ChatMessage{{Role: RoleSystem, Content: fillPrompt(tt.wantPrompt, testPromptVariables)}, {Role: RoleUser, Content: "what is the answer"}}
+				if !reflect.DeepEqual(messages, want) {
+					t.Errorf("prompt = %q, want %q", messages, want)
+				}
+			}
+
+			var wantSpoken []string
+			if tt.wantSpoken != "" {
+				wantSpoken = []string{tt.wantSpoken}
+			}
+			if !slices.Equal(generator.Spoken(), wantSpoken) {
+				t.Errorf("spoken = %q, want %q", generator.Spoken(), wantSpoken)
+			}
+
+			totals := aiUsage.Sum(time.Time{}, func(r usage.Request) bool { return r.GuildID == guildID && r.UserID == userID })
+			if recorded := totals.Requests == 1; recorded != tt.wantRecorded {
+				t.Errorf("usage recorded = %v, want %v", recorded, tt.wantRecorded)
+			}
+		})
+	}
+}
+
+func TestOpenAICompatibleLLM(t *testing.T) {
+	var got openAIChatRequest
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		if got.Model == "missing" {
+			http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"model":"local-7b","choices":[{"message":{"role":"assistant","content":"hi","reasoning_content":"thinking"}}],"usage":{"prompt_tokens":3,"completion_tokens":1}}`)
+	}))
+	defer server.Close()
+
+	llm := OpenAICompatibleLLM{BaseURL: server.URL + "/v1/", APIKey: "secret"}
+	resp, err := llm.Complete(context.Background(), ChatRequest{
+		Model:    "local",
+		Messages: []ChatMessage{{Role: RoleSystem, Content: "be nice"}, {Role: RoleUser, Content: "hello"}},
+	})
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+
+	want := ChatResponse{Content: "hi", Reasoning: "thinking", Model: "local-7b", PromptTokens: 3, CompletionTokens: 1}
+	if !reflect.DeepEqual(resp, want) {
+		t.Errorf("Complete() = %+v, want %+v", resp, want)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Authorization = %q, want %q", auth, "Bearer secret")
+	}
+	if got.Model != "local" || len(got.Messages) != 2 || got.Messages[1].Content != "hello" {
+		t.Errorf("request = %+v", got)
+	}
+
+	_, err = llm.Complete(context.Background(), ChatRequest{Model: "missing"})
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Complete() error = %v, want a 404 StatusError", err)
+	}
+}
+
+func TestAskQuestionTools(t *testing.T) {
+	call := func(name, args string) FakeReply {
+		return FakeReply{Response: ChatResponse{ToolCalls: []ToolCall{{ID: "call-" + name, Name: name, Arguments: args}}, PromptTokens: 2}}
+	}
+	answer := FakeReply{Response: ChatResponse{Content: "done", PromptTokens: 5}}
+
+	tests := []struct {
+		name      string
+		configure func(*config.Config)
+		replies   []FakeReply
+
+		wantOffered     []bool
+		wantToolResults []string
+		wantMessage     string
+		wantVoice       string
+		wantTokens      int
+	}{
+		{
+			name:            "set_voice changes the spoken voice",
+			replies:         []FakeReply{call("set_voice", `{"voice":"Tim"}`), answer},
+			wantOffered:     []bool{true, true},
+			wantToolResults: []string{"your answer will be spoken as tim"},
+			wantMessage:     "done\n-# answered by general-a using set_voice",
+			wantVoice:       "tim",
+			wantTokens:      7,
+		},
+		{
+			name:            "tools stop being offered at the cap",
+			configure:       func(cfg *config.Config) { cfg.AI.MaxToolCalls = 1 },
+			replies:         []FakeReply{call("set_voice", `{"voice":"tim"}`), answer},
+			wantOffered:     []bool{true, false},
+			wantToolResults: []string{"your answer will be spoken as tim"},
+			wantMessage:     "done\n-# answered by general-a using set_voice",
+			wantVoice:       "tim",
+			wantTokens:      7,
+		},
+		{
+			name:            "tools not in ai.tools can't be run",
+			configure:       func(cfg *config.Config) { cfg.AI.Tools = []string{"set_voice"} },
+			replies:         []FakeReply{call("get_joke", ``), answer},
+			wantOffered:     []bool{true, true},
+			wantToolResults: []string{"there's no tool called 'get_joke'"},
+			wantMessage:     "done\n-# answered by general-a",
+			wantVoice:       tts.DefaultVoice,
+			wantTokens:      7,
+		},
+		{
+			name:            "bad arguments are told to the model",
+			replies:         []FakeReply{call("set_voice", `{"voice":5}`), answer},
+			wantOffered:     []bool{true, true},
+			wantToolResults: []string{"error: invalid arguments: json: cannot unmarshal number into Go struct field .voice of type string"},
+			wantMessage:     "done\n-# answered by general-a using set_voice",
+			wantVoice:       tts.DefaultVoice,
+			wantTokens:      7,
+		},
+		{
+			name:        "models without tool support answer without them",
+			replies:     []FakeReply{{Err: &StatusError{StatusCode: http.StatusNotFound, Err: errors.New("no endpoints support tool use")}}, answer},
+			wantOffered: []bool{true, false},
+			wantMessage: "done\n-# answered by general-a",
+			wantVoice:   tts.DefaultVoice,
+			wantTokens:  5,
+		},
+		{
+			name:            "usage before a failure is recorded",
+			replies:         []FakeReply{call("set_voice", `{"voice":"tim"}`), {Err: &StatusError{StatusCode: http.StatusPaymentRequired, Err: errors.New("out of credits")}}},
+			wantOffered:     []bool{true, true},
+			wantToolResults: []string{"your answer will be spoken as tim"},
+			wantMessage:     "Couldn't get an answer from any model: status 402: out of credits",
+			wantVoice:       "tim",
+			wantTokens:      2,
+		},
+		{
+			name:            "questions aren't asked again after using a tool",
+			configure:       func(cfg *config.Config) { cfg.AI.Retries = 2 },
+			replies:         []FakeReply{call("set_voice", `{"voice":"tim"}`), {Err: &StatusError{StatusCode: http.StatusBadGateway, Err: errors.New("bad gateway")}}, answer},
+			wantOffered:     []bool{true, true},
+			wantToolResults: []string{"your answer will be spoken as tim"},
+			wantMessage:     "Couldn't get an answer from any model: general-a: status 502: bad gateway",
+			wantVoice:       "tim",
+			wantTokens:      2,
+		},
+		{
+			name:            "empty answers after using a tool don't fall back",
+			replies:         []FakeReply{call("set_voice", `{"voice":"tim"}`), {Response: ChatResponse{Content: " ", PromptTokens: 5}}},
+			wantOffered:     []bool{true, true},
+			wantToolResults: []string{"your answer will be spoken as tim"},
+			wantMessage:     "Couldn't get an answer from any model: general-a: the model returned an empty answer",
+			wantVoice:       "tim",
+			wantTokens:      7,
+		},
+		{
+			name:        "no tools are offered when max_tool_calls is 0",
+			configure:   func(cfg *config.Config) { cfg.AI.MaxToolCalls = 0 },
+			replies:     []FakeReply{answer},
+			wantOffered: []bool{false},
+			wantMessage: "done\n-# answered by general-a",
+			wantVoice:   tts.DefaultVoice,
+			wantTokens:  5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAskTest(t, false, map[string][]FakeReply{"general-a": tt.replies}, tt.configure)
+
+			a.ask(false)
+
+			var offered []bool
+			for _, req := range a.llm.Requests {
+				offered = append(offered, len(req.Tools) > 0)
+			}
+			if !slices.Equal(offered, tt.wantOffered) {
+				t.Errorf("tools offered = %v, want %v", offered, tt.wantOffered)
+			}
+
+			var results []string
+			last := a.llm.Requests[len(a.llm.Requests)-1]
+			for _, m := range last.Messages {
+				if m.Role == RoleTool {
+					results = append(results, m.Content)
+				}
+			}
+			if !slices.Equal(results, tt.wantToolResults) {
+				t.Errorf("tool results = %q, want %q", results, tt.wantToolResults)
+			}
+
+			if sent := a.discord.Sent(); len(sent) == 0 || sent[0] != tt.wantMessage {
+				t.Errorf("messages = %q, want the answer %q first", sent, tt.wantMessage)
+			}
+			if a.c.TTS.Voice != tt.wantVoice {
+				t.Errorf("voice = %q, want %q", a.c.TTS.Voice, tt.wantVoice)
+			}
+
+			totals := a.aiUsage.Sum(time.Time{}, func(usage.Request) bool { return true })
+			if totals.PromptTokens != tt.wantTokens {
+				t.Errorf("prompt tokens recorded = %d, want %d", totals.PromptTokens, tt.wantTokens)
+			}
+		})
+	}
+}
+
+func TestFillPrompt(t *testing.T) {
+	tests := []struct {
+		name   string
+		prompt string
+		vars   map[string]string
+		want   string
+	}{
+		{
+			name:   "every variable is replaced",
+			prompt: "{{user}} asked in #{{channel}} on {{server}}, {{user}} again",
+			vars:   map[string]string{"user": "bob", "channel": "general", "server": "the lab"},
+			want:   "bob asked in #general on the lab, bob again",
+		},
+		{
+			name:   "unknown variables are left alone",
+			prompt: "hi {{user}}, {{nope}} and {user}",
+			vars:   map[string]string{"user": "bob"},
+			want:   "hi bob, {{nope}} and {user}",
+		},
+		{
+			name:   "values aren't filled again",
+			prompt: "{{user}}",
+			vars:   map[string]string{"user": "{{server}}", "server": "the lab"},
+			want:   "{{server}}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fillPrompt(tt.prompt, tt.vars); got != tt.want {
+				t.Errorf("fillPrompt() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPromptVariablesRecentMemes(t *testing.T) {
+	a := newAskTest(t, true, nil, nil)
+	a.c.MemeSet = &MemeSet{}
+	for _, meme := range []string{"a", "b", "c", "d", "e", "f"} {
+		a.c.MemeSet.Played(testGuildID, meme)
+	}
+	a.c.MemeSet.Played(testGuildID+1, "other server")
+
+	if got, want := a.c.promptVariables()["recent_memes"], "b, c, d, e, f"; got != want {
+		t.Errorf("recent_memes = %q, want %q", got, want)
+	}
+}
+
+func TestPromptVariablesChannel(t *testing.T) {
+	a := newAskTest(t, false, nil, nil)
+	a.discord.AddTextChannel(testGuildID, testChannelID, "general")
+
+	if got, want := a.c.promptVariables()["channel"], "general"; got != want {
+		t.Errorf("channel = %q, want %q", got, want)
+	}
+}
+
+func TestAskRateLimits(t *testing.T) {
+	tests := []struct {
+		name         string
+		asks         int
+		wantModels   int
+		wantSpoken   int
+		wantMessages []string
+	}{
+		{name: "an uncached answer only takes one costly token", asks: 1, wantModels: 1, wantSpoken: 1, wantMessages: []string{"forty two\n-# answered by general-a"}},
+		{name: "the next question is limited", asks: 2, wantModels: 1, wantSpoken: 1, wantMessages: []string{"forty two\n-# answered by general-a", "Slow down! Try again in 60s"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAskTest(t, false, map[string][]FakeReply{"general-a": {{Response: ChatResponse{Content: "forty two"}}}}, func(cfg *config.Config) {
+				cfg.RateLimits.Cheap.User = config.RateLimit{Burst: 2, Every: config.Duration(time.Minute)}
+				cfg.RateLimits.Costly.User = config.RateLimit{Burst: 1, Every: config.Duration(time.Minute)}
+			})
+			a.discord.AddTextChannel(testGuildID, testChannelID, "general")
+			a.discord.AddVoiceChannel(testGuildID, testGeneralID, "General")
+			a.discord.JoinVoice(testGuildID, testUserID, testGeneralID)
+			a.generator.Provider = tts.ElevenLabsGeneratorName
+			a.generator.Err = nil
+
+			voice := fake.NewVoiceManager()
+			connections := tts.NewConnectionManager(slog.New(slog.DiscardHandler), voice, a.c.Config)
+			connections.Encode = fake.Encode
+			t.Cleanup(func() { _ = connections.Shutdown(context.Background()) })
+			a.c.TTS.Connections = connections
+			a.c.Limiter = ratelimit.New()
+
+			for range tt.asks {
+				c := *a.c
+				c.MessageEvent = a.discord.MessageCreate(t, testGuildID, testChannelID, testUserID, "!ask-ai what is the answer")
+				if err := c.Build().Execute(); err != nil {
+					t.Fatalf("failed to execute: %v", err)
+				}
+			}
+			voice.WaitForClips(t, tt.wantSpoken)
+
+			if models := a.llm.models(); len(models) != tt.wantModels {
+				t.Errorf("asked %d models, want %d", len(models), tt.wantModels)
+			}
+			if spoken := a.generator.Spoken(); len(spoken) != tt.wantSpoken {
+				t.Errorf("spoke %q, want %d answers", spoken, tt.wantSpoken)
+			}
+			if sent := a.discord.Sent(); !slices.Equal(sent, tt.wantMessages) {
+				t.Errorf("messages = %q, want %q", sent, tt.wantMessages)
+			}
+
+			// a question turned away by the costly budget doesn't spend a cheap token
+			if _, ok := a.c.Limiter.Allow(a.c.rateLimits("cheap", a.c.Config.Get().RateLimits.Cheap, settings.GroupAI)...); !ok {
+				t.Error("the cheap budget was spent")
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package pkg
+
+import (
+	"fmt"
+	"maps"
+	"marcus/pkg/tts"
+	"marcus/pkg/util"
+	"slices"
+	"strings"
+)
+
+// CacheStats shows how much TTS audio has been cached for each provider.
+func (c *Command) CacheStats() {
+	stats, err := tts.GetCacheStats(c.Config.Get().Storage.AudioDir, c.Logger)
+	if err != nil {
+		c.Logger.Error("failed to get cache stats", "err", err)
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("failed to get cache stats: %v", err), "failed to get cache stats")
+		return
+	}
+
+	b := strings.Builder{}
+	b.WriteString(fmt.Sprintf("Cached TTS: %d files, %.1f MB\n", stats.TotalFiles, float64(stats.TotalSize)/(1024*1024)))
+
+	for _, name := range slices.Sorted(maps.Keys(stats.ProviderStats)) {
+		provider := stats.ProviderStats[name]
+		b.WriteString(fmt.Sprintf("\n%s: %d files, %.1f MB\n", name, provider.FileCount, float64(provider.TotalSize)/(1024*1024)))
+		for _, voice := range slices.Sorted(maps.Keys(provider.VoiceStats)) {
+			b.WriteString(fmt.Sprintf("	- %s: %d files\n", voice, provider.VoiceStats[voice]))
+		}
+	}
+
+	util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("```\n%s```", b.String()), "failed to send cache stats")
+}
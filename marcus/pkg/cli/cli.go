@@ -0,0 +1,461 @@
+// Package cli is Marcus's command-line mode, for managing the TTS cache and
+// memes on disk and trying the generators without connecting to Discord.
+package cli
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"marcus/pkg"
+	"marcus/pkg/config"
+	"marcus/pkg/tts"
+	"marcus/pkg/util"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const Usage = `Usage: marcus [-config file] [command]
+
+With no command Marcus runs the bot. Commands:
+
+  tts render [-voice name] [-o out.wav] <text>   generate a line, using the cache
+  cache stats                                    cached lines by provider and voice
+  cache search <text>                            find cached lines
+  cache prune [-older-than 720h] [-dry-run]      remove leftovers and old lines
+  cache verify                                   check the cache against its metadata
+  cache export [-provider p] [-voice v]          bundle cached lines with a manifest, -o - for stdout
+               [-since 2025-01-01] [-until 2025-02-01] [-search text] [-o cache.tar.gz]
+  cache import <bundle.tar.gz>                   merge a bundle into the cache, - for stdin
+  memes list                                     list memes and their files
+  memes import [-replace] <dir>                  copy the .wav files in dir into the memes
+  prewarm [-voice name] [-concurrency 2]         generate every phrase in file that isn't cached
+          [-max-characters 10000] <file>
+`
+
+var (
+	// errUsage is returned for commands that don't exist or are missing arguments.
+	errUsage   = errors.New("invalid command")
+	errBadDate = errors.New("dates look like 2025-01-31")
+)
+
+// CLI runs one command against the config's storage.
+type CLI struct {
+	Config *config.Store
+	Logger *slog.Logger
+	In     io.Reader
+	Out    io.Writer
+	// Err is for reports when Out is taken by a bundle.
+	Err io.Writer
+}
+
+// Main runs the command in args, returning the exit code.
+func Main(configPath string, args []string) int {
+	cfg, err := config.LoadOffline(configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		return 1
+	}
+
+	level := slog.LevelWarn
+	if cfg.Debug {
+		level = slog.LevelDebug
+	}
+	c := &CLI{
+		Config: config.NewStaticStore(cfg),
+		Logger: slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})),
+		In:     os.Stdin,
+		Out:    os.Stdout,
+		Err:    os.Stderr,
+	}
+
+	if err := c.Run(args); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprint(os.Stderr, Usage)
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "marcus: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// Run runs a command, such as "cache stats".
+func (c *CLI) Run(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	sub := ""
+	if len(args) > 1 {
+		sub = args[1]
+	}
+	switch {
+	case args[0] == "tts" && sub == "render":
+		return c.render(args[2:])
+	case args[0] == "cache" && sub == "stats":
+		return c.cacheStats()
+	case args[0] == "cache" && sub == "search":
+		return c.cacheSearch(args[2:])
+	case args[0] == "cache" && sub == "prune":
+		return c.cachePrune(args[2:])
+	case args[0] == "cache" && sub == "verify":
+		return c.cacheVerify()
+	case args[0] == "cache" && sub == "export":
+		return c.cacheExport(args[2:])
+	case args[0] == "cache" && sub == "import":
+		return c.cacheImport(args[2:])
+	case args[0] == "memes" && sub == "list":
+		return c.memesList()
+	case args[0] == "memes" && sub == "import":
+		return c.memesImport(args[2:])
+	case args[0] == "prewarm":
+		return c.prewarm(args[1:])
+	}
+	return errUsage
+}
+
+func newFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	return flags
+}
+
+// parse parses flags wherever they are among the arguments, returning the
+// arguments that aren't flags.
+func parse(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, fmt.Errorf("%w: %v", errUsage, err)
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func (c *CLI) audioDir() string {
+	return c.Config.Get().Storage.AudioDir
+}
+
+// newTTS sets up the generators, speaking in voice.
+func (c *CLI) newTTS(voice string) (*tts.TTS, error) {
+	t, err := tts.NewTTS(c.Logger, c.Config, nil)
+	if err != nil {
+		return nil, err
+	}
+	t.Voice = cmp.Or(voice, tts.DefaultVoice)
+	if _, err := t.GetGeneratorForVoice(t.Voice); err != nil {
+		return nil, fmt.Errorf("can't speak as '%s', try one of: %v", t.Voice, t.ListSupportedVoiceNames())
+	}
+	return t, nil
+}
+
+func (c *CLI) render(args []string) error {
+	flags := newFlags("tts render")
+	voice := flags.String("voice", tts.DefaultVoice, "voice to speak in")
+	out := flags.String("o", "out.wav", "file to write")
+	text, err := parse(flags, args)
+	if err != nil || len(text) != 1 {
+		return errUsage
+	}
+
+	t, err := c.newTTS(*voice)
+	if err != nil {
+		return err
+	}
+	effects, content, err := tts.ParseEffects(text[0])
+	if err != nil {
+		return fmt.Errorf("invalid effects: %w", err)
+	}
+
+	audio, err := t.Generate(t.Voice, content, effects)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, audio, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", *out, err)
+	}
+	fmt.Fprintf(c.Out, "wrote %s (%s)\n", *out, util.FormatBytes(int64(len(audio))))
+	return nil
+}
+
+func (c *CLI) cacheStats() error {
+	stats, err := tts.GetCacheStats(c.audioDir(), c.Logger)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.Out, "%d files, %s\n", stats.TotalFiles, util.FormatBytes(stats.TotalSize))
+	for _, name := range slices.Sorted(maps.Keys(stats.ProviderStats)) {
+		provider := stats.ProviderStats[name]
+		fmt.Fprintf(c.Out, "\n%s: %d files, %s, last cached %s\n", name, provider.FileCount, util.FormatBytes(provider.TotalSize), provider.LastUsed.Local().Format(time.DateTime))
+		for _, voice := range slices.Sorted(maps.Keys(provider.VoiceStats)) {
+			fmt.Fprintf(c.Out, "  %s: %d files\n", voice, provider.VoiceStats[voice])
+		}
+	}
+	return nil
+}
+
+func (c *CLI) cacheSearch(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	lines, err := tts.ListCache(c.audioDir(), args[0], c.Logger)
+	if err != nil {
+		return err
+	}
+	for _, line := range lines {
+		fmt.Fprintf(c.Out, "%s  %s/%s/%s.wav  %s\n", line.CreatedAt.Local().Format(time.DateTime), line.Provider, line.Voice, line.Hash, line.Text)
+	}
+	fmt.Fprintf(c.Out, "%d lines\n", len(lines))
+	return nil
+}
+
+func (c *CLI) cachePrune(args []string) error {
+	flags := newFlags("cache prune")
+	olderThan := flags.Duration("older-than", 0, "also delete lines cached longer ago than this")
+	dryRun := flags.Bool("dry-run", false, "only report what would be removed")
+	if rest, err := parse(flags, args); err != nil || len(rest) != 0 {
+		return errUsage
+	}
+
+	var before time.Time
+	if *olderThan > 0 {
+		before = time.Now().Add(-*olderThan)
+	}
+	result, err := tts.PruneCache(c.audioDir(), before, *dryRun, c.Logger)
+	if err != nil {
+		return err
+	}
+
+	verb := "removed"
+	if *dryRun {
+		verb = "would remove"
+	}
+	fmt.Fprintf(c.Out, "%s %d temp files, %d entries for missing audio and %d old lines, freeing %s\n", verb, result.TempFiles, result.MissingEntries, result.Expired, util.FormatBytes(result.Freed))
+	return nil
+}
+
+func (c *CLI) cacheVerify() error {
+	problems, err := tts.VerifyCache(c.audioDir())
+	for _, p := range problems {
+		fmt.Fprintf(c.Out, "%s: %s\n", p.Path, p.Problem)
+	}
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problems, `marcus cache prune` removes entries for missing audio", len(problems))
+	}
+	fmt.Fprintln(c.Out, "cache is OK")
+	return nil
+}
+
+func (c *CLI) cacheExport(args []string) error {
+	flags := newFlags("cache export")
+	var filter tts.BundleFilter
+	flags.StringVar(&filter.Provider, "provider", "", "only this provider's lines")
+	flags.StringVar(&filter.Voice, "voice", "", "only this voice's lines")
+	flags.StringVar(&filter.Search, "search", "", "only lines containing this text")
+	since := flags.String("since", "", "only lines cached on or after this date")
+	until := flags.String("until", "", "only lines cached before this date")
+	out := flags.String("o", "cache.tar.gz", "bundle to write, - for stdout")
+	if rest, err := parse(flags, args); err != nil || len(rest) != 0 {
+		return errUsage
+	}
+
+	var err error
+	if filter.Since, err = parseDate(*since); err != nil {
+		return err
+	}
+	if filter.Until, err = parseDate(*until); err != nil {
+		return err
+	}
+
+	var manifest *tts.BundleManifest
+	report := c.Out
+	if *out == "-" {
+		report = c.Err
+		manifest, err = tts.ExportCache(c.audioDir(), filter, c.Out, c.Logger)
+	} else {
+		manifest, err = c.exportFile(*out, filter)
+	}
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, entry := range manifest.Entries {
+		size += entry.FileSize
+	}
+	fmt.Fprintf(report, "exported %d lines, %s of audio\n", len(manifest.Entries), util.FormatBytes(size))
+	return nil
+}
+
+// exportFile writes a bundle to file, removing it if the export fails.
+func (c *CLI) exportFile(file string, filter tts.BundleFilter) (*tts.BundleManifest, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := tts.ExportCache(c.audioDir(), filter, f, c.Logger)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file)
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func (c *CLI) cacheImport(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	r := c.In
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	result, err := tts.ImportCache(c.audioDir(), r, c.Logger)
+	for _, file := range slices.Sorted(maps.Keys(result.Rejected)) {
+		fmt.Fprintf(c.Out, "rejected %s: %s\n", file, result.Rejected[file])
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "imported %d lines, %d already cached, %d rejected\n", result.Imported, result.Duplicates, len(result.Rejected))
+	if len(result.Rejected) > 0 {
+		return fmt.Errorf("%d lines were rejected", len(result.Rejected))
+	}
+	return nil
+}
+
+// parseDate parses a date such as 2025-01-31, or a full RFC 3339 time. Empty
+// is the zero time.
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %q", errBadDate, s)
+	}
+	return t, nil
+}
+
+func (c *CLI) memes() *pkg.MemeSet {
+	return &pkg.MemeSet{Map: &sync.Map{}, Config: c.Config}
+}
+
+func (c *CLI) memesList() error {
+	memes := c.memes()
+	if err := memes.BuildMemeSet(); err != nil {
+		return err
+	}
+
+	root := c.Config.Get().Storage.MemesDir
+	names := []string{}
+	files := map[string]string{}
+	memes.Range(func(key, value any) bool {
+		hit := value.(pkg.MemeHit)
+		file, err := filepath.Rel(root, hit.Path)
+		if err != nil || file == "." {
+			return true
+		}
+		if hit.IsDir {
+			file += "/"
+		}
+		names = append(names, key.(string))
+		files[key.(string)] = file
+		return true
+	})
+	slices.Sort(names)
+
+	for _, name := range names {
+		fmt.Fprintf(c.Out, "!%s  %s\n", name, files[name])
+	}
+	fmt.Fprintf(c.Out, "%d memes\n", len(names))
+	return nil
+}
+
+func (c *CLI) memesImport(args []string) error {
+	flags := newFlags("memes import")
+	replace := flags.Bool("replace", false, "replace memes that already exist")
+	dir, err := parse(flags, args)
+	if err != nil || len(dir) != 1 {
+		return errUsage
+	}
+
+	result, err := c.memes().ImportMemes(dir[0], *replace)
+	for _, file := range slices.Sorted(maps.Keys(result.Skipped)) {
+		fmt.Fprintf(c.Out, "skipped %s: %s\n", file, result.Skipped[file])
+	}
+	for _, name := range result.Imported {
+		fmt.Fprintf(c.Out, "imported !%s\n", name)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "imported %d memes, skipped %d\n", len(result.Imported), len(result.Skipped))
+	return nil
+}
+
+func (c *CLI) prewarm(args []string) error {
+	cfg := c.Config.Get().Prewarm
+	flags := newFlags("prewarm")
+	voice := flags.String("voice", tts.DefaultVoice, "voice for the phrases before any [voice] line")
+	concurrency := flags.Int("concurrency", cfg.Concurrency, "phrases generated at once")
+	maxCharacters := flags.Int("max-characters", cfg.MaxCharacters, "most ElevenLabs characters to generate, 0 for no limit")
+	file, err := parse(flags, args)
+	if err != nil || len(file) != 1 || *concurrency < 1 || *maxCharacters < 0 {
+		return errUsage
+	}
+
+	f, err := os.Open(file[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	phrases, err := tts.ReadPhrases(f, *voice)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	prewarmer := &tts.Prewarmer{Config: c.Config, Logger: c.Logger}
+	result, err := prewarmer.Prewarm(ctx, phrases, tts.PrewarmOptions{Concurrency: *concurrency, MaxCharacters: *maxCharacters})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.Out, "generated %d (%d characters), already cached %d, over the character limit %d, failed %d\n", result.Generated, result.Characters, result.Skipped, result.OverQuota, result.Failed)
+	if result.Failed > 0 {
+		return fmt.Errorf("%d phrases failed, see the log for why", result.Failed)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"marcus/pkg/config"
+	"marcus/pkg/fake"
+	"marcus/pkg/tts"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr error
+	}{
+		{name: "memes list", args: []string{"memes", "list"}, want: "!airhorn  airhorn.wav\n!sounds  sounds/\n!sounds-bruh  sounds/bruh.wav\n3 memes\n"},
+		{name: "flags after arguments", args: []string{"memes", "import", "import", "-replace"}, want: "imported !bonk\nimported 1 memes, skipped 0\n"},
+		{name: "cache verify", args: []string{"cache", "verify"}, want: "cache is OK\n"},
+		{name: "cache prune dry run", args: []string{"cache", "prune", "--dry-run"}, want: "would remove 0 temp files, 0 entries for missing audio and 0 old lines, freeing 0 B\n"},
+		{name: "cache export", args: []string{"cache", "export", "-since", "2025-01-01", "-o", "bundle.tar.gz"}, want: "exported 0 lines, 0 B of audio\n"},
+		{name: "bad date", args: []string{"cache", "export", "-until", "yesterday"}, wantErr: errBadDate},
+		{name: "no command", wantErr: errUsage},
+		{name: "unknown command", args: []string{"cache", "explode"}, wantErr: errUsage},
+		{name: "missing argument", args: []string{"memes", "import"}, wantErr: errUsage},
+		{name: "unknown flag", args: []string{"cache", "prune", "-all"}, wantErr: errUsage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, file := range []string{"memes/airhorn.wav", "memes/sounds/bruh.wav", "import/bonk.wav"} {
+				path := filepath.Join(dir, file)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			t.Chdir(dir)
+
+			cfg := config.Default()
+			cfg.Storage.AudioDir = filepath.Join(dir, "audio")
+			cfg.Storage.MemesDir = filepath.Join(dir, "memes")
+			var out bytes.Buffer
+			c := &CLI{Config: config.NewStaticStore(cfg), Logger: slog.New(slog.DiscardHandler), Out: &out}
+
+			err := c.Run(tt.args)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Run(%q) = %v, want %v", tt.args, err, tt.wantErr)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("Run(%q) printed %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	newCLI := func(dir string) (*CLI, *bytes.Buffer) {
+		var out bytes.Buffer
+		return &CLI{Config: newTestStore(dir), Logger: logger, Out: &out, Err: &out}, &out
+	}
+
+	src, dst := t.TempDir(), t.TempDir()
+	speaker := &tts.TTS{Config: newTestStore(src), Logger: logger, Generators: []tts.Generator{&fake.Generator{}}}
+	for _, text := range []string{"hello", "bye"} {
+		if _, err := speaker.Generate("liam", text, nil); err != nil {
+			t.Fatalf("failed to cache %q: %v", text, err)
+		}
+	}
+
+	bundle := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	export, out := newCLI(src)
+	if err := export.Run([]string{"cache", "export", "-voice", "liam", "-o", bundle}); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	if want := "exported 2 lines, 20 B of audio\n"; out.String() != want {
+		t.Errorf("export printed %q, want %q", out.String(), want)
+	}
+
+	for _, want := range []string{"imported 2 lines, 0 already cached, 0 rejected\n", "imported 0 lines, 2 already cached, 0 rejected\n"} {
+		importer, out := newCLI(dst)
+		if err := importer.Run([]string{"cache", "import", bundle}); err != nil {
+			t.Fatalf("failed to import: %v", err)
+		}
+		if out.String() != want {
+			t.Errorf("import printed %q, want %q", out.String(), want)
+		}
+	}
+}
+
+func newTestStore(audioDir string) *config.Store {
+	cfg := config.Default()
+	cfg.Storage.AudioDir = audioDir
+	return config.NewStaticStore(cfg)
+}
+
+func TestParse(t *testing.T) {
+	flags := newFlags("test")
+	voice := flags.String("voice", "marcus", "")
+	out := flags.String("o", "out.wav", "")
+
+	args, err := parse(flags, []string{"--voice", "liam", "hello there", "-o", "hi.wav"})
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if *voice != "liam" || *out != "hi.wav" || strings.Join(args, "|") != "hello there" {
+		t.Errorf("voice = %q, o = %q, args = %q", *voice, *out, args)
+	}
+}
@@ -0,0 +1,187 @@
+package pkg
+
+import (
+	"fmt"
+	"marcus/pkg/settings"
+	"marcus/pkg/tts"
+	"marcus/pkg/util"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxComboSegments caps how many clips a single !combo or !mix can stitch together.
+const maxComboSegments = 10
+
+const comboUsage = "```\nUsage: !combo [--gap <ms>] [--crossfade <ms>] <clip> <clip> ... - plays clips one after another\n" +
+	"       !mix <clip> <clip> ... - plays clips on top of each other\n\n" +
+	"A clip is either a meme name, or a quoted TTS command like \"v!liam hello\" or \"!marcus hi\".\n\n" +
+	"Example: !combo airhorn mets \"v!liam-fx:chipmunk let's go\"\n```"
+
+func (c *Command) PlayCombo() {
+	args, gapMs, crossfadeMs, err := parseComboOptions(splitQuoted(c.TTSOpts.Content))
+	if err != nil {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("%v\n%s", err, comboUsage), "failed to send usage for combo")
+		return
+	}
+
+	clips, ok := c.loadComboClips(args)
+	if !ok {
+		return
+	}
+
+	c.TTS.Speak(c.request(), tts.Concat(clips, gapMs, crossfadeMs).WAV(), c.TTSOpts.ChannelName)
+}
+
+func (c *Command) PlayMix() {
+	clips, ok := c.loadComboClips(splitQuoted(c.TTSOpts.Content))
+	if !ok {
+		return
+	}
+
+	c.TTS.Speak(c.request(), tts.Mix(clips...).WAV(), c.TTSOpts.ChannelName)
+}
+
+// loadComboClips resolves every argument to decoded audio, replying to the user
+// and returning false if any of them can't be used.
+func (c *Command) loadComboClips(args []string) ([]*tts.PCM, bool) {
+	if len(args) < 2 {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, comboUsage, "failed to send usage for combo")
+		return nil, false
+	}
+	if len(args) > maxComboSegments {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("Too many clips, at most %d can be combined.", maxComboSegments), "failed to send usage for combo")
+		return nil, false
+	}
+
+	var clips []*tts.PCM
+	for _, arg := range args {
+		audio, err := c.loadComboClip(arg)
+		if err != nil {
+			c.Logger.Error("failed to load combo clip", "clip", arg, "err", err)
+			util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("Couldn't use '%s': %v", arg, err), "failed to load combo clip")
+			return nil, false
+		}
+
+		pcm, err := tts.DecodePCM(audio)
+		if err != nil {
+			c.Logger.Error("failed to decode combo clip", "clip", arg, "err", err)
+			util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("Couldn't decode '%s': %v", arg, err), "failed to decode combo clip")
+			return nil, false
+		}
+		clips = append(clips, pcm)
+	}
+
+	return clips, true
+}
+
+// loadComboClip returns the raw audio for a meme name or a TTS command.
+func (c *Command) loadComboClip(arg string) ([]byte, error) {
+	if strings.HasPrefix(arg, "v!") || strings.HasPrefix(arg, "!marcus") || strings.HasPrefix(arg, "!m ") {
+		if !c.allowed(settings.PermTTS) {
+			return nil, fmt.Errorf("you don't have the '%s' permission needed for TTS clips", settings.PermTTS)
+		}
+
+		voice, cmd, _, content, _, err := extractVoiceCommand(arg, c.Generators)
+		if err != nil {
+			return nil, err
+		}
+		flags, content, err := tts.ParseEffects(content)
+		if err != nil {
+			return nil, err
+		}
+		if content == "" {
+			return nil, fmt.Errorf("TTS clips need some text to say")
+		}
+		if maxLength := c.guild.MaxTTSLength(); len(content) >= maxLength {
+			return nil, fmt.Errorf("TTS clips must be less than %d characters", maxLength)
+		}
+		if voice == "" {
+			voice = c.TTS.DefaultVoice()
+		}
+
+		// a segment can have its own preset and flags, "v!liam-fx:chipmunk hi --reverb"
+		var effects tts.EffectChain
+		_, sub := splitCommandAndSubcommand(cmd)
+		if preset, ok := strings.CutPrefix(sub, "fx:"); ok {
+			chain, found := tts.EffectPresets[strings.ToLower(preset)]
+			if !found {
+				return nil, fmt.Errorf("unknown effect preset '%s'", preset)
+			}
+			effects = chain
+		} else if sub != "" {
+			return nil, fmt.Errorf("subcommands can't be used in a combo")
+		}
+		effects = append(slices.Clone(effects), flags...)
+
+		return c.TTS.Generate(strings.ToLower(voice), content, effects)
+	}
+
+	meme, found := c.MemeSet.GetMeme(strings.TrimPrefix(arg, "!"))
+	if !found {
+		return nil, fmt.Errorf("no meme with that name, try !list-memes")
+	}
+	return os.ReadFile(meme)
+}
+
+// parseComboOptions pulls --gap and --crossfade out of the arguments.
+func parseComboOptions(args []string) ([]string, int, int, error) {
+	var rest []string
+	var gapMs, crossfadeMs int
+	for i := 0; i < len(args); i++ {
+		var target *int
+		switch args[i] {
+		case "--gap":
+			target = &gapMs
+		case "--crossfade":
+			target = &crossfadeMs
+		default:
+			rest = append(rest, args[i])
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return nil, 0, 0, fmt.Errorf("%s needs a number of milliseconds", args[i])
+		}
+		ms, err := strconv.Atoi(args[i+1])
+		if err != nil || ms < 0 || ms > 5000 {
+			return nil, 0, 0, fmt.Errorf("%s must be between 0 and 5000 milliseconds", args[i])
+		}
+		*target = ms
+		i++
+	}
+	return rest, gapMs, crossfadeMs, nil
+}
+
+// splitQuoted splits input on whitespace, keeping anything in double quotes
+// (including the curly quotes phones like to insert) together.
+func splitQuoted(input string) []string {
+	var args []string
+	var current strings.Builder
+	inQuotes := false
+
+	flush := func() {
+		if current.Len() > 0 {
+			args = append(args, current.String())
+			current.Reset()
+		}
+	}
+
+	for _, r := range input {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			if inQuotes {
+				flush()
+			}
+			inQuotes = !inQuotes
+		case !inQuotes && (r == ' ' || r == '\t' || r == '\n'):
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	flush()
+
+	return args
+}
@@ -0,0 +1,153 @@
+package pkg
+
+import (
+	"fmt"
+	"log/slog"
+	"marcus/pkg/config"
+	"marcus/pkg/fake"
+	"marcus/pkg/settings"
+	"marcus/pkg/tts"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitQuoted(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{name: "words", input: "airhorn  mets\tbruh\n", want: []string{"airhorn", "mets", "bruh"}},
+		{name: "quotes keep spaces", input: `airhorn "v!liam hello  there" mets`, want: []string{"airhorn", "v!liam hello  there", "mets"}},
+		{name: "curly quotes", input: "“v!liam hi” airhorn", want: []string{"v!liam hi", "airhorn"}},
+		{name: "unclosed quote", input: `airhorn "v!liam hi`, want: []string{"airhorn", "v!liam hi"}},
+		{name: "empty quotes", input: `airhorn "" mets`, want: []string{"airhorn", "mets"}},
+		{name: "nothing", input: "  ", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitQuoted(tt.input); !slices.Equal(got, tt.want) {
+				t.Errorf("splitQuoted(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// newComboTest returns a command from the user, who only has the tts
+// permission if canTTS is set, in a guild with a 20 character TTS limit.
+func newComboTest(t *testing.T, msg string, canTTS bool) (*Command, *fake.Discord) {
+	t.Helper()
+	logger := slog.New(slog.DiscardHandler)
+	dir := t.TempDir()
+	cfg := config.Default()
+	cfg.Storage.AudioDir = dir
+
+	discord := fake.NewDiscord(t)
+	discord.AddTextChannel(testGuildID, testChannelID, "general")
+	discord.AddVoiceChannel(testGuildID, testGeneralID, "General")
+	discord.JoinVoice(testGuildID, testUserID, testGeneralID)
+
+	guildSettings, err := settings.NewStore(dir, logger)
+	if err != nil {
+		t.Fatalf("failed to create settings store: %v", err)
+	}
+	if _, err := guildSettings.Set(testGuildID, settings.KeyMaxTTSLength, "20"); err != nil {
+		t.Fatalf("failed to set max TTS length: %v", err)
+	}
+	permissions, err := settings.NewPermissionStore(dir, logger)
+	if err != nil {
+		t.Fatalf("failed to create permission store: %v", err)
+	}
+	if !canTTS {
+		if err := permissions.Allow(testGuildID, settings.PermTTS, testUserID+1, false); err != nil {
+			t.Fatalf("failed to limit TTS: %v", err)
+		}
+	}
+
+	memes, _ := newTestMemeSet(t, "airhorn.wav", "sounds/bruh.wav")
+	c := &Command{
+		Logger:        logger,
+		Config:        config.NewStaticStore(cfg),
+		TTS:           &tts.TTS{Config: config.NewStaticStore(cfg), Logger: logger, Generators: []tts.Generator{&fake.Generator{Voices: []string{"marcus", "liam"}}}},
+		MemeSet:       memes,
+		GuildSettings: guildSettings,
+		Permissions:   permissions,
+		MessageEvent:  discord.MessageCreate(t, testGuildID, testChannelID, testUserID, msg),
+	}
+	return c.Build(), discord
+}
+
+func TestLoadComboClip(t *testing.T) {
+	tests := []struct {
+		name      string
+		arg       string
+		noTTS     bool
+		wantAudio string
+		wantErr   string
+	}{
+		{name: "meme", arg: "airhorn", wantAudio: "airhorn.wav"},
+		{name: "meme with a prefix", arg: "!sounds-bruh", wantAudio: "sounds/bruh.wav"},
+		{name: "voice", arg: "v!liam hi there", wantAudio: "liam: hi there"},
+		{name: "marcus", arg: "!marcus hi", wantAudio: "marcus: hi"},
+		{name: "not a meme", arg: "nothing", wantErr: "no meme with that name, try !list-memes"},
+		{name: "no text", arg: "v!liam", wantErr: "TTS clips need some text to say"},
+		{name: "too long", arg: "v!liam " + strings.Repeat("a", 20), wantErr: "TTS clips must be less than 20 characters"},
+		{name: "just short enough", arg: "v!liam " + strings.Repeat("a", 19), wantAudio: "liam: " + strings.Repeat("a", 19)},
+		{name: "unknown preset", arg: "v!liam-fx:nope hi", wantErr: "unknown effect preset 'nope'"},
+		{name: "subcommand", arg: "v!liam-list hi", wantErr: "subcommands can't be used in a combo"},
+		{name: "TTS needs the permission", arg: "v!liam hi", noTTS: true, wantErr: fmt.Sprintf("you don't have the '%s' permission needed for TTS clips", settings.PermTTS)},
+		{name: "memes don't need the TTS permission", arg: "airhorn", noTTS: true, wantAudio: "airhorn.wav"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newComboTest(t, "!combo", !tt.noTTS)
+
+			audio, err := c.loadComboClip(tt.arg)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("loadComboClip(%q) error = %v, want %q", tt.arg, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadComboClip(%q) failed: %v", tt.arg, err)
+			}
+			if string(audio) != tt.wantAudio {
+				t.Errorf("loadComboClip(%q) = %q, want %q", tt.arg, audio, tt.wantAudio)
+			}
+		})
+	}
+}
+
+func TestPlayComboRefusals(t *testing.T) {
+	tests := []struct {
+		name        string
+		msg         string
+		noTTS       bool
+		wantMessage string
+	}{
+		{name: "one clip", msg: "!combo airhorn", wantMessage: comboUsage},
+		{name: "too many clips", msg: "!combo" + strings.Repeat(" airhorn", maxComboSegments+1), wantMessage: fmt.Sprintf("Too many clips, at most %d can be combined.", maxComboSegments)},
+		{name: "too many clips to mix", msg: "!mix" + strings.Repeat(" airhorn", maxComboSegments+1), wantMessage: fmt.Sprintf("Too many clips, at most %d can be combined.", maxComboSegments)},
+		{name: "bad gap", msg: "!combo --gap soon airhorn airhorn", wantMessage: "--gap must be between 0 and 5000 milliseconds\n" + comboUsage},
+		{name: "missing crossfade", msg: "!combo airhorn airhorn --crossfade", wantMessage: "--crossfade needs a number of milliseconds\n" + comboUsage},
+		{name: "one bad clip", msg: `!combo "v!liam" airhorn`, wantMessage: "Couldn't use 'v!liam': TTS clips need some text to say"},
+		{name: "TTS clip without the permission", msg: `!combo "v!liam hi" airhorn`, noTTS: true, wantMessage: fmt.Sprintf("Couldn't use 'v!liam hi': you don't have the '%s' permission needed for TTS clips", settings.PermTTS)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, discord := newComboTest(t, tt.msg, !tt.noTTS)
+			if err := c.Execute(); err != nil {
+				t.Fatalf("failed to execute: %v", err)
+			}
+
+			if sent := discord.Sent(); !slices.Equal(sent, []string{tt.wantMessage}) {
+				t.Errorf("messages = %q, want %q", sent, tt.wantMessage)
+			}
+		})
+	}
+}
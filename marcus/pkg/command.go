@@ -1,20 +1,40 @@
 package pkg
 
 import (
+	"cmp"
 	"fmt"
 	"log/slog"
+	"marcus/pkg/config"
+	"marcus/pkg/metrics"
+	"marcus/pkg/ratelimit"
+	"marcus/pkg/settings"
 	"marcus/pkg/tts"
+	"marcus/pkg/usage"
 	"marcus/pkg/util"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/disgoorg/disgo/events"
 )
 
 type Command struct {
 	Logger *slog.Logger
+	Config *config.Store
 
 	*tts.TTS
 	*MemeSet
+	Intros *IntroStore
+
+	GuildSettings *settings.Store
+	Permissions   *settings.PermissionStore
+	Limiter       *ratelimit.Limiter
+
+	ElevenLabsUsage *usage.Ledger
+	AIUsage         *usage.RequestLog
+
+	// LLMs replaces the AI backends built from the config, by backend name.
+	LLMs map[string]LLM
 
 	TTSOpts tts.Opts
 
@@ -24,13 +44,26 @@ type Command struct {
 	ignore            bool
 	action            func()
 	usableOutsideOfVC bool
+	group             string
+	permission        string
+	guild             settings.Guild
 
 	CommandString    string
 	SubcommandString string
 }
 
 func (c *Command) Build() *Command {
-	voice, cmd, channel, content, isTTS, err := c.ExtractCommandParts(c.MessageEvent.Message.Content)
+	if c.MessageEvent.GuildID != nil {
+		c.guild = c.GuildSettings.Get(*c.MessageEvent.GuildID)
+	}
+
+	msg, ok := applyPrefix(c.MessageEvent.Message.Content, c.guild.Prefix())
+	if !ok {
+		c.ignore = true
+		return c
+	}
+
+	voice, cmd, channel, content, isTTS, err := c.ExtractCommandParts(msg)
 	if err != nil {
 		c.Logger.Error(fmt.Sprintf("failed to extract command parts: %v", err))
 		c.err = err
@@ -44,9 +77,17 @@ func (c *Command) Build() *Command {
 		return c
 	}
 
+	if cmd != "config" && !c.inTextChannel() {
+		c.ignore = true
+		return c
+	}
+
 	if c.TTS == nil {
-		c.TTS, _ = tts.NewTTS(c.Logger.With("component", "tts"), c.VoiceManager)
+		c.TTS, _ = tts.NewTTS(c.Logger.With("component", "tts"), c.Config, nil)
 	}
+	c.TTS.UseGuildSettings(c.guild)
+	c.TTS.CanGenerate = c.canGenerate
+	c.TTS.GenerationFailed = c.generationFailed
 
 	c.Logger = c.Logger.With(
 		"guildID", c.MessageEvent.GuildID,
@@ -55,25 +96,34 @@ func (c *Command) Build() *Command {
 		"targetChannel", channel,
 	)
 
+	c.TTSOpts = tts.Opts{Content: content, ChannelName: channel}
+
+	if cmd == "config" {
+		c.action = c.ConfigureSettings
+		c.usableOutsideOfVC = true
+		return c
+	}
+
+	if cmd == "perms" {
+		c.action = c.ConfigurePermissions
+		c.usableOutsideOfVC = true
+		return c
+	}
+
 	if cmd == "list-voices" {
 		c.action = c.ListVoices
+		c.group = settings.GroupTTS
 		c.usableOutsideOfVC = true
 		return c
 	}
 
-	// TODO: this will be dedicated to the UI only.
-	//if cmd == "list-cache" {
-	//	c.action = c.SayCachedFiles
-	//	c.usableOutsideOfVC = true
-	//	return c
-	//}
+	// the cache is listed on the admin dashboard rather than in chat
 
-	// Set voice if provided (v! path) or default will be used later for !marcus path
+	// Set voice if provided (v! path), otherwise the guild's default voice is used
 	if voice != "" {
 		c.TTS.Voice = voice
 	}
 
-	c.TTSOpts = tts.Opts{Content: content, ChannelName: channel}
 	base := cmd
 	sub := ""
 	if idx := strings.Index(cmd, "-"); idx != -1 {
@@ -88,21 +138,46 @@ func (c *Command) Build() *Command {
 	}
 
 	if isTTS {
+		c.group = settings.GroupTTS
+		c.permission = settings.PermTTS
+
+		if content, ok = c.parseEffects(content); !ok {
+			return c
+		}
+
+		// v!<voice>-fx:<preset> runs the preset before any --flags
+		if preset, ok := strings.CutPrefix(sub, "fx:"); ok {
+			chain, found := tts.EffectPresets[strings.ToLower(preset)]
+			if !found {
+				c.action = func() {
+					util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("Unknown effect preset '%s', try one of: %s", preset, strings.Join(tts.ListEffectPresets(), ", ")), "invalid effect preset")
+				}
+				c.usableOutsideOfVC = true
+				return c
+			}
+			c.TTS.Effects = append(slices.Clone(chain), c.TTS.Effects...)
+			sub = ""
+		}
+
 		switch sub {
 		case "insult":
 			c.action = c.SayInsult
+			c.group = settings.GroupFun
 			return c
 		case "fact":
 			c.action = c.SayFact
+			c.group = settings.GroupFun
 			return c
 		case "joke":
 			c.action = c.SayJoke
+			c.group = settings.GroupFun
 			return c
 
 		// allow saying cached slurs,
 		// but don't generate any more
 		case "slur":
 			c.action = c.SaySlur
+			c.group = settings.GroupFun
 			return c
 
 		default:
@@ -115,13 +190,15 @@ func (c *Command) Build() *Command {
 				return c
 			}
 			c.action = func() {
-				c.TTS.GenerateAndPlay(c.MessageEvent, content, channel)
+				c.TTS.GenerateAndPlay(c.request(), content, channel)
 			}
 			return c
 		}
 	}
 
 	if strings.HasPrefix(c.CommandString, "ask") {
+		c.group = settings.GroupAI
+		c.permission = settings.PermAsk
 		switch c.SubcommandString {
 		case "marcus":
 			c.action = c.AskMarcusQuestion
@@ -138,6 +215,7 @@ func (c *Command) Build() *Command {
 	if c.CommandString == "list" {
 		switch c.SubcommandString {
 		case "memes":
+			c.group = settings.GroupMemes
 			c.action = func() {
 				util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, c.MemeSet.ListMemes(), "failed list memes")
 			}
@@ -146,29 +224,104 @@ func (c *Command) Build() *Command {
 		}
 	}
 
+	if c.CommandString == "combo" {
+		c.action = c.PlayCombo
+		c.group = settings.GroupCombo
+		return c
+	}
+
+	if c.CommandString == "mix" {
+		c.action = c.PlayMix
+		c.group = settings.GroupCombo
+		return c
+	}
+
+	if c.CommandString == "intro" {
+		c.action = c.ConfigureIntro
+		c.group = settings.GroupIntro
+		c.usableOutsideOfVC = true
+		return c
+	}
+
+	if c.CommandString == "volume" {
+		c.group = settings.GroupVolume
+		switch c.SubcommandString {
+		case "":
+			c.action = c.SetVolume
+			c.permission = settings.PermVolume
+		case "me":
+			c.action = c.SetUserVolume
+		default:
+			c.err = fmt.Errorf("unknown !volume subcommand: %s", c.SubcommandString)
+			return c
+		}
+		c.usableOutsideOfVC = true
+		return c
+	}
+
+	if c.CommandString == "usage" {
+		c.action = c.ShowUsage
+		c.usableOutsideOfVC = true
+		return c
+	}
+
+	if c.CommandString == "cache" && c.SubcommandString == "stats" {
+		c.action = c.CacheStats
+		c.permission = settings.PermCacheAdmin
+		c.usableOutsideOfVC = true
+		return c
+	}
+
 	if c.CommandString == "addmeme" {
 		c.action = c.AddMeme
+		c.group = settings.GroupMemes
+		c.permission = settings.PermAddMeme
 		c.usableOutsideOfVC = true
 		return c
 	}
 
 	meme, found := c.MemeSet.GetMeme(cmd)
 	if found {
-		c.Logger.Info("found meme for ", cmd, "")
+		c.Logger.Info("found meme", "meme", cmd)
+		c.group = settings.GroupMemes
+		if _, ok := c.parseEffects(content); !ok {
+			return c
+		}
 		c.action = func() {
-			c.TTS.SpeakFile(c.MessageEvent, meme, channel)
+			c.MemeSet.Played(*c.MessageEvent.GuildID, cmd)
+			c.TTS.SpeakFile(c.request(), meme, channel)
 		}
 		return c
 	} else {
-		c.Logger.Info("didn't find a meme for ", cmd)
+		c.Logger.Info("didn't find a meme", "meme", cmd)
 	}
 
 	c.ignore = true
 	return c
 }
 
+// parseEffects pulls effect flags out of content into c.TTS.Effects. If they
+// are invalid the command replies with why instead, and ok is false.
+func (c *Command) parseEffects(content string) (string, bool) {
+	effects, content, err := tts.ParseEffects(content)
+	if err != nil {
+		c.action = func() {
+			util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("Invalid effects: %v", err), "invalid effects")
+		}
+		c.usableOutsideOfVC = true
+		return "", false
+	}
+	c.TTS.Effects = effects
+	c.TTSOpts.Content = content
+	return content, true
+}
+
 func (c *Command) Execute() error {
-	if c.ignore || c.err != nil {
+	if c.ignore {
+		return nil
+	}
+	if c.err != nil {
+		metrics.CommandsTotal.WithLabelValues("unknown", metrics.OutcomeInvalid).Inc()
 		return nil
 	}
 
@@ -179,9 +332,32 @@ func (c *Command) Execute() error {
 	}
 
 	c.Logger = c.Logger.With("func", funcName)
+	name := c.metricName(funcName)
+
+	if c.group != "" && !c.guild.GroupEnabled(c.group) {
+		c.Logger.Info("command group is turned off", "group", c.group)
+		metrics.CommandsTotal.WithLabelValues(name, metrics.OutcomeDisabled).Inc()
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("The %s commands are turned off in this server.", c.group), "failed to send disabled message")
+		return nil
+	}
+
+	if c.permission != "" && !c.allowed(c.permission) {
+		c.Logger.Info("missing permission", "permission", c.permission)
+		metrics.CommandsTotal.WithLabelValues(name, metrics.OutcomeDenied).Inc()
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("You don't have the '%s' permission needed for that command, ask a server admin if you think you should.", c.permission), "failed to send permission denied message")
+		return nil
+	}
 
-	_, userInVC := util.GetUserVoiceChannel(c.MessageEvent, c.MessageEvent.Message.Author.ID)
-	if !c.usableOutsideOfVC && !userInVC {
+	if wait, ok := c.take(); !ok {
+		c.Logger.Info("rate limited", "group", c.group, "wait", wait)
+		metrics.CommandsTotal.WithLabelValues(name, metrics.OutcomeRateLimited).Inc()
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, slowDown(wait), "failed to send rate limit message")
+		return nil
+	}
+
+	_, userInVC := util.GetUserVoiceChannel(c.request(), c.MessageEvent.Message.Author.ID)
+	if !c.usableOutsideOfVC && !userInVC && c.guild.VoiceChannel() == "" {
+		metrics.CommandsTotal.WithLabelValues(name, metrics.OutcomeNotInVoice).Inc()
 		_, err := util.SendMessageInChannel(c.MessageEvent, c.MessageEvent.ChannelID, "You must be in a voice channel to use this command.")
 		if err != nil {
 			c.Logger.Error(fmt.Sprintf("encountered error sending message: %v", err))
@@ -190,16 +366,32 @@ func (c *Command) Execute() error {
 	}
 
 	c.Logger.Info("Executing command")
+	start := time.Now()
 	c.action()
+	metrics.CommandDuration.WithLabelValues(name).Observe(metrics.Since(start))
+	metrics.CommandsTotal.WithLabelValues(name, metrics.OutcomeOK).Inc()
 
 	return nil
 }
 
+// metricName names the command in metrics after the method it runs, such as
+// AskAIQuestion. Commands built from closures, like memes and TTS, are named
+// after their group instead, so user input never ends up in a label.
+func (c *Command) metricName(funcName string) string {
+	name := funcName[strings.LastIndex(funcName, ".")+1:]
+	name = strings.TrimSuffix(name, "-fm")
+	if strings.HasPrefix(name, "func") {
+		return cmp.Or(c.group, "other")
+	}
+	return name
+}
+
 // ExtractCommandParts parses an incoming message and extracts voice, command, channel, and content.
 // Supported forms:
 // - "v!<voice> <content>"
 // - "v!<voice>-<sub> [<channel>] [content]"
 // - "!marcus[-<sub>] [<channel>] [content]"
+// - "!<command>[-<sub>] [<channel>] [content]"
 // Returns isTTS=true for TTS commands (v!<voice> or !marcus), false for other commands like !ask-ai, !list-memes.
 // It enforces that v!<voice> cannot be combined with !marcus/!m explicitly in the same message.
 func (c *Command) ExtractCommandParts(msg string) (string, string, string, string, bool, error) {
@@ -214,13 +406,30 @@ func (c *Command) ExtractCommandParts(msg string) (string, string, string, strin
 	}
 
 	if strings.HasPrefix(msg, "!") {
-		return "", strings.TrimPrefix(msg, "!"), "", "", false, nil
+		fullCommand, remainder, _ := strings.Cut(strings.TrimPrefix(msg, "!"), " ")
+		channel, content := parseChannelAndContent(remainder)
+		return "", fullCommand, channel, content, false, nil
 	}
 
 	// No recognized command syntax, just a normal message
 	return "", "", "", "", false, nil
 }
 
+// inTextChannel reports whether the message was sent in one of the guild's
+// allowed text channels.
+func (c *Command) inTextChannel() bool {
+	channels := c.guild.TextChannels()
+	if len(channels) == 0 || c.MessageEvent.GuildID == nil {
+		return true
+	}
+	return util.MessageInChannels(c.MessageEvent, channels)
+}
+
+// request is who sent the command, for playing audio and replying to them.
+func (c *Command) request() *util.Request {
+	return util.MessageRequest(c.MessageEvent)
+}
+
 func (c *Command) ListVoices() {
 	names := make(map[string][]string)
 	for _, gen := range c.Generators {
@@ -2,11 +2,31 @@ package pkg
 
 import (
 	"fmt"
+	"marcus/pkg/settings"
 	"marcus/pkg/tts"
 	"strings"
 )
 
+// applyPrefix rewrites a command using the guild's prefix into the "!" form the
+// parser understands. Commands using "!" are ignored once the guild has its own
+// prefix, apart from !config so a bad prefix can always be fixed.
+func applyPrefix(msg, prefix string) (string, bool) {
+	msg = strings.TrimSpace(msg)
+	if prefix == settings.DefaultPrefix || strings.HasPrefix(msg, "v!") {
+		return msg, true
+	}
+
+	if rest, ok := strings.CutPrefix(msg, prefix); ok {
+		return "!" + rest, true
+	}
+	if strings.HasPrefix(msg, "!") && !strings.HasPrefix(msg, "!config") {
+		return "", false
+	}
+	return msg, true
+}
+
 // extractVoiceCommand handles the v! and !marcus, or !m, syntax for requesting a voice command.
+// The voice is empty for !marcus, which uses the guild's default voice.
 func extractVoiceCommand(msg string, generators []tts.Generator) (string, string, string, string, bool, error) {
 	if !strings.HasPrefix(msg, "!") && !strings.HasPrefix(msg, "v!") {
 		return "", "", "", "", false, nil
@@ -44,8 +64,6 @@ func extractVoiceCommand(msg string, generators []tts.Generator) (string, string
 			return "", "", "", "", false, fmt.Errorf("unknown voice '%s'", baseCommand)
 		}
 		voice = baseCommand
-	} else {
-		voice = tts.DefaultVoice
 	}
 
 	restOfMessage := strings.TrimSpace(remainder)
@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"marcus/pkg/fake"
+	"marcus/pkg/tts"
+	"testing"
+)
+
+// parsed is what ExtractCommandParts returns.
+type parsed struct {
+	voice, cmd, channel, content string
+	isTTS                        bool
+}
+
+func TestExtractCommandParts(t *testing.T) {
+	c := &Command{TTS: &tts.TTS{Generators: []tts.Generator{&fake.Generator{Voices: []string{"liam", "marcus"}}}}}
+
+	tests := []struct {
+		name    string
+		msg     string
+		want    parsed
+		wantErr bool
+	}{
+		{name: "empty", msg: "  "},
+		{name: "normal message", msg: "hello there"},
+		{name: "voice", msg: "v!liam hello", want: parsed{"liam", "liam", "", "hello", true}},
+		{name: "voice is matched case-insensitively", msg: "v!LIAM hi", want: parsed{"LIAM", "LIAM", "", "hi", true}},
+		{name: "voice with subcommand", msg: "v!liam-joke", want: parsed{"liam", "liam-joke", "", "", true}},
+		{name: "voice in a channel", msg: "v!liam <General> hi all", want: parsed{"liam", "liam", "General", "hi all", true}},
+		{name: "unknown voice", msg: "v!nobody hi", wantErr: true},
+		{name: "list voices", msg: "v!voices", want: parsed{cmd: "list-voices"}},
+		{name: "marcus", msg: "!marcus hello", want: parsed{"", "marcus", "", "hello", true}},
+		{name: "marcus shorthand", msg: "!m hello", want: parsed{"", "m", "", "hello", true}},
+		{name: "marcus subcommand in a channel", msg: "!marcus-insult <Gaming>", want: parsed{"", "marcus-insult", "Gaming", "", true}},
+		{name: "command starting with m", msg: "!mix airhorn bruh", want: parsed{"", "mix", "", "airhorn bruh", false}},
+		{name: "command", msg: "!ask-ai what is <this>", want: parsed{"", "ask-ai", "", "what is <this>", false}},
+		{name: "meme in a channel", msg: "  !airhorn <General>  ", want: parsed{"", "airhorn", "General", "", false}},
+		{name: "config", msg: "!config set prefix ?", want: parsed{"", "config", "", "set prefix ?", false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			voice, cmd, channel, content, isTTS, err := c.ExtractCommandParts(tt.msg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if got := (parsed{voice, cmd, channel, content, isTTS}); got != tt.want {
+				t.Errorf("ExtractCommandParts(%q) = %+v, want %+v", tt.msg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractVoiceCommand(t *testing.T) {
+	liam := []tts.Generator{&fake.Generator{Voices: []string{"liam"}}}
+
+	tests := []struct {
+		name       string
+		msg        string
+		generators []tts.Generator
+		want       parsed
+		wantErr    string
+	}{
+		{name: "not a voice command", msg: "hello", generators: liam},
+		{name: "voice", msg: "v!liam <Gaming> gg", generators: liam, want: parsed{"liam", "liam", "Gaming", "gg", true}},
+		{name: "no generators", msg: "v!liam hi", wantErr: "no voice generators configured"},
+		{name: "unsupported voice", msg: "v!tim hi", generators: liam, wantErr: "unknown voice 'tim'"},
+		{name: "marcus doesn't need generators", msg: "!marcus-fact", want: parsed{"", "marcus-fact", "", "", true}},
+		{name: "effect preset", msg: "v!liam-fx:robot hello", generators: liam, want: parsed{"liam", "liam-fx:robot", "", "hello", true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			voice, cmd, channel, content, isTTS, err := extractVoiceCommand(tt.msg, tt.generators)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := (parsed{voice, cmd, channel, content, isTTS}); got != tt.want {
+				t.Errorf("extractVoiceCommand(%q) = %+v, want %+v", tt.msg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseChannelAndContent(t *testing.T) {
+	tests := []struct {
+		input       string
+		wantChannel string
+		wantContent string
+	}{
+		{"", "", ""},
+		{"hello", "", "hello"},
+		{"<General>", "General", ""},
+		{"<General> hi", "General", "hi"},
+		{"  <General>   hi there  ", "General", "hi there"},
+		{"<General", "", "<General"},
+		{"<Voice Chat> hi", "", "<Voice Chat> hi"},
+		{"hi <General>", "", "hi <General>"},
+		{"<> hi", "", "hi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			channel, content := parseChannelAndContent(tt.input)
+			if channel != tt.wantChannel || content != tt.wantContent {
+				t.Errorf("parseChannelAndContent(%q) = (%q, %q), want (%q, %q)", tt.input, channel, content, tt.wantChannel, tt.wantContent)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package pkg
+
+import (
+	"context"
+	"log/slog"
+	"marcus/pkg/config"
+	"marcus/pkg/fake"
+	"marcus/pkg/ratelimit"
+	"marcus/pkg/settings"
+	"marcus/pkg/tts"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const (
+	testGeneralID snowflake.ID = 40
+	testGamingID  snowflake.ID = 41
+)
+
+func TestCommandPlayback(t *testing.T) {
+	tests := []struct {
+		name         string
+		msg          string
+		inVoice      snowflake.ID
+		groups       string
+		wantChannel  snowflake.ID
+		wantAudio    string
+		wantMessages []string
+	}{
+		{name: "marcus", msg: "!marcus hello", inVoice: testGamingID, wantChannel: testGamingID, wantAudio: "marcus: hello"},
+		{name: "voice in a named channel", msg: "v!liam <General> hi", wantChannel: testGeneralID, wantAudio: "liam: hi"},
+		{name: "meme", msg: "!airhorn", inVoice: testGeneralID, wantChannel: testGeneralID, wantAudio: "airhorn.wav"},
+		{name: "meme in a directory", msg: "!sounds-bruh <Gaming>", wantChannel: testGamingID, wantAudio: "sounds/bruh.wav"},
+		{name: "not in voice", msg: "!airhorn", wantMessages: []string{"You must be in a voice channel to use this command."}},
+		{name: "group turned off", msg: "!airhorn", inVoice: testGeneralID, groups: settings.GroupTTS, wantMessages: []string{"The memes commands are turned off in this server."}},
+		{name: "server volume needs the permission", msg: "!volume 50", wantMessages: []string{"You don't have the 'volume' permission needed for that command, ask a server admin if you think you should."}},
+		{name: "own volume", msg: "!volume-me 50", wantMessages: []string{"Your clips will now play at 50%"}},
+		{name: "not a meme", msg: "!nothing", inVoice: testGeneralID},
+		{name: "not a command", msg: "airhorn", inVoice: testGeneralID},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.DiscardHandler)
+			dir := t.TempDir()
+			cfg := config.Default()
+			cfg.Storage.AudioDir = dir
+
+			discord := fake.NewDiscord(t)
+			discord.AddTextChannel(testGuildID, testChannelID, "general")
+			discord.AddVoiceChannel(testGuildID, testGeneralID, "General")
+			discord.AddVoiceChannel(testGuildID, testGamingID, "Gaming")
+			discord.JoinVoice(testGuildID, testUserID, tt.inVoice)
+
+			guildSettings, err := settings.NewStore(dir, logger)
+			if err != nil {
+				t.Fatalf("failed to create settings store: %v", err)
+			}
+			if tt.groups != "" {
+				if _, err := guildSettings.Set(testGuildID, settings.KeyCommandGroups, tt.groups); err != nil {
+					t.Fatalf("failed to set command groups: %v", err)
+				}
+			}
+
+			volumes, err := tts.NewVolumeStore(dir, logger)
+			if err != nil {
+				t.Fatalf("failed to create volume store: %v", err)
+			}
+
+			voice := fake.NewVoiceManager()
+			connections := tts.NewConnectionManager(logger, voice, config.NewStaticStore(cfg))
+			connections.Encode = fake.Encode
+			t.Cleanup(func() { _ = connections.Shutdown(context.Background()) })
+
+			memes, _ := newTestMemeSet(t, "airhorn.wav", "sounds/bruh.wav")
+			c := &Command{
+				Logger:        logger,
+				Config:        config.NewStaticStore(cfg),
+				TTS:           &tts.TTS{Config: config.NewStaticStore(cfg), Connections: connections, Volumes: volumes, Logger: logger, Generators: []tts.Generator{&fake.Generator{Voices: []string{"marcus", "liam"}}}},
+				MemeSet:       memes,
+				GuildSettings: guildSettings,
+				MessageEvent:  discord.MessageCreate(t, testGuildID, testChannelID, testUserID, tt.msg),
+			}
+			if err := c.Build().Execute(); err != nil {
+				t.Fatalf("failed to execute: %v", err)
+			}
+
+			if tt.wantAudio != "" {
+				clips := voice.WaitForClips(t, 1)
+				if clips[0].ChannelID != tt.wantChannel || string(clips[0].Audio()) != tt.wantAudio {
+					t.Errorf("played %q in %s, want %q in %s", clips[0].Audio(), clips[0].ChannelID, tt.wantAudio, tt.wantChannel)
+				}
+			} else if clips := voice.Clips(); len(clips) != 0 {
+				t.Errorf("played %d clips, want none", len(clips))
+			}
+
+			if sent := discord.Sent(); !slices.Equal(sent, tt.wantMessages) {
+				t.Errorf("messages = %q, want %q", sent, tt.wantMessages)
+			}
+		})
+	}
+}
+
+func TestCommandEffects(t *testing.T) {
+	tests := []struct {
+		name        string
+		msg         string
+		wantContent string
+		wantEffects string
+	}{
+		{name: "tts", msg: "v!liam hi --speed 2 --reverse", wantContent: "hi", wantEffects: "speed=2.00,reverse"},
+		{name: "tts preset runs first", msg: "v!liam-fx:chipmunk hi --bass", wantContent: "hi", wantEffects: "pitch=+7.0,bass=12.0"},
+		{name: "meme", msg: "!airhorn --reverse", wantEffects: "reverse"},
+		{name: "ask keeps its flags", msg: "!ask-ai what does --speed 2 do", wantContent: "what does --speed 2 do"},
+		{name: "intro keeps its flags", msg: "!intro say hi --reverb", wantContent: "say hi --reverb"},
+		{name: "config keeps its flags", msg: "!config set prefix --", wantContent: "set prefix --"},
+		{name: "perms", msg: "!perms reset volume", wantContent: "reset volume"},
+		{name: "combo keeps its quotes", msg: `!combo "v!liam  hi --reverb" airhorn`, wantContent: `"v!liam  hi --reverb" airhorn`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.DiscardHandler)
+			cfg := config.Default()
+			cfg.Storage.AudioDir = t.TempDir()
+
+			discord := fake.NewDiscord(t)
+			discord.AddTextChannel(testGuildID, testChannelID, "general")
+
+			memes, _ := newTestMemeSet(t, "airhorn.wav")
+			c := &Command{
+				Logger:       logger,
+				Config:       config.NewStaticStore(cfg),
+				TTS:          &tts.TTS{Config: config.NewStaticStore(cfg), Logger: logger, Generators: []tts.Generator{&fake.Generator{Voices: []string{"marcus", "liam"}}}},
+				MemeSet:      memes,
+				MessageEvent: discord.MessageCreate(t, testGuildID, testChannelID, testUserID, tt.msg),
+			}
+			c.Build()
+
+			if c.TTSOpts.Content != tt.wantContent || c.TTS.Effects.String() != tt.wantEffects {
+				t.Errorf("Build(%q) = content %q with effects %q, want %q with %q", tt.msg, c.TTSOpts.Content, c.TTS.Effects, tt.wantContent, tt.wantEffects)
+			}
+		})
+	}
+}
+
+func TestUngroupedRateLimits(t *testing.T) {
+	const usage = "**ElevenLabs usage**\nElevenLabs isn't set up.\nUsage tracking isn't available right now."
+
+	tests := []struct {
+		name         string
+		msgs         []string
+		wantMessages []string
+	}{
+		{name: "within the budget", msgs: []string{"!usage"}, wantMessages: []string{usage}},
+		{name: "usage is limited", msgs: []string{"!usage", "!usage"}, wantMessages: []string{usage, "Slow down! Try again in 60s"}},
+		{name: "groups have their own budget", msgs: []string{"!usage", "!volume-me 50"}, wantMessages: []string{usage, "Your clips will now play at 50%"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.DiscardHandler)
+			dir := t.TempDir()
+			cfg := config.Default()
+			cfg.Storage.AudioDir = dir
+			cfg.RateLimits.Cheap.User = config.RateLimit{Burst: 1, Every: config.Duration(time.Minute)}
+
+			discord := fake.NewDiscord(t)
+			discord.AddTextChannel(testGuildID, testChannelID, "general")
+			volumes, err := tts.NewVolumeStore(dir, logger)
+			if err != nil {
+				t.Fatalf("failed to create volume store: %v", err)
+			}
+
+			limiter := ratelimit.New()
+			for _, msg := range tt.msgs {
+				c := &Command{
+					Logger:       logger,
+					Config:       config.NewStaticStore(cfg),
+					TTS:          &tts.TTS{Config: config.NewStaticStore(cfg), Volumes: volumes, Logger: logger},
+					Limiter:      limiter,
+					MessageEvent: discord.MessageCreate(t, testGuildID, testChannelID, testUserID, msg),
+				}
+				if err := c.Build().Execute(); err != nil {
+					t.Fatalf("failed to execute %s: %v", msg, err)
+				}
+			}
+
+			if sent := discord.Sent(); !slices.Equal(sent, tt.wantMessages) {
+				t.Errorf("messages = %q, want %q", sent, tt.wantMessages)
+			}
+		})
+	}
+}
+
+func TestCostlyRateLimits(t *testing.T) {
+	type take struct {
+		group      string
+		generation bool
+		wantOK     bool
+	}
+	ai := take{group: settings.GroupAI, wantOK: true}
+	generate := take{generation: true, wantOK: true}
+
+	tests := []struct {
+		name  string
+		takes []take
+	}{
+		{name: "questions are limited", takes: []take{ai, {group: settings.GroupAI}}},
+		{name: "other groups don't use the costly budget", takes: []take{ai, {group: settings.GroupTTS, wantOK: true}, {group: settings.GroupTTS, wantOK: true}}},
+		{name: "generation is limited", takes: []take{generate, {generation: true}}},
+		{name: "questions and generation have their own budgets", takes: []take{ai, generate}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.RateLimits.Cheap.User = config.RateLimit{Burst: 10, Every: config.Duration(time.Minute)}
+			cfg.RateLimits.Costly.User = config.RateLimit{Burst: 1, Every: config.Duration(time.Minute)}
+
+			discord := fake.NewDiscord(t)
+			discord.AddTextChannel(testGuildID, testChannelID, "general")
+			limiter := ratelimit.New()
+			for i, take := range tt.takes {
+				c := &Command{
+					Config:       config.NewStaticStore(cfg),
+					Limiter:      limiter,
+					MessageEvent: discord.MessageCreate(t, testGuildID, testChannelID, testUserID, "!test"),
+					group:        take.group,
+				}
+				var ok bool
+				if take.generation {
+					_, ok = c.takeGeneration()
+				} else {
+					_, ok = c.take()
+				}
+				if ok != take.wantOK {
+					t.Errorf("take %d: allowed = %v, want %v", i, ok, take.wantOK)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,477 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting the bot reads. It is loaded from an optional
+// YAML file, then environment variables override anything set in the file.
+type Config struct {
+	Discord    DiscordConfig    `yaml:"discord"`
+	Storage    StorageConfig    `yaml:"storage"`
+	ElevenLabs ElevenLabsConfig `yaml:"eleven_labs"`
+	OpenRouter OpenRouterConfig `yaml:"open_router"`
+	OpenAI     OpenAIConfig     `yaml:"openai"`
+	AI         AIConfig         `yaml:"ai"`
+	TikTok     TikTokConfig     `yaml:"tiktok"`
+	Voice      VoiceConfig      `yaml:"voice"`
+	Memes      MemesConfig      `yaml:"memes"`
+	Intros     IntrosConfig     `yaml:"intros"`
+	Prewarm    PrewarmConfig    `yaml:"prewarm"`
+	RateLimits RateLimitsConfig `yaml:"rate_limits"`
+	HTTP       HTTPConfig       `yaml:"http"`
+
+	// ShutdownTimeout is how long queued clips and running commands get to
+	// finish after SIGTERM before they're cut off.
+	ShutdownTimeout Duration `yaml:"shutdown_timeout"`
+
+	// Debug enables debug logging.
+	Debug bool `yaml:"debug"`
+}
+
+type DiscordConfig struct {
+	Token string `yaml:"token"` // secret
+}
+
+// StorageConfig is where data lives on disk. Changes to it need a restart.
+type StorageConfig struct {
+	AudioDir string `yaml:"audio_dir"`
+	MemesDir string `yaml:"memes_dir"`
+}
+
+type ElevenLabsConfig struct {
+	APIKey string `yaml:"api_key"` // secret
+
+	// Monthly character budgets for generating new audio, 0 for no limit.
+	// Cached clips are free and don't count.
+	MonthlyUserCharacters  int `yaml:"monthly_user_characters"`
+	MonthlyGuildCharacters int `yaml:"monthly_guild_characters"`
+}
+
+type OpenRouterConfig struct {
+	APIKey string `yaml:"api_key"` // secret
+
+	// Spending caps in USD, days and months start at midnight UTC.
+	DailyCap   SpendCap `yaml:"daily_cap"`
+	MonthlyCap SpendCap `yaml:"monthly_cap"`
+}
+
+// OpenAIConfig points at any server with an OpenAI-compatible chat
+// completions API, such as llama.cpp or Ollama.
+type OpenAIConfig struct {
+	BaseURL string `yaml:"base_url"` // e.g. http://localhost:11434/v1
+	APIKey  string `yaml:"api_key"`  // secret, optional
+}
+
+const (
+	BackendOpenRouter = "openrouter"
+	BackendOpenAI     = "openai"
+)
+
+// AIConfig chooses the backend and models used by each AI persona.
+type AIConfig struct {
+	// Retries is how many more times a model is tried after being rate
+	// limited or failing with a server error, before moving to the next one.
+	Retries int `yaml:"retries"`
+
+	// Tools lists the bot features the models may use while answering, such
+	// as play_meme. Anything not listed can't be called.
+	Tools []string `yaml:"tools"`
+	// MaxToolCalls caps how many tools a single question can run.
+	MaxToolCalls int `yaml:"max_tool_calls"`
+
+	Marcus  PersonaConfig `yaml:"marcus"`
+	General PersonaConfig `yaml:"general"`
+}
+
+type PersonaConfig struct {
+	// Backend is openrouter or openai.
+	Backend string `yaml:"backend"`
+	// Models are tried in order until one answers.
+	Models []string `yaml:"models"`
+}
+
+// SpendCap limits what the whole bot, each guild and each user can spend on
+// AI questions in a period. 0 means no limit.
+type SpendCap struct {
+	Total float64 `yaml:"total"`
+	Guild float64 `yaml:"guild"`
+	User  float64 `yaml:"user"`
+}
+
+type TikTokConfig struct {
+	SessionID string `yaml:"session_id"` // secret
+}
+
+type VoiceConfig struct {
+	// IdleTimeout is how long to stay in a voice channel after the last clip.
+	IdleTimeout Duration `yaml:"idle_timeout"`
+}
+
+type MemesConfig struct {
+	// RefreshInterval is how often MEMES_LOCATION is rescanned for new memes.
+	RefreshInterval Duration `yaml:"refresh_interval"`
+}
+
+type IntrosConfig struct {
+	// Cooldown stops intros replaying when someone reconnects.
+	Cooldown Duration `yaml:"cooldown"`
+}
+
+// PrewarmConfig generates common phrases before anyone asks for them, so
+// they're cached and play straight away.
+type PrewarmConfig struct {
+	// File is a phrase list with one phrase per line. A [voice] line starts
+	// that voice's phrases, the ones before it use marcus.
+	File string `yaml:"file"`
+	// Phrases are more phrases to prewarm, by voice.
+	Phrases map[string][]string `yaml:"phrases"`
+
+	// OnStartup prewarms when the bot starts.
+	OnStartup bool `yaml:"on_startup"`
+	// Interval prewarms again this often, picking up new phrases. 0 turns it off.
+	Interval Duration `yaml:"interval"`
+
+	// Concurrency is how many phrases are generated at once.
+	Concurrency int `yaml:"concurrency"`
+	// MaxCharacters caps the ElevenLabs characters one run generates, 0 for
+	// no limit. A run also stops when the ElevenLabs account has none left.
+	MaxCharacters int `yaml:"max_characters"`
+}
+
+// HTTPConfig is the HTTP server that serves /metrics, /healthz, /readyz, the
+// admin dashboard and the REST API.
+type HTTPConfig struct {
+	// Addr is where the server listens, such as :9090. Empty turns it off.
+	Addr string `yaml:"addr"`
+
+	// AdminToken is the password for the admin dashboard on /admin/. Empty
+	// turns the dashboard off.
+	AdminToken string `yaml:"admin_token"` // secret
+
+	// APIToken is the bearer token for the REST API on /api/v1/. Empty turns
+	// the API off.
+	APIToken string `yaml:"api_token"` // secret
+}
+
+// RateLimitsConfig sets how often commands can be used. Cheap limits apply to
+// every command, costly limits also apply to uncached ElevenLabs generation
+// and AI questions, which cost money.
+type RateLimitsConfig struct {
+	Cheap  RateLimitTier `yaml:"cheap"`
+	Costly RateLimitTier `yaml:"costly"`
+}
+
+// RateLimitTier has one bucket per user and another shared by the whole guild.
+// Both are kept separately for each command group.
+type RateLimitTier struct {
+	User  RateLimit `yaml:"user"`
+	Guild RateLimit `yaml:"guild"`
+}
+
+// RateLimit allows Burst uses at once, then one more every Every. A burst of
+// 0 turns the limit off.
+type RateLimit struct {
+	Burst int      `yaml:"burst"`
+	Every Duration `yaml:"every"`
+}
+
+// Duration is a time.Duration written as a string like "5m" in YAML.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %q is not a duration such as 30s or 5m", value.Line, value.Value)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalYAML() (any, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+// Default returns the settings used when nothing is configured.
+func Default() *Config {
+	return &Config{
+		Storage: StorageConfig{
+			AudioDir: filepath.Join(".", "audio"),
+			MemesDir: "memes",
+		},
+		Voice: VoiceConfig{
+			IdleTimeout: Duration(5 * time.Minute),
+		},
+		HTTP: HTTPConfig{
+			Addr: ":9090",
+		},
+		ShutdownTimeout: Duration(20 * time.Second),
+		Memes: MemesConfig{
+			RefreshInterval: Duration(10 * time.Second),
+		},
+		Intros: IntrosConfig{
+			Cooldown: Duration(10 * time.Minute),
+		},
+		Prewarm: PrewarmConfig{
+			OnStartup:     true,
+			Concurrency:   2,
+			MaxCharacters: 10000,
+		},
+		AI: AIConfig{
+			Retries:      2,
+			Tools:        []string{"play_meme", "set_voice", "get_joke", "get_fact", "search_cached_lines"},
+			MaxToolCalls: 3,
+			Marcus: PersonaConfig{
+				Backend: BackendOpenRouter,
+				Models: []string{
+					"tngtech/deepseek-r1t2-chimera:free",
+					"deepseek/deepseek-chat-v3.1:free",
+					"meta-llama/llama-3.3-70b-instruct:free",
+				},
+			},
+			General: PersonaConfig{
+				Backend: BackendOpenRouter,
+				Models: []string{
+					"google/gemma-3n-e2b-it:free",
+					"meta-llama/llama-3.3-70b-instruct:free",
+					"mistralai/mistral-small-3.2-24b-instruct:free",
+				},
+			},
+		},
+		RateLimits: RateLimitsConfig{
+			Cheap: RateLimitTier{
+				User:  RateLimit{Burst: 10, Every: Duration(3 * time.Second)},
+				Guild: RateLimit{Burst: 30, Every: Duration(time.Second)},
+			},
+			Costly: RateLimitTier{
+				User:  RateLimit{Burst: 3, Every: Duration(time.Minute)},
+				Guild: RateLimit{Burst: 10, Every: Duration(20 * time.Second)},
+			},
+		},
+	}
+}
+
+// Load reads the config file at path, if one is given, and applies
+// environment variable overrides on top of it. The result is validated.
+func Load(path string) (*Config, error) {
+	cfg, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadOffline is Load for the command-line tools, which don't connect to
+// Discord and so don't need its token.
+func LoadOffline(path string) (*Config, error) {
+	cfg, err := read(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(false); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// read loads the config file and environment variables without validating them.
+func read(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides settings with any environment variables that are set.
+func (c *Config) applyEnv() error {
+	values := map[string]*string{
+		"DISCORD_BOT_TOKEN":   &c.Discord.Token,
+		"AUDIO_DIR":           &c.Storage.AudioDir,
+		"MEMES_LOCATION":      &c.Storage.MemesDir,
+		"ELEVEN_LABS_API_KEY": &c.ElevenLabs.APIKey,
+		"OPEN_ROUTER_KEY":     &c.OpenRouter.APIKey,
+		"OPENAI_BASE_URL":     &c.OpenAI.BaseURL,
+		"OPENAI_API_KEY":      &c.OpenAI.APIKey,
+		"TIKTOK_SESSION_ID":   &c.TikTok.SessionID,
+		"HTTP_ADDR":           &c.HTTP.Addr,
+		"ADMIN_TOKEN":         &c.HTTP.AdminToken,
+		"API_TOKEN":           &c.HTTP.APIToken,
+		"PREWARM_FILE":        &c.Prewarm.File,
+	}
+	for env, field := range values {
+		if v := os.Getenv(env); v != "" {
+			*field = v
+		}
+	}
+
+	durations := map[string]*Duration{
+		"VOICE_IDLE_TIMEOUT":    &c.Voice.IdleTimeout,
+		"MEME_REFRESH_INTERVAL": &c.Memes.RefreshInterval,
+		"INTRO_COOLDOWN":        &c.Intros.Cooldown,
+		"SHUTDOWN_TIMEOUT":      &c.ShutdownTimeout,
+		"PREWARM_INTERVAL":      &c.Prewarm.Interval,
+	}
+	for env, field := range durations {
+		v := os.Getenv(env)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s must be a duration such as 30s or 5m, got %q", env, v)
+		}
+		*field = Duration(parsed)
+	}
+
+	if v := os.Getenv("PREWARM_ON_STARTUP"); v != "" {
+		onStartup, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("PREWARM_ON_STARTUP must be true or false, got %q", v)
+		}
+		c.Prewarm.OnStartup = onStartup
+	}
+
+	if v := os.Getenv("DEBUG"); v != "" {
+		debug, err := strconv.ParseBool(v)
+		// DEBUG used to be enabled by being set to anything
+		c.Debug = err != nil || debug
+	}
+
+	return nil
+}
+
+// Validate checks the config is usable, reporting every problem at once.
+func (c *Config) Validate() error {
+	return c.validate(true)
+}
+
+func (c *Config) validate(needDiscord bool) error {
+	var errs []error
+
+	if needDiscord && c.Discord.Token == "" {
+		errs = append(errs, errors.New("discord.token (DISCORD_BOT_TOKEN) is required"))
+	}
+	if c.Storage.AudioDir == "" {
+		errs = append(errs, errors.New("storage.audio_dir (AUDIO_DIR) can't be empty"))
+	}
+	if c.Storage.MemesDir == "" {
+		errs = append(errs, errors.New("storage.memes_dir (MEMES_LOCATION) can't be empty"))
+	}
+	if c.Voice.IdleTimeout <= 0 {
+		errs = append(errs, errors.New("voice.idle_timeout (VOICE_IDLE_TIMEOUT) must be positive"))
+	}
+	if c.Memes.RefreshInterval < Duration(time.Second) {
+		errs = append(errs, errors.New("memes.refresh_interval (MEME_REFRESH_INTERVAL) must be at least 1s"))
+	}
+	if c.Intros.Cooldown < 0 {
+		errs = append(errs, errors.New("intros.cooldown (INTRO_COOLDOWN) can't be negative"))
+	}
+	if c.ShutdownTimeout < 0 {
+		errs = append(errs, errors.New("shutdown_timeout (SHUTDOWN_TIMEOUT) can't be negative"))
+	}
+
+	if c.Prewarm.Interval < 0 {
+		errs = append(errs, errors.New("prewarm.interval (PREWARM_INTERVAL) can't be negative"))
+	}
+	if c.Prewarm.Concurrency < 1 {
+		errs = append(errs, errors.New("prewarm.concurrency must be at least 1"))
+	}
+	if c.Prewarm.MaxCharacters < 0 {
+		errs = append(errs, errors.New("prewarm.max_characters can't be negative"))
+	}
+
+	if c.ElevenLabs.MonthlyUserCharacters < 0 || c.ElevenLabs.MonthlyGuildCharacters < 0 {
+		errs = append(errs, errors.New("eleven_labs monthly character budgets can't be negative"))
+	}
+
+	if c.AI.Retries < 0 {
+		errs = append(errs, errors.New("ai.retries can't be negative"))
+	}
+	if c.AI.MaxToolCalls < 0 {
+		errs = append(errs, errors.New("ai.max_tool_calls can't be negative"))
+	}
+	personas := map[string]PersonaConfig{"ai.marcus": c.AI.Marcus, "ai.general": c.AI.General}
+	for name, persona := range personas {
+		switch persona.Backend {
+		case BackendOpenRouter:
+		case BackendOpenAI:
+			if c.OpenAI.BaseURL == "" {
+				errs = append(errs, fmt.Errorf("%s uses the openai backend, which needs openai.base_url (OPENAI_BASE_URL)", name))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s.backend must be %s or %s, got %q", name, BackendOpenRouter, BackendOpenAI, persona.Backend))
+		}
+		if len(persona.Models) == 0 {
+			errs = append(errs, fmt.Errorf("%s.models needs at least one model", name))
+		}
+	}
+
+	caps := map[string]SpendCap{
+		"open_router.daily_cap":   c.OpenRouter.DailyCap,
+		"open_router.monthly_cap": c.OpenRouter.MonthlyCap,
+	}
+	for name, spendCap := range caps {
+		if spendCap.Total < 0 || spendCap.Guild < 0 || spendCap.User < 0 {
+			errs = append(errs, fmt.Errorf("%s can't be negative", name))
+		}
+	}
+
+	limits := map[string]RateLimit{
+		"rate_limits.cheap.user":   c.RateLimits.Cheap.User,
+		"rate_limits.cheap.guild":  c.RateLimits.Cheap.Guild,
+		"rate_limits.costly.user":  c.RateLimits.Costly.User,
+		"rate_limits.costly.guild": c.RateLimits.Costly.Guild,
+	}
+	for name, limit := range limits {
+		if limit.Burst < 0 {
+			errs = append(errs, fmt.Errorf("%s.burst can't be negative", name))
+		}
+		if limit.Burst > 0 && limit.Every <= 0 {
+			errs = append(errs, fmt.Errorf("%s.every must be positive", name))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// withRestartOnly copies the settings that can't change while running, the
+// secrets and storage locations, from prev onto c.
+func (c *Config) withRestartOnly(prev *Config) *Config {
+	c.Discord = prev.Discord
+	c.ElevenLabs.APIKey = prev.ElevenLabs.APIKey
+	c.OpenRouter.APIKey = prev.OpenRouter.APIKey
+	c.OpenAI.APIKey = prev.OpenAI.APIKey
+	c.TikTok = prev.TikTok
+	c.Storage = prev.Storage
+	c.HTTP = prev.HTTP
+	return c
+}
@@ -0,0 +1,205 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// envVars are every variable applyEnv reads, cleared for each test so the
+// environment running the tests can't change them.
+var envVars = []string{
+	"DISCORD_BOT_TOKEN", "AUDIO_DIR", "MEMES_LOCATION", "ELEVEN_LABS_API_KEY", "OPEN_ROUTER_KEY",
+	"OPENAI_BASE_URL", "OPENAI_API_KEY", "TIKTOK_SESSION_ID", "HTTP_ADDR", "ADMIN_TOKEN", "API_TOKEN",
+	"PREWARM_FILE", "VOICE_IDLE_TIMEOUT", "MEME_REFRESH_INTERVAL", "INTRO_COOLDOWN", "SHUTDOWN_TIMEOUT",
+	"PREWARM_INTERVAL", "PREWARM_ON_STARTUP", "DEBUG",
+}
+
+func writeConfig(t *testing.T, path, yaml string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		env  map[string]string
+
+		got     func(c *Config) any
+		want    any
+		wantErr []string
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{"DISCORD_BOT_TOKEN": "token"},
+			got:  func(c *Config) any { return c.Intros.Cooldown.Duration() },
+			want: 10 * time.Minute,
+		},
+		{
+			name: "file settings",
+			yaml: "discord:\n  token: token\nstorage:\n  audio_dir: /from/file\n",
+			got:  func(c *Config) any { return c.Storage.AudioDir },
+			want: "/from/file",
+		},
+		{
+			name: "environment overrides the file",
+			yaml: "discord:\n  token: token\nstorage:\n  audio_dir: /from/file\n",
+			env:  map[string]string{"AUDIO_DIR": "/from/env"},
+			got:  func(c *Config) any { return c.Storage.AudioDir },
+			want: "/from/env",
+		},
+		{
+			name: "durations from the environment",
+			env:  map[string]string{"DISCORD_BOT_TOKEN": "token", "INTRO_COOLDOWN": "1m"},
+			got:  func(c *Config) any { return c.Intros.Cooldown.Duration() },
+			want: time.Minute,
+		},
+		{
+			name: "DEBUG set to anything turns it on",
+			env:  map[string]string{"DISCORD_BOT_TOKEN": "token", "DEBUG": "yes"},
+			got:  func(c *Config) any { return c.Debug },
+			want: true,
+		},
+		{
+			name: "DEBUG can be turned off",
+			yaml: "debug: true\n",
+			env:  map[string]string{"DISCORD_BOT_TOKEN": "token", "DEBUG": "false"},
+			got:  func(c *Config) any { return c.Debug },
+			want: false,
+		},
+		{
+			name:    "bad duration in the environment",
+			env:     map[string]string{"DISCORD_BOT_TOKEN": "token", "INTRO_COOLDOWN": "soon"},
+			wantErr: []string{`INTRO_COOLDOWN must be a duration such as 30s or 5m, got "soon"`},
+		},
+		{
+			name:    "bad duration in the file",
+			yaml:    "intros:\n  cooldown: soon\n",
+			env:     map[string]string{"DISCORD_BOT_TOKEN": "token"},
+			wantErr: []string{`"soon" is not a duration such as 30s or 5m`},
+		},
+		{
+			name:    "bad boolean in the environment",
+			env:     map[string]string{"DISCORD_BOT_TOKEN": "token", "PREWARM_ON_STARTUP": "maybe"},
+			wantErr: []string{`PREWARM_ON_STARTUP must be true or false, got "maybe"`},
+		},
+		{
+			name:    "token is required",
+			wantErr: []string{"discord.token (DISCORD_BOT_TOKEN) is required"},
+		},
+		{
+			name: "every problem is reported",
+			yaml: "prewarm:\n  concurrency: 0\nai:\n  retries: -1\nrate_limits:\n  cheap:\n    user: { burst: 5, every: 0s }\n",
+			env:  map[string]string{"DISCORD_BOT_TOKEN": "token"},
+			wantErr: []string{
+				"prewarm.concurrency must be at least 1",
+				"ai.retries can't be negative",
+				"rate_limits.cheap.user.every must be positive",
+			},
+		},
+		{
+			name:    "openai backend needs a base URL",
+			yaml:    "ai:\n  general:\n    backend: openai\n    models: [llama]\n",
+			env:     map[string]string{"DISCORD_BOT_TOKEN": "token"},
+			wantErr: []string{"ai.general uses the openai backend, which needs openai.base_url (OPENAI_BASE_URL)"},
+		},
+		{
+			name:    "unknown backend",
+			yaml:    "ai:\n  marcus:\n    backend: skynet\n",
+			env:     map[string]string{"DISCORD_BOT_TOKEN": "token"},
+			wantErr: []string{`ai.marcus.backend must be openrouter or openai, got "skynet"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, env := range envVars {
+				t.Setenv(env, tt.env[env])
+			}
+			path := ""
+			if tt.yaml != "" {
+				path = filepath.Join(t.TempDir(), "config.yaml")
+				writeConfig(t, path, tt.yaml)
+			}
+
+			cfg, err := Load(path)
+			if len(tt.wantErr) > 0 {
+				if err == nil {
+					t.Fatalf("Load() succeeded, want errors %q", tt.wantErr)
+				}
+				for _, want := range tt.wantErr {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("Load() error = %q, want it to contain %q", err, want)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to load: %v", err)
+			}
+			if got := tt.got(cfg); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	for _, env := range envVars {
+		t.Setenv(env, "")
+	}
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "discord:\n  token: token\nstorage:\n  audio_dir: /audio\nintros:\n  cooldown: 1m\n")
+	store, err := NewStore(path, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	reloaded := make(chan *Config, 10)
+	store.OnReload(func(c *Config) { reloaded <- c })
+	store.Watch(10 * time.Millisecond)
+
+	// change writes the file with a later modification time, so it's
+	// seen however coarse the file system's timestamps are
+	modTime := time.Now()
+	change := func(yaml string) {
+		writeConfig(t, path, yaml)
+		modTime = modTime.Add(time.Second)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	change("discord:\n  token: new-token\nstorage:\n  audio_dir: /elsewhere\nintros:\n  cooldown: 5m\n")
+	select {
+	case cfg := <-reloaded:
+		if cfg.Intros.Cooldown.Duration() != 5*time.Minute {
+			t.Errorf("cooldown = %s, want the reloaded 5m", cfg.Intros.Cooldown.Duration())
+		}
+		if cfg.Discord.Token != "token" || cfg.Storage.AudioDir != "/audio" {
+			t.Errorf("token and audio dir = %q and %q, want them kept until a restart", cfg.Discord.Token, cfg.Storage.AudioDir)
+		}
+		if store.Get() != cfg {
+			t.Error("the store doesn't have the reloaded config")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the changed config wasn't reloaded")
+	}
+
+	change("intros:\n  cooldown: soon\n")
+	change("discord:\n  token: token\nintros:\n  cooldown: -1m\n")
+	select {
+	case cfg := <-reloaded:
+		t.Errorf("reloaded an invalid config with cooldown %s", cfg.Intros.Cooldown.Duration())
+	case <-time.After(100 * time.Millisecond):
+	}
+	if cooldown := store.Get().Intros.Cooldown.Duration(); cooldown != 5*time.Minute {
+		t.Errorf("cooldown = %s after invalid changes, want 5m kept", cooldown)
+	}
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Store holds the current config. Components keep a *Store and call Get
+// whenever they need a setting, so reloads are picked up without restarting.
+type Store struct {
+	path    string
+	current atomic.Pointer[Config]
+	logger  *slog.Logger
+
+	mu        sync.Mutex
+	modTime   time.Time
+	listeners []func(*Config)
+}
+
+// NewStore loads the config at path (which may be empty to only use the
+// environment).
+func NewStore(path string, logger *slog.Logger) (*Store, error) {
+	cfg, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Store{path: path, logger: logger}
+	s.current.Store(cfg)
+	if stat, err := os.Stat(path); err == nil {
+		s.modTime = stat.ModTime()
+	}
+	return s, nil
+}
+
+// NewStaticStore wraps an already loaded config, it is never reloaded.
+func NewStaticStore(cfg *Config) *Store {
+	s := &Store{logger: slog.Default()}
+	s.current.Store(cfg)
+	return s
+}
+
+func (s *Store) Get() *Config {
+	return s.current.Load()
+}
+
+// OnReload registers fn to be called with the new config after every successful reload.
+func (s *Store) OnReload(fn func(*Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, fn)
+}
+
+// Watch checks the config file for changes every interval and reloads it.
+// Secrets and storage locations are kept from the running config, and a file
+// that fails to load or validate is ignored.
+func (s *Store) Watch(interval time.Duration) {
+	if s.path == "" {
+		return
+	}
+
+	go func() {
+		for range time.Tick(interval) {
+			s.reloadIfChanged()
+		}
+	}()
+}
+
+func (s *Store) reloadIfChanged() {
+	stat, err := os.Stat(s.path)
+	if err != nil {
+		s.logger.Warn("failed to check config file", "path", s.path, "err", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !stat.ModTime().After(s.modTime) {
+		return
+	}
+	s.modTime = stat.ModTime()
+
+	cfg, err := Load(s.path)
+	if err != nil {
+		s.logger.Error("failed to reload config, keeping the current one", "path", s.path, "err", err)
+		return
+	}
+
+	prev := s.Get()
+	if cfg.Storage != prev.Storage || cfg.Discord != prev.Discord {
+		s.logger.Warn("storage and secret settings can't be reloaded, restart to apply them")
+	}
+	cfg = cfg.withRestartOnly(prev)
+
+	s.current.Store(cfg)
+	s.logger.Info("reloaded config", "path", s.path)
+
+	for _, fn := range s.listeners {
+		fn(cfg)
+	}
+}
@@ -0,0 +1,194 @@
+// Package dashboard is the admin web UI for browsing the TTS cache, managing
+// memes, checking usage and editing server settings.
+package dashboard
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"marcus/pkg"
+	"marcus/pkg/config"
+	"marcus/pkg/settings"
+	"marcus/pkg/usage"
+	"marcus/pkg/util"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+)
+
+//go:embed templates static
+var files embed.FS
+
+// cookieName holds a hash of the admin token once someone has logged in.
+const cookieName = "marcus_admin"
+
+// Dashboard serves the admin UI under /admin/.
+type Dashboard struct {
+	Config          *config.Store
+	Memes           *pkg.MemeSet
+	Settings        *settings.Store
+	ElevenLabsUsage *usage.Ledger
+	AIUsage         *usage.RequestLog
+	Logger          *slog.Logger
+
+	client atomic.Pointer[bot.Client]
+	pages  map[string]*template.Template
+}
+
+func New(logger *slog.Logger, cfg *config.Store) *Dashboard {
+	d := &Dashboard{Config: cfg, Logger: logger, pages: map[string]*template.Template{}}
+
+	funcs := template.FuncMap{
+		"bytes": util.FormatBytes,
+		"time":  func(t time.Time) string { return t.Local().Format("2006-01-02 15:04") },
+	}
+	for _, page := range []string{"login", "cache", "memes", "usage", "settings", "guild"} {
+		d.pages[page] = template.Must(template.New("layout.html").Funcs(funcs).ParseFS(files, "templates/layout.html", "templates/"+page+".html"))
+	}
+	return d
+}
+
+// SetClient lets the dashboard name servers and users from the Discord caches.
+func (d *Dashboard) SetClient(client *bot.Client) {
+	d.client.Store(client)
+}
+
+// Register adds the dashboard's routes to mux.
+func (d *Dashboard) Register(mux *http.ServeMux) {
+	static, _ := fs.Sub(files, "static")
+	mux.Handle("GET /admin/static/", http.StripPrefix("/admin/static/", http.FileServerFS(static)))
+	mux.HandleFunc("GET /admin/login", d.loginPage)
+	mux.HandleFunc("POST /admin/login", d.login)
+	mux.HandleFunc("POST /admin/logout", d.logout)
+
+	mux.Handle("GET /admin/{$}", d.auth(http.RedirectHandler("/admin/cache", http.StatusSeeOther)))
+	mux.Handle("GET /admin/cache", d.authFunc(d.cachePage))
+	mux.Handle("GET /admin/cache/audio", d.authFunc(d.cacheAudio))
+	mux.Handle("GET /admin/memes", d.authFunc(d.memesPage))
+	mux.Handle("GET /admin/memes/{name}/audio", d.authFunc(d.memeAudio))
+	mux.Handle("POST /admin/memes", d.authFunc(d.uploadMeme))
+	mux.Handle("POST /admin/memes/{name}/rename", d.authFunc(d.renameMeme))
+	mux.Handle("POST /admin/memes/{name}/delete", d.authFunc(d.deleteMeme))
+	mux.Handle("GET /admin/usage", d.authFunc(d.usagePage))
+	mux.Handle("GET /admin/settings", d.authFunc(d.settingsPage))
+	mux.Handle("GET /admin/settings/{guild}", d.authFunc(d.guildPage))
+	mux.Handle("POST /admin/settings/{guild}", d.authFunc(d.saveSetting))
+}
+
+// page is what every template is given.
+type page struct {
+	Title   string
+	Section string
+	Message string
+	Error   string
+	Data    any
+	// Query is the request's, for filling forms back in.
+	Query url.Values
+}
+
+func (d *Dashboard) render(w http.ResponseWriter, r *http.Request, name string, p page) {
+	p.Section = name
+	p.Query = r.URL.Query()
+	if p.Message == "" {
+		p.Message = p.Query.Get("msg")
+	}
+	if p.Error == "" {
+		p.Error = p.Query.Get("err")
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := d.pages[name].Execute(w, p); err != nil {
+		d.Logger.Error("failed to render dashboard page", "page", name, "err", err)
+	}
+}
+
+// redirect goes back to a page after a form, showing how it went.
+func redirect(w http.ResponseWriter, r *http.Request, path string, err error, message string) {
+	query := url.Values{}
+	if err != nil {
+		query.Set("err", err.Error())
+	} else {
+		query.Set("msg", message)
+	}
+	http.Redirect(w, r, path+"?"+query.Encode(), http.StatusSeeOther)
+}
+
+// tokenHash is what the login cookie holds, so the token itself isn't stored
+// in the browser.
+func (d *Dashboard) tokenHash() string {
+	sum := sha256.Sum256([]byte("marcus-admin:" + d.Config.Get().HTTP.AdminToken))
+	return hex.EncodeToString(sum[:])
+}
+
+// authorized accepts the login cookie or the token as a bearer token.
+func (d *Dashboard) authorized(r *http.Request) bool {
+	token := d.Config.Get().HTTP.AdminToken
+	if token == "" {
+		return false
+	}
+
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) == 1
+	}
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(d.tokenHash())) == 1
+}
+
+func (d *Dashboard) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.authorized(r) {
+			if r.Method == http.MethodGet {
+				http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+				return
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *Dashboard) authFunc(next http.HandlerFunc) http.Handler {
+	return d.auth(next)
+}
+
+func (d *Dashboard) loginPage(w http.ResponseWriter, r *http.Request) {
+	d.render(w, r, "login", page{Title: "Log in"})
+}
+
+func (d *Dashboard) login(w http.ResponseWriter, r *http.Request) {
+	token := d.Config.Get().HTTP.AdminToken
+	if token == "" || subtle.ConstantTimeCompare([]byte(r.PostFormValue("token")), []byte(token)) != 1 {
+		d.Logger.Warn("failed dashboard login", "remote", r.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		d.render(w, r, "login", page{Title: "Log in", Error: "wrong token"})
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    d.tokenHash(),
+		Path:     "/admin",
+		MaxAge:   int((30 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, "/admin/", http.StatusSeeOther)
+}
+
+func (d *Dashboard) logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: cookieName, Path: "/admin", MaxAge: -1})
+	http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+}
@@ -0,0 +1,197 @@
+package dashboard
+
+import (
+	"bytes"
+	"log/slog"
+	"marcus/pkg"
+	"marcus/pkg/config"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testToken = "secret"
+
+// newTestDashboard serves a dashboard with one meme, !airhorn.
+func newTestDashboard(t *testing.T, adminToken string) (*Dashboard, http.Handler) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Storage.AudioDir = t.TempDir()
+	cfg.Storage.MemesDir = t.TempDir()
+	cfg.HTTP.AdminToken = adminToken
+	if err := os.WriteFile(filepath.Join(cfg.Storage.MemesDir, "airhorn.wav"), []byte("airhorn"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	store := config.NewStaticStore(cfg)
+	d := New(slog.New(slog.DiscardHandler), store)
+	d.Memes = &pkg.MemeSet{Map: &sync.Map{}, Config: store}
+	if err := d.Memes.BuildMemeSet(); err != nil {
+		t.Fatalf("failed to load memes: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	d.Register(mux)
+	return d, mux
+}
+
+func TestAuth(t *testing.T) {
+	tests := []struct {
+		name         string
+		adminToken   string
+		method       string
+		path         string
+		bearer       string
+		cookie       bool
+		form         url.Values
+		wantStatus   int
+		wantLocation string
+	}{
+		{name: "no token", adminToken: testToken, method: http.MethodGet, path: "/admin/cache", wantStatus: http.StatusSeeOther, wantLocation: "/admin/login"},
+		{name: "wrong token", adminToken: testToken, method: http.MethodGet, path: "/admin/cache", bearer: "guess", wantStatus: http.StatusSeeOther, wantLocation: "/admin/login"},
+		{name: "token", adminToken: testToken, method: http.MethodGet, path: "/admin/cache", bearer: testToken, wantStatus: http.StatusOK},
+		{name: "cookie", adminToken: testToken, method: http.MethodGet, path: "/admin/memes", cookie: true, wantStatus: http.StatusOK},
+		{name: "form without a token", adminToken: testToken, method: http.MethodPost, path: "/admin/memes/airhorn/delete", wantStatus: http.StatusUnauthorized},
+		{name: "dashboard turned off", method: http.MethodGet, path: "/admin/cache", wantStatus: http.StatusSeeOther, wantLocation: "/admin/login"},
+		{name: "log in", adminToken: testToken, method: http.MethodPost, path: "/admin/login", form: url.Values{"token": {testToken}}, wantStatus: http.StatusSeeOther, wantLocation: "/admin/"},
+		{name: "log in with the wrong token", adminToken: testToken, method: http.MethodPost, path: "/admin/login", form: url.Values{"token": {"guess"}}, wantStatus: http.StatusUnauthorized},
+		{name: "log in when turned off", method: http.MethodPost, path: "/admin/login", form: url.Values{"token": {""}}, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, handler := newTestDashboard(t, tt.adminToken)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.form.Encode()))
+			if tt.form != nil {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if tt.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			if tt.cookie {
+				req.AddCookie(&http.Cookie{Name: cookieName, Value: d.tokenHash()})
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus || rec.Header().Get("Location") != tt.wantLocation {
+				t.Errorf("%s %s = %d to %q, want %d to %q", tt.method, tt.path, rec.Code, rec.Header().Get("Location"), tt.wantStatus, tt.wantLocation)
+			}
+			if _, err := os.Stat(filepath.Join(d.Config.Get().Storage.MemesDir, "airhorn.wav")); err != nil {
+				t.Errorf("!airhorn is gone: %v", err)
+			}
+		})
+	}
+}
+
+func TestRenderErrors(t *testing.T) {
+	d, _ := newTestDashboard(t, testToken)
+
+	for name := range d.pages {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			d.render(rec, httptest.NewRequest(http.MethodGet, "/admin/"+name+"?q=hello", nil), name, page{Title: name, Error: "something broke"})
+
+			body := rec.Body.String()
+			if !strings.Contains(body, `<p class="error">something broke</p>`) || !strings.Contains(body, "</html>") {
+				t.Errorf("the %s page didn't render its error:\n%s", name, body)
+			}
+		})
+	}
+}
+
+func TestMemeForms(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		form    url.Values
+		upload  string
+		wantErr string
+		wantMsg string
+		want    []string
+	}{
+		{name: "rename", path: "/admin/memes/airhorn/rename", form: url.Values{"new_name": {"horn"}}, wantMsg: "renamed !airhorn to horn", want: []string{"horn"}},
+		{name: "rename to an invalid name", path: "/admin/memes/airhorn/rename", form: url.Values{"new_name": {"../horn"}}, wantErr: "'../horn' isn't a valid meme name, use lowercase letters, numbers, - and _", want: []string{"airhorn"}},
+		{name: "rename a missing meme", path: "/admin/memes/nothing/rename", form: url.Values{"new_name": {"horn"}}, wantErr: "there's no meme called 'nothing'", want: []string{"airhorn"}},
+		{name: "delete", path: "/admin/memes/airhorn/delete", wantMsg: "deleted !airhorn"},
+		{name: "delete a missing meme", path: "/admin/memes/nothing/delete", wantErr: "there's no meme called 'nothing'", want: []string{"airhorn"}},
+		{name: "upload", path: "/admin/memes", upload: "bonk", wantMsg: "added !bonk", want: []string{"airhorn", "bonk"}},
+		{name: "upload with an invalid name", path: "/admin/memes", upload: "bonk bonk", wantErr: "'bonk bonk' isn't a valid meme name, use lowercase letters, numbers, - and _", want: []string{"airhorn"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, handler := newTestDashboard(t, testToken)
+
+			var req *http.Request
+			if tt.upload != "" {
+				var body bytes.Buffer
+				form := multipart.NewWriter(&body)
+				_ = form.WriteField("name", tt.upload)
+				file, _ := form.CreateFormFile("file", tt.upload+".wav")
+				_, _ = file.Write([]byte("bonk"))
+				form.Close()
+				req = httptest.NewRequest(http.MethodPost, tt.path, &body)
+				req.Header.Set("Content-Type", form.FormDataContentType())
+			} else {
+				req = httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			req.Header.Set("Authorization", "Bearer "+testToken)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			location, err := url.Parse(rec.Header().Get("Location"))
+			if rec.Code != http.StatusSeeOther || err != nil || location.Path != "/admin/memes" {
+				t.Fatalf("POST %s = %d to %q, want a redirect to the memes page", tt.path, rec.Code, rec.Header().Get("Location"))
+			}
+			if got := location.Query(); got.Get("err") != tt.wantErr || got.Get("msg") != tt.wantMsg {
+				t.Errorf("redirected with err %q and msg %q, want %q and %q", got.Get("err"), got.Get("msg"), tt.wantErr, tt.wantMsg)
+			}
+
+			var memes []string
+			for _, name := range []string{"airhorn", "bonk", "horn"} {
+				if _, ok := d.Memes.Load(name); ok {
+					memes = append(memes, name)
+				}
+			}
+			if !slices.Equal(memes, tt.want) {
+				t.Errorf("memes = %q, want %q", memes, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemeAudio(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+	}{
+		{name: "meme", path: "/admin/memes/airhorn/audio", wantStatus: http.StatusOK},
+		{name: "missing meme", path: "/admin/memes/nothing/audio", wantStatus: http.StatusNotFound},
+		{name: "invalid name", path: "/admin/memes/..%2Fsecret/audio", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, handler := newTestDashboard(t, testToken)
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Authorization", "Bearer "+testToken)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package dashboard
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"marcus/pkg"
+	"marcus/pkg/settings"
+	"marcus/pkg/tts"
+	"marcus/pkg/usage"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const (
+	// maxCacheRows stops a search for nothing from rendering the whole cache.
+	maxCacheRows = 500
+	maxMemeSize  = 20 << 20
+	topSpenders  = 5
+)
+
+func (d *Dashboard) cachePage(w http.ResponseWriter, r *http.Request) {
+	search := r.URL.Query().Get("q")
+	lines, err := tts.ListCache(d.Config.Get().Storage.AudioDir, search, d.Logger)
+	if err != nil {
+		d.render(w, r, "cache", page{Title: "Cache", Error: err.Error()})
+		return
+	}
+
+	d.render(w, r, "cache", page{Title: "Cache", Data: struct {
+		Total int
+		Lines []tts.CachedLine
+	}{len(lines), lines[:min(len(lines), maxCacheRows)]}})
+}
+
+func (d *Dashboard) cacheAudio(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	path, err := tts.CachedAudioPath(d.Config.Get().Storage.AudioDir, query.Get("provider"), query.Get("voice"), query.Get("hash"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "audio/wav")
+	http.ServeFile(w, r, path)
+}
+
+// meme is one row of the memes page.
+type meme struct {
+	Name  string
+	File  string
+	IsDir bool
+}
+
+func (d *Dashboard) memesPage(w http.ResponseWriter, r *http.Request) {
+	if d.Memes == nil {
+		d.render(w, r, "memes", page{Title: "Memes", Error: "memes aren't loaded"})
+		return
+	}
+
+	root := d.Config.Get().Storage.MemesDir
+	var memes []meme
+	d.Memes.Range(func(key, value any) bool {
+		hit := value.(pkg.MemeHit)
+		file, err := filepath.Rel(root, hit.Path)
+		if err != nil {
+			file = hit.Path
+		}
+		memes = append(memes, meme{Name: key.(string), File: file, IsDir: hit.IsDir})
+		return true
+	})
+	slices.SortFunc(memes, func(a, b meme) int { return cmp.Compare(a.Name, b.Name) })
+
+	d.render(w, r, "memes", page{Title: "Memes", Data: memes})
+}
+
+func (d *Dashboard) memeAudio(w http.ResponseWriter, r *http.Request) {
+	if d.Memes == nil {
+		http.NotFound(w, r)
+		return
+	}
+	path, ok := d.Memes.GetMeme(r.PathValue("name"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "audio/wav")
+	http.ServeFile(w, r, path)
+}
+
+func (d *Dashboard) uploadMeme(w http.ResponseWriter, r *http.Request) {
+	if d.Memes == nil {
+		redirect(w, r, "/admin/memes", errors.New("memes aren't loaded"), "")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxMemeSize)
+	name := strings.ToLower(strings.TrimSpace(r.FormValue("name")))
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		redirect(w, r, "/admin/memes", fmt.Errorf("failed to read the upload: %w", err), "")
+		return
+	}
+	defer file.Close()
+
+	if !strings.HasSuffix(strings.ToLower(header.Filename), ".wav") {
+		redirect(w, r, "/admin/memes", errors.New("memes must be .wav files"), "")
+		return
+	}
+
+	err = d.Memes.SaveMeme(name, file)
+	d.Logger.Info("meme uploaded from the dashboard", "name", name, "err", err)
+	redirect(w, r, "/admin/memes", err, fmt.Sprintf("added !%s", name))
+}
+
+func (d *Dashboard) renameMeme(w http.ResponseWriter, r *http.Request) {
+	if d.Memes == nil {
+		redirect(w, r, "/admin/memes", errors.New("memes aren't loaded"), "")
+		return
+	}
+
+	name := r.PathValue("name")
+	newName := strings.ToLower(strings.TrimSpace(r.PostFormValue("new_name")))
+	err := d.Memes.RenameMeme(name, newName)
+	d.Logger.Info("meme renamed from the dashboard", "name", name, "newName", newName, "err", err)
+	redirect(w, r, "/admin/memes", err, fmt.Sprintf("renamed !%s to %s", name, newName))
+}
+
+func (d *Dashboard) deleteMeme(w http.ResponseWriter, r *http.Request) {
+	if d.Memes == nil {
+		redirect(w, r, "/admin/memes", errors.New("memes aren't loaded"), "")
+		return
+	}
+
+	name := r.PathValue("name")
+	err := d.Memes.DeleteMeme(name)
+	d.Logger.Info("meme deleted from the dashboard", "name", name, "err", err)
+	redirect(w, r, "/admin/memes", err, fmt.Sprintf("deleted !%s", name))
+}
+
+// guild is a server Marcus is in.
+type guild struct {
+	ID   snowflake.ID
+	Name string
+}
+
+// guilds returns the servers Marcus is in, by name.
+func (d *Dashboard) guilds() []guild {
+	client := d.client.Load()
+	if client == nil {
+		return nil
+	}
+
+	var guilds []guild
+	for g := range client.Caches.Guilds() {
+		guilds = append(guilds, guild{ID: g.ID, Name: g.Name})
+	}
+	slices.SortFunc(guilds, func(a, b guild) int { return cmp.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)) })
+	return guilds
+}
+
+func (d *Dashboard) guildName(guildID snowflake.ID) string {
+	if client := d.client.Load(); client != nil {
+		if g, ok := client.Caches.Guild(guildID); ok {
+			return g.Name
+		}
+	}
+	return guildID.String()
+}
+
+func (d *Dashboard) userName(guildID, userID snowflake.ID) string {
+	if client := d.client.Load(); client != nil {
+		if member, ok := client.Caches.Member(guildID, userID); ok {
+			return member.EffectiveName()
+		}
+	}
+	return userID.String()
+}
+
+// spender is a user and what they spent, with their name.
+type spender struct {
+	Name   string
+	Amount float64
+}
+
+// guildUsage is this month's spending for one server.
+type guildUsage struct {
+	guild
+	ElevenLabsCharacters float64
+	ElevenLabsSpenders   []spender
+	AI                   usage.Totals
+	AIModels             map[string]usage.Totals
+	AISpenders           []spender
+}
+
+func (d *Dashboard) usagePage(w http.ResponseWriter, r *http.Request) {
+	month := usage.StartOfMonth(time.Now())
+
+	var guilds []guildUsage
+	for _, g := range d.guilds() {
+		u := guildUsage{guild: g}
+		if d.ElevenLabsUsage != nil {
+			u.ElevenLabsCharacters, _ = d.ElevenLabsUsage.Spent(g.ID, 0)
+			for _, s := range d.ElevenLabsUsage.TopSpenders(g.ID, topSpenders) {
+				u.ElevenLabsSpenders = append(u.ElevenLabsSpenders, spender{d.userName(g.ID, s.UserID), s.Amount})
+			}
+		}
+		if d.AIUsage != nil {
+			u.AI = d.AIUsage.Sum(month, func(r usage.Request) bool { return r.GuildID == g.ID })
+			u.AIModels = d.AIUsage.ByModel(g.ID, month)
+			for _, s := range d.AIUsage.TopSpenders(g.ID, month, topSpenders) {
+				u.AISpenders = append(u.AISpenders, spender{d.userName(g.ID, s.UserID), s.Amount})
+			}
+		}
+		guilds = append(guilds, u)
+	}
+
+	var total usage.Totals
+	if d.AIUsage != nil {
+		total = d.AIUsage.Sum(month, func(usage.Request) bool { return true })
+	}
+
+	d.render(w, r, "usage", page{Title: "Usage", Data: struct {
+		Month  time.Time
+		AI     usage.Totals
+		Guilds []guildUsage
+	}{month, total, guilds}})
+}
+
+func (d *Dashboard) settingsPage(w http.ResponseWriter, r *http.Request) {
+	d.render(w, r, "settings", page{Title: "Settings", Data: d.guilds()})
+}
+
+// setting is one row of a server's settings form.
+type setting struct {
+	settings.Setting
+	Value string
+	IsSet bool
+}
+
+func (d *Dashboard) guildPage(w http.ResponseWriter, r *http.Request) {
+	guildID, err := snowflake.Parse(r.PathValue("guild"))
+	if err != nil {
+		http.Error(w, "invalid server ID", http.StatusBadRequest)
+		return
+	}
+
+	current := d.Settings.Get(guildID)
+	rows := make([]setting, 0, len(settings.Settings))
+	for _, s := range settings.Settings {
+		rows = append(rows, setting{Setting: s, Value: current.Get(s.Key), IsSet: current.IsSet(s.Key)})
+	}
+
+	d.render(w, r, "guild", page{Title: d.guildName(guildID), Data: struct {
+		Guild    guild
+		Settings []setting
+	}{guild{guildID, d.guildName(guildID)}, rows}})
+}
+
+func (d *Dashboard) saveSetting(w http.ResponseWriter, r *http.Request) {
+	guildID, err := snowflake.Parse(r.PathValue("guild"))
+	if err != nil {
+		http.Error(w, "invalid server ID", http.StatusBadRequest)
+		return
+	}
+	back := "/admin/settings/" + guildID.String()
+	if d.Settings == nil {
+		redirect(w, r, back, errors.New("settings aren't loaded"), "")
+		return
+	}
+
+	key := r.PostFormValue("key")
+	if r.PostFormValue("action") == "reset" {
+		err := d.Settings.Reset(guildID, key)
+		d.Logger.Info("setting reset from the dashboard", "guildID", guildID, "key", key, "err", err)
+		redirect(w, r, back, err, fmt.Sprintf("reset %s", key))
+		return
+	}
+
+	value, err := d.Settings.Set(guildID, key, r.PostFormValue("value"))
+	d.Logger.Info("setting changed from the dashboard", "guildID", guildID, "key", key, "value", value, "err", err)
+	redirect(w, r, back, err, fmt.Sprintf("set %s to '%s'", key, value))
+}
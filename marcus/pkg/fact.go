@@ -9,29 +9,36 @@ import (
 )
 
 func (c *Command) SayFact() {
-	resp, err := http.Get("https://uselessfacts.jsph.pl/api/v2/facts/random")
+	fact, err := fetchFact()
 	if err != nil {
-		_, err = util.SendMessageInChannel(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("the fact API returned an unexpected error: %v", err))
+		_, err = util.SendMessageInChannel(c.MessageEvent, c.MessageEvent.ChannelID, err.Error())
 		return
 	}
 
-	b, err := io.ReadAll(resp.Body)
+	go util.SendMessageInChannel(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("```\n%s\n```", fact))
+	c.TTS.GenerateAndPlay(c.request(), fact, c.TTSOpts.ChannelName)
+}
+
+// fetchFact returns a random useless fact.
+func fetchFact() (string, error) {
+	resp, err := http.Get("https://uselessfacts.jsph.pl/api/v2/facts/random")
 	if err != nil {
-		_, err = util.SendMessageInChannel(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("failed to read fact response: %v", err))
-		return
+		return "", fmt.Errorf("the fact API returned an unexpected error: %v", err)
 	}
 
+	b, err := io.ReadAll(resp.Body)
 	resp.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to read fact response: %v", err)
+	}
 
 	fact := FactResponse{}
 	err = json.Unmarshal(b, &fact)
 	if err != nil {
-		_, err = util.SendMessageInChannel(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("failed to unmarshal fact response: %v", err))
-		return
+		return "", fmt.Errorf("failed to unmarshal fact response: %v", err)
 	}
 
-	go util.SendMessageInChannel(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("```\n%s\n```", fact.Text))
-	c.TTS.GenerateAndPlay(c.MessageEvent, fact.Text, c.TTSOpts.ChannelName)
+	return fact.Text, nil
 }
 
 // getting free facts from https://uselessfacts.jsph.pl/api/v2/facts/random
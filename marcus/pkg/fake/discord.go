@@ -0,0 +1,302 @@
+// Package fake stands in for Discord, voice connections, ffmpeg and the TTS
+// providers, so commands can be run end to end in tests without a network.
+package fake
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/disgo"
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// Message is a message Marcus sent.
+type Message struct {
+	ID        snowflake.ID
+	ChannelID snowflake.ID
+	Content   string
+	Edited    bool
+	// Followup is set for replies to interactions, which have no channel.
+	Followup bool
+}
+
+// channel is a guild channel the fake knows about.
+type channel struct {
+	ID   snowflake.ID
+	Type discord.ChannelType
+	Name string
+}
+
+type voiceKey struct {
+	guildID, userID snowflake.ID
+}
+
+// Discord is a Discord REST API that records the messages sent and edited,
+// and serves the channels and voice states it is given.
+type Discord struct {
+	Server *httptest.Server
+
+	mu          sync.Mutex
+	nextID      snowflake.ID
+	order       []snowflake.ID
+	messages    map[snowflake.ID]Message
+	channels    map[snowflake.ID][]channel
+	voiceStates map[voiceKey]snowflake.ID
+}
+
+func NewDiscord(t testing.TB) *Discord {
+	d := &Discord{
+		nextID:      1000,
+		messages:    map[snowflake.ID]Message{},
+		channels:    map[snowflake.ID][]channel{},
+		voiceStates: map[voiceKey]snowflake.ID{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /channels/{channel}/messages", d.createMessage)
+	mux.HandleFunc("PATCH /channels/{channel}/messages/{message}", d.editMessage)
+	mux.HandleFunc("POST /webhooks/{application}/{token}", d.createFollowup)
+	mux.HandleFunc("GET /channels/{channel}", d.getChannel)
+	mux.HandleFunc("GET /guilds/{guild}/channels", d.guildChannels)
+	mux.HandleFunc("GET /guilds/{guild}/voice-states/{user}", d.voiceState)
+	d.Server = httptest.NewServer(mux)
+	t.Cleanup(d.Server.Close)
+	return d
+}
+
+// AddTextChannel adds a text channel to the guild.
+func (d *Discord) AddTextChannel(guildID, channelID snowflake.ID, name string) {
+	d.addChannel(guildID, channel{ID: channelID, Type: discord.ChannelTypeGuildText, Name: name})
+}
+
+// AddVoiceChannel adds a voice channel to the guild.
+func (d *Discord) AddVoiceChannel(guildID, channelID snowflake.ID, name string) {
+	d.addChannel(guildID, channel{ID: channelID, Type: discord.ChannelTypeGuildVoice, Name: name})
+}
+
+func (d *Discord) addChannel(guildID snowflake.ID, c channel) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.channels[guildID] = append(d.channels[guildID], c)
+}
+
+// JoinVoice puts the user in a voice channel, or takes them out of voice
+// when channelID is 0.
+func (d *Discord) JoinVoice(guildID, userID, channelID snowflake.ID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if channelID == 0 {
+		delete(d.voiceStates, voiceKey{guildID, userID})
+		return
+	}
+	d.voiceStates[voiceKey{guildID, userID}] = channelID
+}
+
+// Messages returns every message in the order they were first sent, with
+// their latest content.
+func (d *Discord) Messages() []Message {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	messages := make([]Message, 0, len(d.order))
+	for _, id := range d.order {
+		messages = append(messages, d.messages[id])
+	}
+	return messages
+}
+
+// Sent returns the content of every message in the order they were sent.
+func (d *Discord) Sent() []string {
+	var contents []string
+	for _, m := range d.Messages() {
+		contents = append(contents, m.Content)
+	}
+	return contents
+}
+
+// WaitForMessages waits for n messages to have been sent, as replies about
+// playback are sent in the background, failing the test if they aren't.
+func (d *Discord) WaitForMessages(t testing.TB, n int) []Message {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		messages := d.Messages()
+		if len(messages) >= n {
+			return messages
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d messages, want %d", len(messages), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Client returns a bot client that talks to the fake.
+func (d *Discord) Client(t testing.TB) *bot.Client {
+	// the token only has to carry an application ID
+	client, err := disgo.New("MTIz.fake.token",
+		bot.WithLogger(slog.New(slog.DiscardHandler)),
+		bot.WithRestClientConfigOpts(rest.WithURL(d.Server.URL)),
+	)
+	if err != nil {
+		t.Fatalf("failed to create discord client: %v", err)
+	}
+	return client
+}
+
+// MessageCreate returns the event for a user sending content in a guild channel.
+func (d *Discord) MessageCreate(t testing.TB, guildID, channelID, userID snowflake.ID, content string) *events.MessageCreate {
+	return &events.MessageCreate{
+		GenericMessage: &events.GenericMessage{
+			GenericEvent: events.NewGenericEvent(d.Client(t), 0, 0),
+			MessageID:    1,
+			Message: discord.Message{
+				ID:        1,
+				ChannelID: channelID,
+				Content:   content,
+				Author:    discord.User{ID: userID, Username: "tester"},
+			},
+			ChannelID: channelID,
+			GuildID:   &guildID,
+		},
+	}
+}
+
+// messageBody is the part of a sent message the fake cares about.
+type messageBody struct {
+	Content string `json:"content"`
+}
+
+func readContent(r *http.Request) string {
+	body, _ := io.ReadAll(r.Body)
+	var msg messageBody
+	_ = json.Unmarshal(body, &msg)
+	return msg.Content
+}
+
+func (d *Discord) createMessage(w http.ResponseWriter, r *http.Request) {
+	channelID, _ := snowflake.Parse(r.PathValue("channel"))
+	writeJSON(w, http.StatusOK, d.record(Message{ChannelID: channelID, Content: readContent(r)}))
+}
+
+func (d *Discord) createFollowup(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, d.record(Message{Content: readContent(r), Followup: true}))
+}
+
+func (d *Discord) record(m Message) map[string]any {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.nextID++
+	m.ID = d.nextID
+	d.order = append(d.order, m.ID)
+	d.messages[m.ID] = m
+	return messageJSON(m)
+}
+
+func (d *Discord) editMessage(w http.ResponseWriter, r *http.Request) {
+	id, _ := snowflake.Parse(r.PathValue("message"))
+	content := readContent(r)
+
+	d.mu.Lock()
+	m, ok := d.messages[id]
+	if ok {
+		m.Content, m.Edited = content, true
+		d.messages[id] = m
+	}
+	d.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, 10008, "Unknown Message")
+		return
+	}
+	writeJSON(w, http.StatusOK, messageJSON(m))
+}
+
+func messageJSON(m Message) map[string]any {
+	return map[string]any{
+		"id":         m.ID.String(),
+		"channel_id": m.ChannelID.String(),
+		"content":    m.Content,
+	}
+}
+
+func (d *Discord) getChannel(w http.ResponseWriter, r *http.Request) {
+	channelID, _ := snowflake.Parse(r.PathValue("channel"))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for guildID, channels := range d.channels {
+		for _, c := range channels {
+			if c.ID == channelID {
+				writeJSON(w, http.StatusOK, c.json(guildID))
+				return
+			}
+		}
+	}
+	writeError(w, http.StatusNotFound, 10003, "Unknown Channel")
+}
+
+func (d *Discord) guildChannels(w http.ResponseWriter, r *http.Request) {
+	guildID, _ := snowflake.Parse(r.PathValue("guild"))
+
+	d.mu.Lock()
+	channels := make([]map[string]any, 0, len(d.channels[guildID]))
+	for _, c := range d.channels[guildID] {
+		channels = append(channels, c.json(guildID))
+	}
+	d.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, channels)
+}
+
+func (c channel) json(guildID snowflake.ID) map[string]any {
+	return map[string]any{
+		"id":       c.ID.String(),
+		"type":     c.Type,
+		"guild_id": guildID.String(),
+		"name":     c.Name,
+	}
+}
+
+func (d *Discord) voiceState(w http.ResponseWriter, r *http.Request) {
+	guildID, _ := snowflake.Parse(r.PathValue("guild"))
+	userID, _ := snowflake.Parse(r.PathValue("user"))
+
+	d.mu.Lock()
+	channelID, ok := d.voiceStates[voiceKey{guildID, userID}]
+	d.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, 10065, "Unknown Voice State")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"guild_id":   guildID.String(),
+		"channel_id": channelID.String(),
+		"user_id":    userID.String(),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError responds like Discord does, with a JSON error code.
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	writeJSON(w, status, map[string]any{"code": code, "message": message})
+}
@@ -0,0 +1,81 @@
+package fake
+
+import (
+	"io"
+	"marcus/pkg/tts"
+	"slices"
+	"sync"
+
+	"github.com/caffeinatedtoad/dca"
+)
+
+// Generator is a TTS provider whose audio is the voice and text it was
+// asked for, so tests can tell clips apart.
+type Generator struct {
+	// Provider is the generator's name, "fake" if unset.
+	Provider string
+	// Voices are the voices it supports, every voice if nil.
+	Voices []string
+	// Err, when set, is returned instead of audio.
+	Err error
+
+	mu     sync.Mutex
+	spoken []string
+}
+
+func (g *Generator) Name() string {
+	if g.Provider == "" {
+		return "fake"
+	}
+	return g.Provider
+}
+
+func (g *Generator) SupportsVoice(voice string) bool {
+	return g.Voices == nil || slices.Contains(g.Voices, voice)
+}
+
+func (g *Generator) ListSupportedVoices() ([]string, error) {
+	return g.Voices, nil
+}
+
+func (g *Generator) GenerateTTS(input, voice string) ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.spoken = append(g.spoken, input)
+	if g.Err != nil {
+		return nil, g.Err
+	}
+	return []byte(voice + ": " + input), nil
+}
+
+// Spoken returns everything it was asked to say, in order.
+func (g *Generator) Spoken() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return slices.Clone(g.spoken)
+}
+
+// FrameSize is how many bytes of audio Encode puts in each frame.
+const FrameSize = 16
+
+// Encode is a tts.Encoder that doesn't need ffmpeg, splitting the audio into
+// frames as it is.
+func Encode(audio []byte, _ *dca.EncodeOptions) (tts.OpusFrames, error) {
+	return &frames{chunks: slices.Collect(slices.Chunk(audio, FrameSize))}, nil
+}
+
+type frames struct {
+	chunks [][]byte
+}
+
+func (f *frames) OpusFrame() ([]byte, error) {
+	if len(f.chunks) == 0 {
+		return nil, io.EOF
+	}
+	frame := f.chunks[0]
+	f.chunks = f.chunks[1:]
+	return frame, nil
+}
+
+func (f *frames) Cleanup() {}
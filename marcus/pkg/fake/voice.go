@@ -0,0 +1,251 @@
+package fake
+
+import (
+	"bytes"
+	"context"
+	"iter"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/disgo/gateway"
+	"github.com/disgoorg/disgo/voice"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// silenceFrame is what the connection manager sends after each clip.
+var silenceFrame = []byte{0xF8, 0xFF, 0xFE}
+
+// Clip is the opus frames written for one clip, up to the silence sent
+// after it.
+type Clip struct {
+	GuildID   snowflake.ID
+	ChannelID snowflake.ID
+	Frames    [][]byte
+}
+
+// Audio joins the clip's frames back together.
+func (c Clip) Audio() []byte {
+	return bytes.Join(c.Frames, nil)
+}
+
+// VoiceManager is a voice.Manager whose connections open instantly and
+// capture the frames written to them.
+type VoiceManager struct {
+	// OpenErr, when set, is returned when joining a voice channel.
+	OpenErr error
+	// WriteErr, when set, is returned for every frame written.
+	WriteErr error
+
+	mu    sync.Mutex
+	conns map[snowflake.ID]*Conn
+	joins []snowflake.ID
+	clips []Clip
+}
+
+func NewVoiceManager() *VoiceManager {
+	return &VoiceManager{conns: map[snowflake.ID]*Conn{}}
+}
+
+func (m *VoiceManager) HandleVoiceStateUpdate(gateway.EventVoiceStateUpdate)   {}
+func (m *VoiceManager) HandleVoiceServerUpdate(gateway.EventVoiceServerUpdate) {}
+
+func (m *VoiceManager) CreateConn(guildID snowflake.ID) voice.Conn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	conn := &Conn{manager: m, guildID: guildID, closed: make(chan struct{})}
+	conn.udp = &udpConn{conn: conn}
+	m.conns[guildID] = conn
+	return conn
+}
+
+func (m *VoiceManager) GetConn(guildID snowflake.ID) voice.Conn {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if conn, ok := m.conns[guildID]; ok {
+		return conn
+	}
+	return nil
+}
+
+func (m *VoiceManager) Conns() iter.Seq[voice.Conn] {
+	m.mu.Lock()
+	conns := slices.Collect(maps.Values(m.conns))
+	m.mu.Unlock()
+
+	return func(yield func(voice.Conn) bool) {
+		for _, conn := range conns {
+			if !yield(conn) {
+				return
+			}
+		}
+	}
+}
+
+func (m *VoiceManager) RemoveConn(guildID snowflake.ID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.conns, guildID)
+}
+
+func (m *VoiceManager) Close(ctx context.Context) {
+	for conn := range m.Conns() {
+		conn.Close(ctx)
+	}
+}
+
+// Joins returns every voice channel joined, in order.
+func (m *VoiceManager) Joins() []snowflake.ID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.joins)
+}
+
+// Clips returns every clip played so far, in order.
+func (m *VoiceManager) Clips() []Clip {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.clips)
+}
+
+// WaitForClips waits for n clips to have been played, as playback happens in
+// the background, failing the test if they don't arrive in time.
+func (m *VoiceManager) WaitForClips(t testing.TB, n int) []Clip {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		clips := m.Clips()
+		if len(clips) >= n {
+			return clips
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d clips, want %d", len(clips), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Conn is a voice connection that records the frames written to it. Only the
+// methods the connection manager uses are implemented.
+type Conn struct {
+	voice.Conn
+
+	manager *VoiceManager
+	guildID snowflake.ID
+	udp     *udpConn
+
+	mu        sync.Mutex
+	channelID *snowflake.ID
+	frames    [][]byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *Conn) UDP() voice.UDPConn {
+	return c.udp
+}
+
+func (c *Conn) GuildID() snowflake.ID {
+	return c.guildID
+}
+
+func (c *Conn) ChannelID() *snowflake.ID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.channelID
+}
+
+func (c *Conn) Open(_ context.Context, channelID snowflake.ID, _, _ bool) error {
+	if c.manager.OpenErr != nil {
+		return c.manager.OpenErr
+	}
+
+	c.mu.Lock()
+	c.channelID = &channelID
+	c.mu.Unlock()
+
+	c.manager.mu.Lock()
+	c.manager.joins = append(c.manager.joins, channelID)
+	c.manager.mu.Unlock()
+	return nil
+}
+
+func (c *Conn) SetSpeaking(context.Context, voice.SpeakingFlags) error {
+	return nil
+}
+
+// Close disconnects, keeping anything written since the last clip as a clip
+// that was cut off.
+func (c *Conn) Close(context.Context) {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.endClip()
+
+		c.manager.mu.Lock()
+		if c.manager.conns[c.guildID] == c {
+			delete(c.manager.conns, c.guildID)
+		}
+		c.manager.mu.Unlock()
+	})
+}
+
+func (c *Conn) write(frame []byte) {
+	if bytes.Equal(frame, silenceFrame) {
+		c.endClip()
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.frames = append(c.frames, bytes.Clone(frame))
+}
+
+func (c *Conn) endClip() {
+	c.mu.Lock()
+	frames, channelID := c.frames, c.channelID
+	c.frames = nil
+	c.mu.Unlock()
+
+	if len(frames) == 0 || channelID == nil {
+		return
+	}
+
+	c.manager.mu.Lock()
+	defer c.manager.mu.Unlock()
+	c.manager.clips = append(c.manager.clips, Clip{GuildID: c.guildID, ChannelID: *channelID, Frames: frames})
+}
+
+// udpConn sends frames to its Conn and never receives any.
+type udpConn struct {
+	voice.UDPConn
+	conn *Conn
+}
+
+func (u *udpConn) Write(p []byte) (int, error) {
+	select {
+	case <-u.conn.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if u.conn.manager.WriteErr != nil {
+		return 0, u.conn.manager.WriteErr
+	}
+
+	u.conn.write(p)
+	return len(p), nil
+}
+
+func (u *udpConn) ReadPacket() (*voice.Packet, error) {
+	<-u.conn.closed
+	return nil, net.ErrClosed
+}
+
+func (u *udpConn) Close() error {
+	return nil
+}
@@ -0,0 +1,112 @@
+package pkg
+
+import (
+	"fmt"
+	"marcus/pkg/settings"
+	"marcus/pkg/util"
+	"strings"
+)
+
+const configUsage = "```\nUsage: !config [list] - shows every setting for this server\n" +
+	"       !config get <setting> - shows a setting\n" +
+	"       !config set <setting> <value> - changes a setting\n" +
+	"       !config reset <setting> - puts a setting back to its default\n\n" +
+	"Only people who can manage the server can use !config.\n```"
+
+func (c *Command) ConfigureSettings() {
+	if c.GuildSettings == nil {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, "Server settings are not available right now.", "failed to send config")
+		return
+	}
+
+	if !util.IsAdmin(c.MessageEvent) {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, "Only people who can manage the server can change its settings.", "failed to send config")
+		return
+	}
+
+	guildID := *c.MessageEvent.GuildID
+	action, rest, _ := strings.Cut(c.TTSOpts.Content, " ")
+	key, value, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	key = strings.ToLower(key)
+
+	switch strings.ToLower(action) {
+	case "", "list":
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, formatSettings(c.guild), "failed to list config")
+	case "get":
+		setting, ok := settings.Lookup(key)
+		if !ok {
+			util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, unknownSetting(key), "failed to send config")
+			return
+		}
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("`%s` is %s\n%s", key, displayValue(c.guild, setting), setting.Description), "failed to send config")
+	case "set":
+		if _, ok := settings.Lookup(key); !ok {
+			util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, unknownSetting(key), "failed to send config")
+			return
+		}
+
+		value = strings.TrimSpace(value)
+		if key == settings.KeyDefaultVoice && value != "" {
+			if _, err := c.GetGeneratorForVoice(strings.ToLower(value)); err != nil {
+				util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("Unknown voice '%s', try v!voices", value), "failed to send config")
+				return
+			}
+		}
+
+		saved, err := c.GuildSettings.Set(guildID, key, value)
+		if err != nil {
+			c.Logger.Error("failed to set guild setting", "key", key, "err", err)
+			util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("failed to set %s: %v", key, err), "failed to set config")
+			return
+		}
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("`%s` is now %s", key, quoteValue(saved)), "failed to send config")
+	case "reset":
+		setting, ok := settings.Lookup(key)
+		if !ok {
+			util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, unknownSetting(key), "failed to send config")
+			return
+		}
+
+		if err := c.GuildSettings.Reset(guildID, key); err != nil {
+			c.Logger.Error("failed to reset guild setting", "key", key, "err", err)
+			util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("failed to reset %s: %v", key, err), "failed to reset config")
+			return
+		}
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("`%s` is back to %s", key, quoteValue(setting.Default)), "failed to send config")
+	default:
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, configUsage, "failed to send usage for config")
+	}
+}
+
+func formatSettings(guild settings.Guild) string {
+	b := strings.Builder{}
+	b.WriteString("Settings for this server:\n")
+	for _, setting := range settings.Settings {
+		b.WriteString(fmt.Sprintf("`%s` %s\n", setting.Key, displayValue(guild, setting)))
+	}
+	b.WriteString("\nUse `!config get <setting>` to see what a setting does.")
+	return b.String()
+}
+
+func displayValue(guild settings.Guild, setting settings.Setting) string {
+	value := quoteValue(guild.Get(setting.Key))
+	if !guild.IsSet(setting.Key) {
+		value += " (default)"
+	}
+	return value
+}
+
+func quoteValue(value string) string {
+	if value == "" {
+		return "not set"
+	}
+	return fmt.Sprintf("`%s`", value)
+}
+
+func unknownSetting(key string) string {
+	keys := make([]string, 0, len(settings.Settings))
+	for _, setting := range settings.Settings {
+		keys = append(keys, setting.Key)
+	}
+	return fmt.Sprintf("Unknown setting '%s', try one of: %s", key, strings.Join(keys, ", "))
+}
@@ -0,0 +1,178 @@
+// Package health serves /healthz and /readyz, reporting whether Marcus is
+// connected to Discord and able to make and play audio.
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"marcus/pkg/config"
+	"marcus/pkg/tts"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/gateway"
+)
+
+// stuckAfter is how long the gateway can go without being ready or sending
+// an event before /healthz fails and the bot gets restarted.
+const stuckAfter = 5 * time.Minute
+
+// Checker tracks the bot's state for the health endpoints.
+type Checker struct {
+	Config      *config.Store
+	Connections *tts.ConnectionManager
+	Logger      *slog.Logger
+
+	client    atomic.Pointer[bot.Client]
+	started   time.Time
+	lastEvent atomic.Int64
+}
+
+func NewChecker(logger *slog.Logger, cfg *config.Store) *Checker {
+	return &Checker{Config: cfg, Logger: logger, started: time.Now()}
+}
+
+// SetClient starts checking the client's gateway connection.
+func (h *Checker) SetClient(client *bot.Client) {
+	h.client.Store(client)
+}
+
+// OnEvent records that the gateway sent an event. Register it as an event listener.
+func (h *Checker) OnEvent(bot.Event) {
+	h.lastEvent.Store(time.Now().UnixNano())
+}
+
+// Check is the result of one readiness check.
+type Check struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Report is what the health endpoints respond with.
+type Report struct {
+	OK        bool             `json:"ok"`
+	Gateway   string           `json:"gateway"`
+	LastEvent *time.Time       `json:"last_event,omitempty"`
+	Checks    map[string]Check `json:"checks"`
+	// VoiceConnections maps guild IDs to the voice channel Marcus is in.
+	VoiceConnections map[string]string `json:"voice_connections"`
+}
+
+// Report runs every check. It's OK when Marcus is ready to take commands.
+func (h *Checker) Report() Report {
+	r := Report{
+		Gateway:          h.gatewayStatus().String(),
+		Checks:           map[string]Check{},
+		VoiceConnections: map[string]string{},
+	}
+	if last := h.lastEventTime(); !last.IsZero() {
+		r.LastEvent = &last
+	}
+
+	r.Checks["gateway"] = Check{OK: h.gatewayStatus() == gateway.StatusReady, Detail: r.Gateway}
+	r.Checks["tts"] = h.checkGenerators()
+	storage := h.Config.Get().Storage
+	r.Checks["audio_dir"] = checkWritable(storage.AudioDir)
+	r.Checks["memes_dir"] = checkWritable(storage.MemesDir)
+
+	if h.Connections != nil {
+		for guildID, channelID := range h.Connections.Channels() {
+			r.VoiceConnections[guildID.String()] = channelID.String()
+		}
+	}
+
+	r.OK = true
+	for _, check := range r.Checks {
+		r.OK = r.OK && check.OK
+	}
+	return r
+}
+
+// Live reports whether the bot is still working, even if it isn't ready. It
+// only fails when the gateway has been down with no events for stuckAfter.
+func (h *Checker) Live() bool {
+	if h.gatewayStatus() == gateway.StatusReady {
+		return true
+	}
+	since := h.started
+	if last := h.lastEventTime(); last.After(since) {
+		since = last
+	}
+	return time.Since(since) < stuckAfter
+}
+
+// Healthz fails when the bot is stuck and should be restarted.
+func (h *Checker) Healthz(w http.ResponseWriter, _ *http.Request) {
+	report := h.Report()
+	report.OK = h.Live()
+	h.respond(w, report)
+}
+
+// Readyz fails when any check does.
+func (h *Checker) Readyz(w http.ResponseWriter, _ *http.Request) {
+	h.respond(w, h.Report())
+}
+
+func (h *Checker) respond(w http.ResponseWriter, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	if !report.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		h.Logger.Error("failed to write health report", "err", err)
+	}
+}
+
+func (h *Checker) gatewayStatus() gateway.Status {
+	client := h.client.Load()
+	if client == nil || client.Gateway == nil {
+		return gateway.StatusUnconnected
+	}
+	return client.Gateway.Status()
+}
+
+func (h *Checker) lastEventTime() time.Time {
+	nanos := h.lastEvent.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// checkGenerators passes when a generator that makes new audio is working.
+// Without an ElevenLabs key only cached audio can be played, which is fine.
+func (h *Checker) checkGenerators() Check {
+	if h.Config.Get().ElevenLabs.APIKey == "" {
+		return Check{OK: true, Detail: "no generators configured, only cached audio can be played"}
+	}
+
+	setup := tts.GeneratorSetup()
+	if len(setup) == 0 {
+		return Check{Detail: "generators haven't been set up yet"}
+	}
+
+	var failures []string
+	for name, err := range setup {
+		if err == nil {
+			return Check{OK: true, Detail: fmt.Sprintf("%s is working", name)}
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+	}
+	return Check{Detail: fmt.Sprintf("no generators are working: %s", strings.Join(failures, "; "))}
+}
+
+// checkWritable makes sure a file can be created in dir.
+func checkWritable(dir string) Check {
+	f, err := os.CreateTemp(dir, ".healthcheck-*")
+	if err != nil {
+		return Check{Detail: fmt.Sprintf("%s isn't writable: %v", dir, err)}
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return Check{OK: true, Detail: dir}
+}
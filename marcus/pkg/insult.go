@@ -40,7 +40,7 @@ func (c *Command) SayInsult() {
 	}
 
 	go util.SendMessageInChannel(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("```\n%s\n```", rb.Insult))
-	c.TTS.GenerateAndPlay(c.MessageEvent, rb.Insult, c.TTSOpts.ChannelName)
+	c.TTS.GenerateAndPlay(c.request(), rb.Insult, c.TTSOpts.ChannelName)
 }
 
 // getting insults from https://evilinsult.com/generate_insult.php?lang=en&type=json
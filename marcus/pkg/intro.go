@@ -0,0 +1,293 @@
+package pkg
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"marcus/pkg/config"
+	"marcus/pkg/tts"
+	"marcus/pkg/util"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const introUsage = "```\nUsage: !intro - shows your intro\n" +
+	"       !intro set <meme> - plays a meme when you join a voice channel\n" +
+	"       !intro say [text] - says text when you join, defaults to \"Hello <your name>\"\n" +
+	"       !intro voice <voice> - the voice used for !intro say, see v!voices\n" +
+	"       !intro off|on - stops or resumes your intro without losing it\n" +
+	"       !intro clear - removes your intro\n```"
+
+// Intro is how a user is greeted when they join a voice channel. Meme takes
+// priority over Text if both are set.
+type Intro struct {
+	Meme     string `json:"meme,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Voice    string `json:"voice,omitempty"`
+	Greeting bool   `json:"greeting,omitempty"` // say "Hello <name>"
+	OptOut   bool   `json:"opt_out,omitempty"`
+}
+
+func (i Intro) configured() bool {
+	return i.Meme != "" || i.Text != "" || i.Greeting
+}
+
+func (i Intro) String() string {
+	status := ""
+	if i.OptOut {
+		status = " (turned off)"
+	}
+
+	switch {
+	case i.Meme != "":
+		return fmt.Sprintf("the meme '%s'%s", i.Meme, status)
+	case i.Text != "":
+		return fmt.Sprintf("'%s' in the %s voice%s", i.Text, i.voice(), status)
+	case i.Greeting:
+		return fmt.Sprintf("a greeting in the %s voice%s", i.voice(), status)
+	}
+	return "nothing"
+}
+
+func (i Intro) voice() string {
+	if i.Voice == "" {
+		return "default"
+	}
+	return i.Voice
+}
+
+// IntroStore keeps per-user intros for every guild, saving changes to disk
+// so they survive restarts. Cooldowns are only kept in memory, and stop
+// greetings from replaying when someone's connection drops and they rejoin.
+type IntroStore struct {
+	sync.Mutex
+	Config *config.Store
+
+	path       string
+	intros     map[snowflake.ID]map[snowflake.ID]Intro // guild -> user -> intro
+	lastPlayed map[[2]snowflake.ID]time.Time
+	logger     *slog.Logger
+}
+
+// NewIntroStore loads intros from the audio directory, starting empty if the file does not exist yet.
+func NewIntroStore(cfg *config.Store, logger *slog.Logger) (*IntroStore, error) {
+	i := &IntroStore{
+		Config:     cfg,
+		path:       filepath.Join(cfg.Get().Storage.AudioDir, "intros.json"),
+		intros:     map[snowflake.ID]map[snowflake.ID]Intro{},
+		lastPlayed: map[[2]snowflake.ID]time.Time{},
+		logger:     logger,
+	}
+
+	data, err := os.ReadFile(i.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return i, nil
+		}
+		return nil, fmt.Errorf("failed to read intros: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &i.intros); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal intros: %w", err)
+	}
+	if i.intros == nil {
+		i.intros = map[snowflake.ID]map[snowflake.ID]Intro{}
+	}
+
+	return i, nil
+}
+
+func (i *IntroStore) Get(guildID, userID snowflake.ID) Intro {
+	i.Lock()
+	defer i.Unlock()
+
+	return i.intros[guildID][userID]
+}
+
+// Update applies fn to the user's intro and saves the result.
+func (i *IntroStore) Update(guildID, userID snowflake.ID, fn func(intro *Intro)) error {
+	i.Lock()
+	defer i.Unlock()
+
+	intro := i.intros[guildID][userID]
+	fn(&intro)
+
+	if i.intros[guildID] == nil {
+		i.intros[guildID] = map[snowflake.ID]Intro{}
+	}
+	if intro == (Intro{}) {
+		delete(i.intros[guildID], userID)
+	} else {
+		i.intros[guildID][userID] = intro
+	}
+	return i.save()
+}
+
+// Due returns the user's intro if they have one, haven't opted out and
+// haven't been greeted within the cooldown. Returning true starts the cooldown.
+func (i *IntroStore) Due(guildID, userID snowflake.ID) (Intro, bool) {
+	i.Lock()
+	defer i.Unlock()
+
+	intro, ok := i.intros[guildID][userID]
+	if !ok || intro.OptOut || !intro.configured() {
+		return Intro{}, false
+	}
+
+	key := [2]snowflake.ID{guildID, userID}
+	if time.Since(i.lastPlayed[key]) < i.Config.Get().Intros.Cooldown.Duration() {
+		return Intro{}, false
+	}
+	i.lastPlayed[key] = time.Now()
+
+	return intro, true
+}
+
+// save writes the intros atomically using a temp file + rename, the caller must hold the lock.
+func (i *IntroStore) save() error {
+	if err := os.MkdirAll(filepath.Dir(i.path), 0755); err != nil {
+		return fmt.Errorf("failed to create intros directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(i.intros, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal intros: %w", err)
+	}
+
+	tempPath := i.path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write temp intros file: %w", err)
+	}
+
+	if err := os.Rename(tempPath, i.path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rename intros file: %w", err)
+	}
+
+	i.logger.Info("saved intros", "path", i.path)
+	return nil
+}
+
+// Greet plays the user's intro in the channel they just joined, if one is due.
+func (i *IntroStore) Greet(t *tts.TTS, memes *MemeSet, guildID, channelID, userID snowflake.ID, name string) {
+	intro, due := i.Due(guildID, userID)
+	if !due {
+		return
+	}
+
+	logger := t.Logger.With("guildID", guildID, "userID", userID, "channelID", channelID)
+
+	var audio []byte
+	var err error
+	switch {
+	case intro.Meme != "":
+		meme, found := memes.GetMeme(intro.Meme)
+		if !found {
+			logger.Warn("intro meme no longer exists", "meme", intro.Meme)
+			return
+		}
+		audio, err = os.ReadFile(meme)
+	case intro.Text != "":
+		audio, err = t.Generate(cmp.Or(intro.Voice, t.DefaultVoice()), intro.Text, nil)
+	default:
+		audio, err = t.Generate(cmp.Or(intro.Voice, t.DefaultVoice()), fmt.Sprintf("Hello %s", name), nil)
+	}
+	if err != nil {
+		logger.Error("failed to load intro", "err", err)
+		return
+	}
+
+	logger.Info("playing intro", "intro", intro.String())
+	if err := t.PlayInChannel(guildID, channelID, userID, audio); err != nil {
+		logger.Error("failed to play intro", "err", err)
+	}
+}
+
+func (c *Command) ConfigureIntro() {
+	if c.Intros == nil {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, "Intros are not available right now.", "failed to send intro")
+		return
+	}
+
+	guildID := *c.MessageEvent.GuildID
+	userID := c.MessageEvent.Message.Author.ID
+	action, arg, _ := strings.Cut(c.TTSOpts.Content, " ")
+	arg = strings.TrimSpace(arg)
+
+	var update func(intro *Intro)
+	var reply string
+
+	switch strings.ToLower(action) {
+	case "":
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("Your intro is %s", c.Intros.Get(guildID, userID)), "failed to send intro")
+		return
+	case "set":
+		if arg == "" {
+			util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, introUsage, "failed to send usage for intro")
+			return
+		}
+		arg = strings.TrimPrefix(arg, "!")
+		if _, found := c.MemeSet.GetMeme(arg); !found {
+			util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("There's no meme called '%s', try !list-memes", arg), "failed to send intro")
+			return
+		}
+		update = func(intro *Intro) {
+			intro.Meme, intro.Text, intro.Greeting = arg, "", false
+		}
+		reply = fmt.Sprintf("Your intro is now the meme '%s'", arg)
+	case "say":
+		if len(arg) > 300 {
+			util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, "Intros must be 300 characters or less.", "failed to send intro")
+			return
+		}
+		update = func(intro *Intro) {
+			intro.Meme, intro.Text, intro.Greeting = "", arg, arg == ""
+		}
+		reply = "Your intro will greet you by name"
+		if arg != "" {
+			reply = fmt.Sprintf("Your intro will say '%s'", arg)
+		}
+	case "voice":
+		voice := strings.ToLower(arg)
+		if _, err := c.GetGeneratorForVoice(voice); err != nil {
+			util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("Unknown voice '%s', try v!voices", arg), "failed to send intro")
+			return
+		}
+		update = func(intro *Intro) {
+			intro.Voice = voice
+		}
+		reply = fmt.Sprintf("Your intro will use the %s voice", voice)
+	case "off":
+		update = func(intro *Intro) {
+			intro.OptOut = true
+		}
+		reply = "Your intro is turned off"
+	case "on":
+		update = func(intro *Intro) {
+			intro.OptOut = false
+		}
+		reply = "Your intro is turned on"
+	case "clear":
+		update = func(intro *Intro) {
+			*intro = Intro{}
+		}
+		reply = "Your intro has been removed"
+	default:
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, introUsage, "failed to send usage for intro")
+		return
+	}
+
+	if err := c.Intros.Update(guildID, userID, update); err != nil {
+		c.Logger.Error("failed to update intro", "err", err)
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("failed to update intro: %v", err), "failed to update intro")
+		return
+	}
+
+	util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, reply, "failed to send intro")
+}
@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"log/slog"
+	"marcus/pkg/config"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+func TestIntroDue(t *testing.T) {
+	tests := []struct {
+		name     string
+		intro    *Intro
+		cooldown time.Duration
+		// playedAgo is how long ago the intro last played, zero for never
+		playedAgo time.Duration
+		wantDue   bool
+	}{
+		{name: "meme", intro: &Intro{Meme: "bruh"}, cooldown: 10 * time.Minute, wantDue: true},
+		{name: "text", intro: &Intro{Text: "hi"}, cooldown: 10 * time.Minute, wantDue: true},
+		{name: "greeting", intro: &Intro{Greeting: true}, cooldown: 10 * time.Minute, wantDue: true},
+		{name: "no intro", wantDue: false},
+		{name: "only a voice", intro: &Intro{Voice: "liam"}, wantDue: false},
+		{name: "opted out", intro: &Intro{Meme: "bruh", OptOut: true}, wantDue: false},
+		{name: "within the cooldown", intro: &Intro{Meme: "bruh"}, cooldown: 10 * time.Minute, playedAgo: 5 * time.Minute, wantDue: false},
+		{name: "after the cooldown", intro: &Intro{Meme: "bruh"}, cooldown: 10 * time.Minute, playedAgo: 11 * time.Minute, wantDue: true},
+		{name: "no cooldown", intro: &Intro{Meme: "bruh"}, playedAgo: time.Second, wantDue: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Storage.AudioDir = t.TempDir()
+			cfg.Intros.Cooldown = config.Duration(tt.cooldown)
+			intros, err := NewIntroStore(config.NewStaticStore(cfg), slog.New(slog.DiscardHandler))
+			if err != nil {
+				t.Fatalf("failed to create intro store: %v", err)
+			}
+			if tt.intro != nil {
+				if err := intros.Update(testGuildID, testUserID, func(intro *Intro) { *intro = *tt.intro }); err != nil {
+					t.Fatalf("failed to set intro: %v", err)
+				}
+			}
+			if tt.playedAgo > 0 {
+				intros.lastPlayed[[2]snowflake.ID{testGuildID, testUserID}] = time.Now().Add(-tt.playedAgo)
+			}
+
+			intro, due := intros.Due(testGuildID, testUserID)
+			if due != tt.wantDue {
+				t.Fatalf("due = %v, want %v", due, tt.wantDue)
+			}
+			if !due {
+				return
+			}
+			if intro != *tt.intro {
+				t.Errorf("intro = %+v, want %+v", intro, *tt.intro)
+			}
+
+			// playing starts the cooldown for this server only
+			if _, due := intros.Due(testGuildID, testUserID); due && tt.cooldown > 0 {
+				t.Error("the intro is due again straight after playing")
+			}
+			if err := intros.Update(testGuildID+1, testUserID, func(intro *Intro) { *intro = *tt.intro }); err != nil {
+				t.Fatalf("failed to set intro: %v", err)
+			}
+			if _, due := intros.Due(testGuildID+1, testUserID); !due {
+				t.Error("the intro isn't due in another server")
+			}
+		})
+	}
+}
@@ -10,25 +10,34 @@ import (
 )
 
 func (c *Command) SayJoke() {
-	resp, err := http.Get("https://v2.jokeapi.dev/joke/Miscellaneous,Dark?blacklistFlags=nsfw,religious,political,racist,sexist,explicit&type=single")
+	joke, err := fetchJoke()
 	if err != nil {
-		_, err = util.SendMessageInChannel(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("the joke API returned an unexpected error: %v", err))
+		_, err = util.SendMessageInChannel(c.MessageEvent, c.MessageEvent.ChannelID, err.Error())
 		return
 	}
 
+	go util.SendMessageInChannel(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("```\n%s\n```", joke))
+	c.TTS.GenerateAndPlay(c.request(), joke, c.TTSOpts.ChannelName)
+}
+
+// fetchJoke returns a random joke with a short intro.
+func fetchJoke() (string, error) {
+	resp, err := http.Get("https://v2.jokeapi.dev/joke/Miscellaneous,Dark?blacklistFlags=nsfw,religious,political,racist,sexist,explicit&type=single")
+	if err != nil {
+		return "", fmt.Errorf("the joke API returned an unexpected error: %v", err)
+	}
+
 	b, err := io.ReadAll(resp.Body)
 	resp.Body.Close()
 	if err != nil {
-		_, err = util.SendMessageInChannel(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("the joke API returned an unexpected response: %v", err))
-		return
+		return "", fmt.Errorf("the joke API returned an unexpected response: %v", err)
 	}
 
 	j := Joke{}
 
 	err = json.Unmarshal(b, &j)
 	if err != nil {
-		_, err = util.SendMessageInChannel(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("the joke API returned an unexpected response: %v", err))
-		return
+		return "", fmt.Errorf("the joke API returned an unexpected response: %v", err)
 	}
 
 	intro := fmt.Sprintf("Heres a \"%s\" joke:", j.Category)
@@ -36,9 +45,7 @@ func (c *Command) SayJoke() {
 		intro = "Heres a miscellaneous joke:"
 	}
 
-	joke := fmt.Sprintf("%s %s", intro, strings.ReplaceAll(j.Joke, "\n", " "))
-	go util.SendMessageInChannel(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("```\n%s\n```", joke))
-	c.TTS.GenerateAndPlay(c.MessageEvent, joke, c.TTSOpts.ChannelName)
+	return fmt.Sprintf("%s %s", intro, strings.ReplaceAll(j.Joke, "\n", " ")), nil
 }
 
 type Joke struct {
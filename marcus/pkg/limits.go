@@ -0,0 +1,58 @@
+package pkg
+
+import (
+	"cmp"
+	"fmt"
+	"marcus/pkg/config"
+	"marcus/pkg/ratelimit"
+	"marcus/pkg/settings"
+	"math"
+	"time"
+)
+
+// ungroupedBucket is shared by commands that aren't in a group, like !usage.
+const ungroupedBucket = "other"
+
+// generationBucket keeps the costly budget for new ElevenLabs audio apart
+// from the AI one, so a spoken answer to a question isn't charged to it twice.
+const generationBucket = "elevenlabs"
+
+// rateLimits returns the user and guild buckets for a tier. Each tier and
+// bucket, usually the command's group, is separate, so memes being spammed
+// don't stop anyone asking the AI a question.
+func (c *Command) rateLimits(tierName string, tier config.RateLimitTier, bucket string) []ratelimit.Limit {
+	guildID := *c.MessageEvent.GuildID
+	userID := c.MessageEvent.Message.Author.ID
+
+	return []ratelimit.Limit{
+		{
+			Key:  fmt.Sprintf("%s:user:%s:%s:%s", tierName, guildID, userID, bucket),
+			Rule: ratelimit.Rule{Burst: tier.User.Burst, Every: tier.User.Every.Duration()},
+		},
+		{
+			Key:  fmt.Sprintf("%s:guild:%s:%s", tierName, guildID, bucket),
+			Rule: ratelimit.Rule{Burst: tier.Guild.Burst, Every: tier.Guild.Every.Duration()},
+		},
+	}
+}
+
+// take uses up one of the budgets every command in the group shares, and one
+// of the costly budgets as well for AI questions. Either all of them are
+// used or none are.
+func (c *Command) take() (time.Duration, bool) {
+	limits := c.Config.Get().RateLimits
+	buckets := c.rateLimits("cheap", limits.Cheap, cmp.Or(c.group, ungroupedBucket))
+	if c.group == settings.GroupAI {
+		buckets = append(buckets, c.rateLimits("costly", limits.Costly, c.group)...)
+	}
+	return c.Limiter.Allow(buckets...)
+}
+
+// takeGeneration uses up one of the costly budgets for new ElevenLabs audio.
+func (c *Command) takeGeneration() (time.Duration, bool) {
+	return c.Limiter.Allow(c.rateLimits("costly", c.Config.Get().RateLimits.Costly, generationBucket)...)
+}
+
+func slowDown(wait time.Duration) string {
+	return fmt.Sprintf("Slow down! Try again in %ds", int(math.Ceil(wait.Seconds())))
+}
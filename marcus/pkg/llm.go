@@ -0,0 +1,255 @@
+package pkg
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"marcus/pkg/config"
+	"marcus/pkg/metrics"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// llmTimeout bounds a single attempt, so a hung model falls back quickly.
+	llmTimeout = time.Minute
+	maxBackoff = 8 * time.Second
+)
+
+// retryBackoff is the first wait before retrying a model, doubling each time.
+var retryBackoff = time.Second
+
+// LLM is a chat model backend.
+type LLM interface {
+	Name() string
+	Complete(ctx context.Context, req ChatRequest) (ChatResponse, error)
+}
+
+type ChatMessage struct {
+	Role    string
+	Content string
+
+	// ToolCalls are the tools an assistant message asked for.
+	ToolCalls []ToolCall
+	// ToolCallID is the call a tool message is the result of.
+	ToolCallID string
+}
+
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+type ChatRequest struct {
+	Model    string
+	Messages []ChatMessage
+	// Tools the model may call instead of answering, if any.
+	Tools []ToolDefinition
+}
+
+// ToolDefinition describes a tool to the model. Parameters is a JSON schema.
+type ToolDefinition struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage
+}
+
+// ToolCall is a model asking for a tool to be run, with JSON arguments.
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// ChatResponse is a model's answer and what it cost. Backends that don't
+// report a cost leave it at 0.
+type ChatResponse struct {
+	Content          string
+	Reasoning        string
+	Model            string
+	ToolCalls        []ToolCall
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+}
+
+// StatusError is returned by backends when the API answers with an error
+// status, so failures can be handled the same way for every backend.
+type StatusError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("status %d: %v", e.StatusCode, e.Err)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+var errEmptyAnswer = errors.New("the model returned an empty answer")
+
+// newLLM builds the backend with the given name from the config.
+func newLLM(cfg *config.Config, backend string) (LLM, error) {
+	switch backend {
+	case config.BackendOpenRouter:
+		return OpenRouterLLM{APIKey: cfg.OpenRouter.APIKey}, nil
+	case config.BackendOpenAI:
+		return OpenAICompatibleLLM{BaseURL: cfg.OpenAI.BaseURL, APIKey: cfg.OpenAI.APIKey, HTTPClient: http.DefaultClient}, nil
+	}
+	return nil, fmt.Errorf("unknown AI backend '%s'", backend)
+}
+
+// completeWithFallback asks each model in turn until one answers. A model
+// that is rate limited or has a server error is retried with backoff first.
+// The models may use the tools, if any, and once one has been used the
+// question isn't asked again. The usage of every attempt is
+// returned, even when no model answers, so paid requests are still recorded.
+func completeWithFallback(logger *slog.Logger, llm LLM, models []string, retries int, messages []ChatMessage, tools *Toolbox) (ChatResponse, error) {
+	var spent ChatResponse
+	var errs []error
+	for _, model := range models {
+		wait := retryBackoff
+		for attempt := 0; ; attempt++ {
+			resp, err := completeWithTools(logger, llm, model, messages, tools)
+			spent.addUsage(resp, model)
+			if err == nil && strings.TrimSpace(resp.Content) == "" {
+				metrics.LLMRequestErrors.WithLabelValues(llm.Name(), model, errorReason(errEmptyAnswer)).Inc()
+				err = errEmptyAnswer
+			}
+			if err == nil {
+				if resp.Model == "" {
+					resp.Model = model
+				}
+				resp.PromptTokens, resp.CompletionTokens, resp.Cost = spent.PromptTokens, spent.CompletionTokens, spent.Cost
+				return resp, nil
+			}
+
+			retryable, fatal := classifyLLMError(err)
+			logger.Warn("model failed to answer", "backend", llm.Name(), "model", model, "attempt", attempt+1, "retryable", retryable, "err", err)
+			if fatal {
+				return spent, err
+			}
+			// tools like play_meme have already done something, asking again
+			// would do it twice
+			if tools.used() {
+				return spent, fmt.Errorf("%s: %w", model, err)
+			}
+			if !retryable || attempt >= retries {
+				errs = append(errs, fmt.Errorf("%s: %w", model, err))
+				break
+			}
+
+			time.Sleep(wait)
+			wait = min(wait*2, maxBackoff)
+		}
+	}
+
+	return spent, errors.Join(errs...)
+}
+
+// addUsage adds the tokens and cost of resp, which model answered, to r.
+func (r *ChatResponse) addUsage(resp ChatResponse, model string) {
+	if resp.PromptTokens == 0 && resp.CompletionTokens == 0 && resp.Cost == 0 {
+		return
+	}
+	r.Model = cmp.Or(resp.Model, model)
+	r.PromptTokens += resp.PromptTokens
+	r.CompletionTokens += resp.CompletionTokens
+	r.Cost += resp.Cost
+}
+
+// completeWithTools asks a model until it answers instead of calling tools,
+// running the tools it asks for in between. The answer's usage covers every
+// request made, and is returned with the error if a later request fails. Tools stop being offered once the toolbox is used up, so a
+// model can't keep calling them forever.
+func completeWithTools(logger *slog.Logger, llm LLM, model string, messages []ChatMessage, tools *Toolbox) (ChatResponse, error) {
+	messages = slices.Clone(messages)
+	definitions := tools.Definitions()
+
+	var usage ChatResponse
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), llmTimeout)
+		start := time.Now()
+		resp, err := llm.Complete(ctx, ChatRequest{Model: model, Messages: messages, Tools: definitions})
+		metrics.LLMRequestDuration.WithLabelValues(llm.Name(), model).Observe(metrics.Since(start))
+		cancel()
+		if err != nil {
+			metrics.LLMRequestErrors.WithLabelValues(llm.Name(), model, errorReason(err)).Inc()
+		}
+		if err != nil && len(definitions) > 0 && !tools.used() && toolsUnsupported(err) {
+			logger.Info("model doesn't support tools, asking without them", "backend", llm.Name(), "model", model, "err", err)
+			definitions = nil
+			continue
+		}
+		if err != nil {
+			return usage, err
+		}
+
+		usage.addUsage(resp, model)
+		if len(resp.ToolCalls) == 0 || len(definitions) == 0 {
+			resp.PromptTokens, resp.CompletionTokens, resp.Cost = usage.PromptTokens, usage.CompletionTokens, usage.Cost
+			return resp, nil
+		}
+
+		messages = append(messages, ChatMessage{Role: RoleAssistant, Content: resp.Content, ToolCalls: resp.ToolCalls})
+		for _, call := range resp.ToolCalls {
+			messages = append(messages, ChatMessage{Role: RoleTool, ToolCallID: call.ID, Content: tools.Run(call)})
+		}
+		definitions = tools.Definitions()
+	}
+}
+
+// toolsUnsupported reports whether a request failed because the model can't
+// use tools, which providers answer with a 400 or 404.
+func toolsUnsupported(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusBadRequest || statusErr.StatusCode == http.StatusNotFound)
+}
+
+// errorReason describes why a request failed for metrics: the HTTP status,
+// timeout, network or empty.
+func errorReason(err error) string {
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &statusErr):
+		return strconv.Itoa(statusErr.StatusCode)
+	case errors.Is(err, errEmptyAnswer):
+		return "empty"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	}
+	return "network"
+}
+
+// classifyLLMError reports whether a failed request is worth retrying with
+// the same model, and whether it is fatal for every model, such as a bad API
+// key or running out of credits.
+func classifyLLMError(err error) (retryable, fatal bool) {
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &statusErr):
+	case errors.Is(err, errEmptyAnswer):
+		return false, false
+	default:
+		// timeouts and connection problems
+		return true, false
+	}
+
+	switch status := statusErr.StatusCode; {
+	case status == http.StatusUnauthorized || status == http.StatusPaymentRequired:
+		return false, true
+	case status == http.StatusTooManyRequests || status >= 500:
+		return true, false
+	}
+	return false, false
+}
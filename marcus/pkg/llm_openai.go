@@ -0,0 +1,149 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OpenAICompatibleLLM talks to any server with an OpenAI-compatible chat
+// completions API, such as a local llama.cpp or Ollama server.
+type OpenAICompatibleLLM struct {
+	BaseURL    string
+	APIKey     string
+	HTTPClient *http.Client
+}
+
+func (o OpenAICompatibleLLM) Name() string {
+	return "openai"
+}
+
+type openAIMessage struct {
+	Role             string           `json:"role"`
+	Content          string           `json:"content"`
+	ReasoningContent string           `json:"reasoning_content,omitempty"` // llama.cpp
+	Reasoning        string           `json:"reasoning,omitempty"`         // Ollama
+	ToolCalls        []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID       string           `json:"tool_call_id,omitempty"`
+}
+
+type openAIToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type openAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Parameters  json.RawMessage `json:"parameters"`
+	} `json:"function"`
+}
+
+type openAIChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []openAIMessage `json:"messages"`
+	Tools    []openAITool    `json:"tools,omitempty"`
+}
+
+type openAIChatResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message openAIMessage `json:"message"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+func (o OpenAICompatibleLLM) Complete(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	body := openAIChatRequest{Model: req.Model}
+	for _, m := range req.Messages {
+		msg := openAIMessage{Role: m.Role, Content: m.Content, ToolCallID: m.ToolCallID}
+		for _, call := range m.ToolCalls {
+			c := openAIToolCall{ID: call.ID, Type: "function"}
+			c.Function.Name = call.Name
+			c.Function.Arguments = call.Arguments
+			msg.ToolCalls = append(msg.ToolCalls, c)
+		}
+		body.Messages = append(body.Messages, msg)
+	}
+	for _, tool := range req.Tools {
+		t := openAITool{Type: "function"}
+		t.Function.Name = tool.Name
+		t.Function.Description = tool.Description
+		t.Function.Parameters = tool.Parameters
+		body.Tools = append(body.Tools, t)
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return ChatResponse{}, fmt.Errorf("failed to marshal chat request: %w", err)
+	}
+
+	url := strings.TrimSuffix(o.BaseURL, "/") + "/chat/completions"
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return ChatResponse{}, fmt.Errorf("failed to create chat request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if o.APIKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+o.APIKey)
+	}
+
+	client := o.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return ChatResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ChatResponse{}, fmt.Errorf("failed to read chat response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return ChatResponse{}, &StatusError{StatusCode: resp.StatusCode, Err: fmt.Errorf("%s", strings.TrimSpace(string(respBody)))}
+	}
+
+	var chat openAIChatResponse
+	if err := json.Unmarshal(respBody, &chat); err != nil {
+		return ChatResponse{}, fmt.Errorf("failed to unmarshal chat response: %w", err)
+	}
+	if len(chat.Choices) == 0 {
+		return ChatResponse{}, errEmptyAnswer
+	}
+
+	message := chat.Choices[0].Message
+	reasoning := message.ReasoningContent
+	if reasoning == "" {
+		reasoning = message.Reasoning
+	}
+
+	result := ChatResponse{
+		Content:          message.Content,
+		Reasoning:        reasoning,
+		Model:            chat.Model,
+		PromptTokens:     chat.Usage.PromptTokens,
+		CompletionTokens: chat.Usage.CompletionTokens,
+	}
+	for _, call := range message.ToolCalls {
+		result.ToolCalls = append(result.ToolCalls, ToolCall{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+	}
+
+	return result, nil
+}
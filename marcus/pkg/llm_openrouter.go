@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+
+	"github.com/revrost/go-openrouter"
+)
+
+// OpenRouterLLM answers with models hosted on OpenRouter, using web search.
+type OpenRouterLLM struct {
+	APIKey string
+}
+
+func (o OpenRouterLLM) Name() string {
+	return "openrouter"
+}
+
+func (o OpenRouterLLM) Complete(ctx context.Context, req ChatRequest) (ChatResponse, error) {
+	msgs := make([]openrouter.ChatCompletionMessage, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		msg := openrouter.ChatCompletionMessage{
+			Role:       m.Role,
+			Content:    openrouter.Content{Text: m.Content},
+			ToolCallID: m.ToolCallID,
+		}
+		for _, call := range m.ToolCalls {
+			msg.ToolCalls = append(msg.ToolCalls, openrouter.ToolCall{
+				ID:       call.ID,
+				Type:     openrouter.ToolTypeFunction,
+				Function: openrouter.FunctionCall{Name: call.Name, Arguments: call.Arguments},
+			})
+		}
+		msgs = append(msgs, msg)
+	}
+
+	var tools []openrouter.Tool
+	for _, tool := range req.Tools {
+		tools = append(tools, openrouter.Tool{
+			Type: openrouter.ToolTypeFunction,
+			Function: &openrouter.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+
+	client := openrouter.NewClient(
+		o.APIKey,
+	)
+	resp, err := client.CreateChatCompletion(
+		ctx,
+		openrouter.ChatCompletionRequest{
+			Model: req.Model,
+			Reasoning: &openrouter.ChatCompletionReasoning{
+				Effort: toPtr("medium"),
+			},
+			Messages: msgs,
+			Tools:    tools,
+			WebSearchOptions: &openrouter.WebSearchOptions{
+				SearchContextSize: openrouter.SearchContextSizeHigh,
+			},
+			Usage: &openrouter.IncludeUsage{Include: true},
+		},
+	)
+	if err != nil {
+		var apiErr *openrouter.APIError
+		var reqErr *openrouter.RequestError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.HTTPStatusCode != 0:
+			return ChatResponse{}, &StatusError{StatusCode: apiErr.HTTPStatusCode, Err: err}
+		case errors.As(err, &reqErr) && reqErr.HTTPStatusCode != 0:
+			return ChatResponse{}, &StatusError{StatusCode: reqErr.HTTPStatusCode, Err: err}
+		}
+		return ChatResponse{}, err
+	}
+
+	if len(resp.Choices) == 0 {
+		return ChatResponse{}, errEmptyAnswer
+	}
+
+	message := resp.Choices[0].Message
+	result := ChatResponse{
+		Content:   message.Content.Text,
+		Reasoning: fromPtr(message.Reasoning),
+		Model:     resp.Model,
+	}
+	for _, call := range message.ToolCalls {
+		result.ToolCalls = append(result.ToolCalls, ToolCall{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+	}
+	if resp.Usage != nil {
+		result.PromptTokens = resp.Usage.PromptTokens
+		result.CompletionTokens = resp.Usage.CompletionTokens
+		result.Cost = resp.Usage.Cost
+	}
+
+	return result, nil
+}
@@ -2,20 +2,33 @@ package pkg
 
 import (
 	"fmt"
+	"io"
 	"log/slog"
+	"marcus/pkg/config"
+	"marcus/pkg/metrics"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"regexp"
 	"slices"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/disgoorg/snowflake/v2"
 )
 
 type MemeSet struct {
 	*sync.Map
+	Config *config.Store
+
+	recentMu sync.Mutex
+	recent   map[snowflake.ID][]string
 }
 
+// maxRecentMemes is how many recently played memes are kept for each guild.
+const maxRecentMemes = 5
+
 type MemeHit struct {
 	IsDir bool
 	Path  string
@@ -33,24 +46,31 @@ func (m *MemeSet) MonitorMemes(logger *slog.Logger) {
 			return true
 		})
 		for {
-			select {
-			case <-time.Tick(time.Second * 10):
-				logger.Debug("refreshing meme set")
-				err = m.BuildMemeSet()
-				if err != nil {
-					logger.Error(fmt.Sprintf("failed to build meme set: %v", err))
-				}
+			time.Sleep(m.Config.Get().Memes.RefreshInterval.Duration())
+			logger.Debug("refreshing meme set")
+			err = m.BuildMemeSet()
+			if err != nil {
+				logger.Error(fmt.Sprintf("failed to build meme set: %v", err))
 			}
 		}
 	}()
 }
 
 func (m *MemeSet) BuildMemeSet() error {
-	MemeLocation := os.Getenv("MEMES_LOCATION")
-	if MemeLocation == "" {
-		MemeLocation = "memes"
-	}
-	return bm(MemeLocation, MemeLocation, m)
+	MemeLocation := m.Config.Get().Storage.MemesDir
+	err := bm(MemeLocation, MemeLocation, m)
+	metrics.Memes.Set(float64(m.Len()))
+	return err
+}
+
+// Len returns how many memes there are, including each directory of variants.
+func (m *MemeSet) Len() int {
+	n := 0
+	m.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	return n
 }
 
 func (m *MemeSet) ListMemes() string {
@@ -63,6 +83,26 @@ func (m *MemeSet) ListMemes() string {
 	return strings.Join(memes, "```\n```")
 }
 
+// Played remembers that a meme was played in the guild.
+func (m *MemeSet) Played(guildID snowflake.ID, name string) {
+	m.recentMu.Lock()
+	defer m.recentMu.Unlock()
+
+	if m.recent == nil {
+		m.recent = map[snowflake.ID][]string{}
+	}
+	recent := append(m.recent[guildID], name)
+	m.recent[guildID] = recent[max(0, len(recent)-maxRecentMemes):]
+}
+
+// RecentlyPlayed returns the memes last played in the guild, oldest first.
+func (m *MemeSet) RecentlyPlayed(guildID snowflake.ID) []string {
+	m.recentMu.Lock()
+	defer m.recentMu.Unlock()
+
+	return slices.Clone(m.recent[guildID])
+}
+
 func (m *MemeSet) GetMeme(command string) (string, bool) {
 	v, ok := m.Load(command)
 	if !ok {
@@ -110,6 +150,13 @@ func bm(base, root string, fin *MemeSet) error {
 			fmt.Println("failed to walk path", err)
 			return err
 		}
+		// hidden files are temp files and health checks, not memes
+		if strings.HasPrefix(info.Name(), ".") && path != root {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		command := strings.ReplaceAll(strings.TrimPrefix(path, root+"/"), "/", "-")
 		if command == "" {
 			command = "meme"
@@ -124,3 +171,151 @@ func bm(base, root string, fin *MemeSet) error {
 		return nil
 	})
 }
+
+// memeNamePattern is what meme names added from the dashboard can look like.
+var memeNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// checkMemeName makes sure a name is safe to use as a file name.
+func checkMemeName(name string) error {
+	if !memeNamePattern.MatchString(name) {
+		return fmt.Errorf("'%s' isn't a valid meme name, use lowercase letters, numbers, - and _", name)
+	}
+	return nil
+}
+
+// SaveMeme writes a .wav file to the top of MEMES_LOCATION, replacing any meme
+// with the same name.
+func (m *MemeSet) SaveMeme(name string, audio io.Reader) error {
+	if err := m.saveMeme(name, audio); err != nil {
+		return err
+	}
+	return m.BuildMemeSet()
+}
+
+func (m *MemeSet) saveMeme(name string, audio io.Reader) error {
+	if err := checkMemeName(name); err != nil {
+		return err
+	}
+
+	dir := m.Config.Get().Storage.MemesDir
+	path := filepath.Join(dir, name+".wav")
+	tempPath := filepath.Join(dir, "."+name+".wav.tmp")
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return fmt.Errorf("failed to create meme file: %w", err)
+	}
+	_, err = io.Copy(file, audio)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write meme file: %w", err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to save meme file: %w", err)
+	}
+	return nil
+}
+
+// ImportResult is what ImportMemes did with each file.
+type ImportResult struct {
+	Imported []string
+	// Skipped is why each file that wasn't imported was skipped.
+	Skipped map[string]string
+}
+
+// ImportMemes copies the .wav files at the top of dir into MEMES_LOCATION,
+// named after the file. Memes that already exist are skipped unless replace
+// is set.
+func (m *MemeSet) ImportMemes(dir string, replace bool) (ImportResult, error) {
+	result := ImportResult{Skipped: map[string]string{}}
+	if err := m.BuildMemeSet(); err != nil {
+		return result, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return result, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	for _, entry := range entries {
+		file := entry.Name()
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(file), ".wav") {
+			continue
+		}
+
+		name := strings.ToLower(strings.ReplaceAll(strings.TrimSuffix(file, filepath.Ext(file)), " ", "-"))
+		if _, exists := m.Load(name); exists && !replace {
+			result.Skipped[file] = fmt.Sprintf("there's already a meme called '%s'", name)
+			continue
+		}
+
+		if err := m.importMeme(name, filepath.Join(dir, file)); err != nil {
+			result.Skipped[file] = err.Error()
+			continue
+		}
+		result.Imported = append(result.Imported, name)
+	}
+
+	return result, m.BuildMemeSet()
+}
+
+func (m *MemeSet) importMeme(name, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return m.saveMeme(name, file)
+}
+
+// RenameMeme renames a meme's file. For memes in a directory only the file's
+// own name changes, so the directory stays part of the meme's name.
+func (m *MemeSet) RenameMeme(name, newName string) error {
+	if err := checkMemeName(newName); err != nil {
+		return err
+	}
+	hit, err := m.memeFile(name)
+	if err != nil {
+		return err
+	}
+
+	newPath := filepath.Join(filepath.Dir(hit.Path), newName+filepath.Ext(hit.Path))
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("there's already a meme file called %s", filepath.Base(newPath))
+	}
+	if err := os.Rename(hit.Path, newPath); err != nil {
+		return fmt.Errorf("failed to rename meme: %w", err)
+	}
+
+	m.Delete(name)
+	return m.BuildMemeSet()
+}
+
+// DeleteMeme removes a meme's file.
+func (m *MemeSet) DeleteMeme(name string) error {
+	hit, err := m.memeFile(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(hit.Path); err != nil {
+		return fmt.Errorf("failed to delete meme: %w", err)
+	}
+
+	m.Delete(name)
+	return m.BuildMemeSet()
+}
+
+// memeFile returns a meme that is a single file rather than a directory of variants.
+func (m *MemeSet) memeFile(name string) (MemeHit, error) {
+	v, ok := m.Load(name)
+	if !ok {
+		return MemeHit{}, fmt.Errorf("there's no meme called '%s'", name)
+	}
+	hit := v.(MemeHit)
+	if hit.IsDir {
+		return MemeHit{}, fmt.Errorf("'%s' is a directory of variants, change its files instead", name)
+	}
+	return hit, nil
+}
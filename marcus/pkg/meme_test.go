@@ -0,0 +1,158 @@
+package pkg
+
+import (
+	"maps"
+	"marcus/pkg/config"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// newTestMemeSet builds a meme set from files, created empty under a temp
+// MEMES_LOCATION.
+func newTestMemeSet(t *testing.T, files ...string) (*MemeSet, string) {
+	dir := t.TempDir()
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := config.Default()
+	cfg.Storage.MemesDir = dir
+	memes := &MemeSet{Map: &sync.Map{}, Config: config.NewStaticStore(cfg)}
+	if err := memes.BuildMemeSet(); err != nil {
+		t.Fatalf("failed to build meme set: %v", err)
+	}
+	return memes, dir
+}
+
+func TestGetMeme(t *testing.T) {
+	memes, dir := newTestMemeSet(t,
+		"airhorn.wav",
+		".bruh.wav.tmp",
+		"sounds/bruh.wav",
+		"variants/one.wav",
+		"variants/two.wav",
+		".cache/hidden.wav",
+	)
+
+	tests := []struct {
+		name      string
+		meme      string
+		wantFiles []string
+	}{
+		{name: "file", meme: "airhorn", wantFiles: []string{"airhorn.wav"}},
+		{name: "file in a directory", meme: "sounds-bruh", wantFiles: []string{"sounds/bruh.wav"}},
+		{name: "directory plays one of its files", meme: "variants", wantFiles: []string{"variants/one.wav", "variants/two.wav"}},
+		{name: "missing", meme: "nope"},
+		{name: "temp files are hidden", meme: ".bruh.wav"},
+		{name: "hidden directories are skipped", meme: ".cache-hidden"},
+		{name: "names are exact", meme: "Airhorn"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, found := memes.GetMeme(tt.meme)
+			if found != (len(tt.wantFiles) > 0) {
+				t.Fatalf("found = %v, want %v", found, len(tt.wantFiles) > 0)
+			}
+			if !found {
+				return
+			}
+
+			file, err := filepath.Rel(dir, path)
+			if err != nil || !slices.Contains(tt.wantFiles, filepath.ToSlash(file)) {
+				t.Errorf("GetMeme(%q) = %q, want one of %q", tt.meme, file, tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestMemeChanges(t *testing.T) {
+	memes, _ := newTestMemeSet(t, "airhorn.wav", "variants/one.wav")
+
+	if err := memes.RenameMeme("airhorn", "horn"); err != nil {
+		t.Fatalf("failed to rename: %v", err)
+	}
+	if _, found := memes.GetMeme("airhorn"); found {
+		t.Error("airhorn is still a meme after being renamed")
+	}
+	if _, found := memes.GetMeme("horn"); !found {
+		t.Error("horn isn't a meme after renaming airhorn to it")
+	}
+
+	if err := memes.DeleteMeme("variants"); err == nil {
+		t.Error("deleting a directory of variants should fail")
+	}
+	if err := memes.RenameMeme("horn", "../horn"); err == nil {
+		t.Error("renaming to a path should fail")
+	}
+
+	if err := memes.DeleteMeme("horn"); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if _, found := memes.GetMeme("horn"); found {
+		t.Error("horn is still a meme after being deleted")
+	}
+}
+
+func TestImportMemes(t *testing.T) {
+	tests := []struct {
+		name         string
+		replace      bool
+		wantImported []string
+		wantSkipped  []string
+		wantAirhorn  string
+	}{
+		{name: "keeps existing memes", wantImported: []string{"big-bonk", "bruh"}, wantSkipped: []string{"airhorn.wav", "bad!.wav"}, wantAirhorn: "airhorn.wav"},
+		{name: "replaces existing memes", replace: true, wantImported: []string{"airhorn", "big-bonk", "bruh"}, wantSkipped: []string{"bad!.wav"}, wantAirhorn: "new airhorn"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			memes, _ := newTestMemeSet(t, "airhorn.wav")
+			src := t.TempDir()
+			for file, content := range map[string]string{
+				"airhorn.wav":    "new airhorn",
+				"Big Bonk.wav":   "bonk",
+				"bruh.WAV":       "bruh",
+				"bad!.wav":       "bad",
+				"notes.txt":      "not audio",
+				"sub/nested.wav": "not at the top",
+			} {
+				os.MkdirAll(filepath.Dir(filepath.Join(src, file)), 0755)
+				if err := os.WriteFile(filepath.Join(src, file), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			result, err := memes.ImportMemes(src, tt.replace)
+			if err != nil {
+				t.Fatalf("failed to import: %v", err)
+			}
+			if imported := slices.Sorted(slices.Values(result.Imported)); !slices.Equal(imported, tt.wantImported) {
+				t.Errorf("imported %q, want %q", imported, tt.wantImported)
+			}
+			if skipped := slices.Sorted(maps.Keys(result.Skipped)); !slices.Equal(skipped, tt.wantSkipped) {
+				t.Errorf("skipped %q, want %q", skipped, tt.wantSkipped)
+			}
+
+			for _, name := range tt.wantImported {
+				if _, found := memes.GetMeme(name); !found {
+					t.Errorf("%s isn't a meme after importing it", name)
+				}
+			}
+			path, _ := memes.GetMeme("airhorn")
+			if audio, _ := os.ReadFile(path); string(audio) != tt.wantAirhorn {
+				t.Errorf("airhorn = %q, want %q", audio, tt.wantAirhorn)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// Package metrics holds the Prometheus metrics Marcus exports on /metrics.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "marcus"
+
+// Command outcomes.
+const (
+	OutcomeOK          = "ok"
+	OutcomeInvalid     = "invalid"
+	OutcomeDisabled    = "disabled"
+	OutcomeDenied      = "denied"
+	OutcomeRateLimited = "rate_limited"
+	OutcomeNotInVoice  = "not_in_voice"
+)
+
+var (
+	CommandsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_total",
+		Help:      "Commands handled, by command and outcome.",
+	}, []string{"command", "outcome"})
+
+	CommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "command_duration_seconds",
+		Help:      "How long commands that ran took, including speaking in voice.",
+		Buckets:   []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"command"})
+
+	TTSCacheTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tts_cache_total",
+		Help:      "TTS cache lookups, by provider, voice and result (hit or miss).",
+	}, []string{"provider", "voice", "result"})
+
+	TTSGenerationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tts_generation_duration_seconds",
+		Help:      "How long generating new TTS audio took, by generator.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"generator"})
+
+	TTSGenerationErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tts_generation_errors_total",
+		Help:      "Failed TTS generations, by generator.",
+	}, []string{"generator"})
+
+	PrewarmPhrases = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "prewarm_phrases_total",
+		Help:      "Phrases prewarmed, by result (generated, skipped, over_quota or failed).",
+	}, []string{"result"})
+
+	LLMRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "llm_request_duration_seconds",
+		Help:      "How long AI completion requests took, by backend and model.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 20, 30, 60},
+	}, []string{"backend", "model"})
+
+	LLMRequestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_request_errors_total",
+		Help:      "Failed AI completion requests, by backend, model and reason (an HTTP status, timeout, network or empty).",
+	}, []string{"backend", "model", "reason"})
+
+	VoiceConnectFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "voice_connect_failures_total",
+		Help:      "Voice connections that failed to open.",
+	})
+
+	VoiceFramesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "voice_frames_total",
+		Help:      "Opus frames sent to voice, by result (written or dropped).",
+	}, []string{"result"})
+
+	VoiceQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "voice_queue_depth",
+		Help:      "Clips waiting to be played across every guild, not counting ones playing.",
+	})
+
+	Memes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "memes",
+		Help:      "Memes in the meme set.",
+	})
+)
+
+// Since returns the seconds elapsed since start, for observing durations.
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
+
+// Handler serves every metric in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
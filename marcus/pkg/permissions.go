@@ -0,0 +1,182 @@
+package pkg
+
+import (
+	"fmt"
+	"marcus/pkg/settings"
+	"marcus/pkg/tts"
+	"marcus/pkg/util"
+	"strings"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const permsUsage = "```\nUsage: !perms - shows who can use what in this server\n" +
+	"       !perms allow <permission> <@role|@user> - gives a role or user a permission\n" +
+	"       !perms remove <permission> <@role|@user> - takes a permission away from a role or user\n" +
+	"       !perms reset <permission> - puts a permission back to its default\n\n" +
+	"Once a permission is given to someone, only they can use it. " +
+	"People who can manage the server always have every permission, and only they can use !perms.\n```"
+
+// member returns who sent the message for permission checks.
+func (c *Command) member() settings.Member {
+	m := settings.Member{
+		UserID:  c.MessageEvent.Message.Author.ID,
+		IsAdmin: util.IsAdmin(c.MessageEvent),
+	}
+	if c.MessageEvent.Message.Member != nil {
+		m.RoleIDs = c.MessageEvent.Message.Member.RoleIDs
+	}
+	return m
+}
+
+// allowed reports whether the author holds the permission in this guild.
+func (c *Command) allowed(permission string) bool {
+	if c.MessageEvent.GuildID == nil {
+		return false
+	}
+	return c.Permissions.Allowed(*c.MessageEvent.GuildID, permission, c.member())
+}
+
+// canGenerate stops people without the elevenlabs permission, who are out of
+// budget or who are generating too quickly from spending credits. Cached lines can still be
+// played by anyone who can use TTS.
+func (c *Command) canGenerate(generatorName, content string) error {
+	if generatorName != tts.ElevenLabsGeneratorName {
+		return nil
+	}
+	if !c.allowed(settings.PermElevenLabs) {
+		return fmt.Errorf("you don't have the '%s' permission needed to generate new ElevenLabs audio, only lines that have been said before can be played", settings.PermElevenLabs)
+	}
+	if err := ReserveElevenLabsBudget(c.Config, c.ElevenLabsUsage, *c.MessageEvent.GuildID, c.MessageEvent.Message.Author.ID, content); err != nil {
+		return err
+	}
+	if wait, ok := c.takeGeneration(); !ok {
+		c.generationFailed(generatorName, content)
+		return fmt.Errorf("%s, new ElevenLabs audio is limited but lines that have been said before can still be played", slowDown(wait))
+	}
+	return nil
+}
+
+func (c *Command) ConfigurePermissions() {
+	if c.Permissions == nil {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, "Permissions are not available right now.", "failed to send perms")
+		return
+	}
+
+	if !util.IsAdmin(c.MessageEvent) {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, "Only people who can manage the server can change permissions.", "failed to send perms")
+		return
+	}
+
+	guildID := *c.MessageEvent.GuildID
+	fields := strings.Fields(c.TTSOpts.Content)
+	if len(fields) == 0 {
+		util.SendMessageWithoutPings(c.MessageEvent, c.MessageEvent.ChannelID, formatPermissions(c.Permissions.Get(guildID)), "failed to list perms")
+		return
+	}
+
+	action := strings.ToLower(fields[0])
+	if len(fields) < 2 {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, permsUsage, "failed to send usage for perms")
+		return
+	}
+
+	name := strings.ToLower(fields[1])
+	if _, ok := settings.LookupPermission(name); !ok {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, unknownPermission(name), "failed to send perms")
+		return
+	}
+
+	var err error
+	var reply string
+	switch action {
+	case "allow", "remove":
+		if len(fields) != 3 {
+			util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, permsUsage, "failed to send usage for perms")
+			return
+		}
+
+		id, isRole, ok := c.parseMention(fields[2])
+		if !ok {
+			util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("'%s' isn't a role or user, mention them with @", fields[2]), "failed to send perms")
+			return
+		}
+
+		if action == "allow" {
+			err = c.Permissions.Allow(guildID, name, id, isRole)
+			reply = fmt.Sprintf("%s now has the '%s' permission", mention(id, isRole), name)
+		} else {
+			err = c.Permissions.Remove(guildID, name, id)
+			reply = fmt.Sprintf("%s no longer has the '%s' permission", mention(id, isRole), name)
+		}
+	case "reset":
+		err = c.Permissions.Reset(guildID, name)
+		reply = fmt.Sprintf("The '%s' permission is back to its default", name)
+	default:
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, permsUsage, "failed to send usage for perms")
+		return
+	}
+
+	if err != nil {
+		c.Logger.Error("failed to update permissions", "action", action, "permission", name, "err", err)
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("failed to update permissions: %v", err), "failed to update perms")
+		return
+	}
+	util.SendMessageWithoutPings(c.MessageEvent, c.MessageEvent.ChannelID, reply, "failed to send perms")
+}
+
+// parseMention accepts <@user>, <@!user>, <@&role> or a bare ID, which is
+// treated as a role if the guild has a role with that ID.
+func (c *Command) parseMention(input string) (snowflake.ID, bool, bool) {
+	isRole := strings.HasPrefix(input, "<@&")
+	trimmed := strings.TrimSuffix(strings.TrimLeft(input, "<@!&"), ">")
+
+	id, err := snowflake.Parse(trimmed)
+	if err != nil {
+		return 0, false, false
+	}
+
+	if !strings.HasPrefix(input, "<") {
+		_, isRole = c.MessageEvent.Client().Caches.Role(*c.MessageEvent.GuildID, id)
+	}
+	return id, isRole, true
+}
+
+func mention(id snowflake.ID, isRole bool) string {
+	if isRole {
+		return fmt.Sprintf("<@&%s>", id)
+	}
+	return fmt.Sprintf("<@%s>", id)
+}
+
+func formatPermissions(grants map[string]settings.Grant) string {
+	b := strings.Builder{}
+	b.WriteString("Permissions for this server:\n")
+	for _, perm := range settings.Permissions {
+		grant, ok := grants[perm.Name]
+		var who []string
+		switch {
+		case ok:
+			for _, id := range grant.Roles {
+				who = append(who, mention(id, true))
+			}
+			for _, id := range grant.Users {
+				who = append(who, mention(id, false))
+			}
+		case perm.AdminOnly:
+			who = []string{"admins only (default)"}
+		default:
+			who = []string{"everyone (default)"}
+		}
+		b.WriteString(fmt.Sprintf("`%s` %s - %s\n", perm.Name, strings.Join(who, ", "), perm.Description))
+	}
+	return b.String()
+}
+
+func unknownPermission(name string) string {
+	names := make([]string, 0, len(settings.Permissions))
+	for _, perm := range settings.Permissions {
+		names = append(names, perm.Name)
+	}
+	return fmt.Sprintf("Unknown permission '%s', try one of: %s", name, strings.Join(names, ", "))
+}
@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"strings"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// promptTimeFormat is how {{time}} is written, in the bot's local time zone.
+const promptTimeFormat = "Monday, January 2 2006, 3:04 PM MST"
+
+// promptNow is swapped out in tests so prompts don't depend on the clock.
+var promptNow = time.Now
+
+// promptVariables returns the values substituted into persona prompts, which
+// describe who asked and where. Anything that can't be found is unknown or
+// none, so prompts still read sensibly.
+func (c *Command) promptVariables() map[string]string {
+	e := c.MessageEvent
+	caches := e.Client().Caches
+
+	user := e.Message.Author.EffectiveName()
+	if e.Message.Member != nil && e.Message.Member.Nick != nil {
+		user = *e.Message.Member.Nick
+	}
+
+	vars := map[string]string{
+		"user":          user,
+		"time":          promptNow().Format(promptTimeFormat),
+		"server":        "unknown",
+		"channel":       "unknown",
+		"voice_members": "nobody",
+		"recent_memes":  "none",
+	}
+
+	if channel, ok := caches.Channel(e.ChannelID); ok {
+		vars["channel"] = channel.Name()
+	} else if channel, err := e.Client().Rest.GetChannel(e.ChannelID); err == nil {
+		vars["channel"] = channel.Name()
+	}
+	if e.GuildID == nil {
+		return vars
+	}
+	guildID := *e.GuildID
+
+	if guild, ok := caches.Guild(guildID); ok {
+		vars["server"] = guild.Name
+	}
+
+	if members := c.voiceMembers(); len(members) > 0 {
+		vars["voice_members"] = strings.Join(members, ", ")
+	}
+	if c.MemeSet != nil {
+		if memes := c.MemeSet.RecentlyPlayed(guildID); len(memes) > 0 {
+			vars["recent_memes"] = strings.Join(memes, ", ")
+		}
+	}
+
+	return vars
+}
+
+// voiceMembers returns the names of the people in the asker's voice channel,
+// or in Marcus's if the asker isn't in one.
+func (c *Command) voiceMembers() []string {
+	e := c.MessageEvent
+	caches := e.Client().Caches
+	guildID := *e.GuildID
+
+	var channelID *snowflake.ID
+	if state, ok := caches.VoiceState(guildID, e.Message.Author.ID); ok && state.ChannelID != nil {
+		channelID = state.ChannelID
+	} else if c.TTS != nil && c.TTS.Connections != nil {
+		channelID = c.TTS.Connections.ChannelID(guildID)
+	}
+	if channelID == nil {
+		return nil
+	}
+
+	var names []string
+	for state := range caches.VoiceStates(guildID) {
+		if state.ChannelID == nil || *state.ChannelID != *channelID || state.UserID == e.Client().ID() {
+			continue
+		}
+		member, ok := caches.Member(guildID, state.UserID)
+		if !ok || member.User.Bot {
+			continue
+		}
+		names = append(names, member.EffectiveName())
+	}
+	return names
+}
+
+// fillPrompt replaces each {{name}} in the prompt with its variable. Unknown
+// names are left alone, so a prompt that talks about braces still works.
+func fillPrompt(prompt string, vars map[string]string) string {
+	pairs := make([]string, 0, len(vars)*2)
+	for name, value := range vars {
+		pairs = append(pairs, "{{"+name+"}}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(prompt)
+}
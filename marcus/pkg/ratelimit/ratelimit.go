@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Rule is a token bucket holding up to Burst tokens, with one token added
+// back every Every. A rule with no burst doesn't limit anything.
+type Rule struct {
+	Burst int
+	Every time.Duration
+}
+
+func (r Rule) enabled() bool {
+	return r.Burst > 0 && r.Every > 0
+}
+
+// Limit applies a rule to the bucket with the given key.
+type Limit struct {
+	Key  string
+	Rule Rule
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	rule   Rule
+}
+
+// refill tops the bucket up for the time that has passed since it was last used.
+func (b *bucket) refill(now time.Time) {
+	b.tokens += float64(now.Sub(b.last)) / float64(b.rule.Every)
+	b.tokens = min(b.tokens, float64(b.rule.Burst))
+	b.last = now
+}
+
+// Limiter keeps a token bucket for every key it has seen. Buckets that have
+// refilled completely are forgotten, since a new bucket starts full anyway.
+type Limiter struct {
+	mu         sync.Mutex
+	buckets    map[string]*bucket
+	lastPruned time.Time
+}
+
+func New() *Limiter {
+	return &Limiter{
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Allow takes a token from every bucket in limits, or from none of them if any
+// is empty, in which case it returns how long until they all have one. A nil
+// limiter allows everything.
+func (l *Limiter) Allow(limits ...Limit) (time.Duration, bool) {
+	if l == nil {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	var wait time.Duration
+	buckets := make([]*bucket, 0, len(limits))
+	for _, limit := range limits {
+		if !limit.Rule.enabled() {
+			continue
+		}
+
+		b, ok := l.buckets[limit.Key]
+		if !ok || b.rule != limit.Rule {
+			// a new or changed rule starts with a full bucket
+			b = &bucket{tokens: float64(limit.Rule.Burst), last: now, rule: limit.Rule}
+			l.buckets[limit.Key] = b
+		}
+		b.refill(now)
+
+		if b.tokens < 1 {
+			wait = max(wait, time.Duration((1-b.tokens)*float64(b.rule.Every)))
+		}
+		buckets = append(buckets, b)
+	}
+
+	if wait > 0 {
+		return wait, false
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return 0, true
+}
+
+// prune drops full buckets every few minutes, the caller must hold the lock.
+func (l *Limiter) prune(now time.Time) {
+	if now.Sub(l.lastPruned) < 5*time.Minute {
+		return
+	}
+	l.lastPruned = now
+
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.rule.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// step is one call to Allow, after moving the limiter's clock on by age.
+type step struct {
+	age      time.Duration
+	limits   []Limit
+	wantOK   bool
+	wantWait time.Duration
+}
+
+// age makes every bucket look like it was last used d earlier.
+func (l *Limiter) age(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, b := range l.buckets {
+		b.last = b.last.Add(-d)
+	}
+}
+
+func TestAllow(t *testing.T) {
+	user := Limit{Key: "user", Rule: Rule{Burst: 2, Every: time.Second}}
+	otherUser := Limit{Key: "other-user", Rule: Rule{Burst: 2, Every: time.Second}}
+	guild := Limit{Key: "guild", Rule: Rule{Burst: 1, Every: time.Minute}}
+	costly := Limit{Key: "costly", Rule: Rule{Burst: 1, Every: 20 * time.Second}}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "burst then limited",
+			steps: []step{
+				{limits: []Limit{user}, wantOK: true},
+				{limits: []Limit{user}, wantOK: true},
+				{limits: []Limit{user}, wantWait: time.Second},
+			},
+		},
+		{
+			name: "refills over time",
+			steps: []step{
+				{limits: []Limit{user}, wantOK: true},
+				{limits: []Limit{user}, wantOK: true},
+				{age: 500 * time.Millisecond, limits: []Limit{user}, wantWait: 500 * time.Millisecond},
+				{age: 500 * time.Millisecond, limits: []Limit{user}, wantOK: true},
+				{limits: []Limit{user}, wantWait: time.Second},
+			},
+		},
+		{
+			name: "refills up to the burst",
+			steps: []step{
+				{limits: []Limit{user}, wantOK: true},
+				{age: time.Hour, limits: []Limit{user}, wantOK: true},
+				{limits: []Limit{user}, wantOK: true},
+				{limits: []Limit{user}, wantWait: time.Second},
+			},
+		},
+		{
+			name: "an empty guild bucket doesn't spend the user's",
+			steps: []step{
+				{limits: []Limit{user, guild}, wantOK: true},
+				{limits: []Limit{otherUser, guild}, wantWait: time.Minute},
+				{limits: []Limit{otherUser}, wantOK: true},
+				{limits: []Limit{otherUser}, wantOK: true},
+				{limits: []Limit{otherUser}, wantWait: time.Second},
+			},
+		},
+		{
+			name: "an empty user bucket doesn't spend the guild's",
+			steps: []step{
+				{limits: []Limit{user}, wantOK: true},
+				{limits: []Limit{user}, wantOK: true},
+				{limits: []Limit{user, guild}, wantWait: time.Second},
+				{limits: []Limit{otherUser, guild}, wantOK: true},
+			},
+		},
+		{
+			name: "an empty costly bucket doesn't spend the cheap ones",
+			steps: []step{
+				{limits: []Limit{user, guild, costly}, wantOK: true},
+				{age: time.Minute, limits: []Limit{user, costly}, wantOK: true},
+				{limits: []Limit{user, guild, costly}, wantWait: 20 * time.Second},
+				{limits: []Limit{user, guild}, wantOK: true},
+			},
+		},
+		{
+			name: "waits for the slowest bucket",
+			steps: []step{
+				{limits: []Limit{user, guild}, wantOK: true},
+				{limits: []Limit{user}, wantOK: true},
+				{limits: []Limit{user, guild}, wantWait: time.Minute},
+			},
+		},
+		{
+			name: "disabled rules don't limit",
+			steps: []step{
+				{limits: []Limit{{Key: "user", Rule: Rule{Every: time.Minute}}}, wantOK: true},
+				{limits: []Limit{{Key: "user", Rule: Rule{Every: time.Minute}}}, wantOK: true},
+				{limits: []Limit{{Key: "user", Rule: Rule{Burst: 1}}}, wantOK: true},
+				{limits: []Limit{{Key: "user", Rule: Rule{Burst: 1}}}, wantOK: true},
+			},
+		},
+		{
+			name: "a changed rule starts full",
+			steps: []step{
+				{limits: []Limit{guild}, wantOK: true},
+				{limits: []Limit{guild}, wantWait: time.Minute},
+				{limits: []Limit{{Key: "guild", Rule: Rule{Burst: 1, Every: time.Second}}}, wantOK: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := New()
+			for i, s := range tt.steps {
+				l.age(s.age)
+				wait, ok := l.Allow(s.limits...)
+				if ok != s.wantOK {
+					t.Fatalf("step %d: allowed = %v, want %v", i, ok, s.wantOK)
+				}
+				// a little time passes during the test, so the wait can be
+				// slightly shorter than expected
+				if wait > s.wantWait || wait < s.wantWait-100*time.Millisecond {
+					t.Errorf("step %d: wait = %s, want %s", i, wait, s.wantWait)
+				}
+			}
+		})
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var l *Limiter
+	for range 3 {
+		if wait, ok := l.Allow(Limit{Key: "user", Rule: Rule{Burst: 1, Every: time.Minute}}); !ok || wait != 0 {
+			t.Errorf("Allow() = %s, %v, want a nil limiter to allow everything", wait, ok)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	l := New()
+	full := Limit{Key: "full", Rule: Rule{Burst: 1, Every: time.Second}}
+	empty := Limit{Key: "empty", Rule: Rule{Burst: 1, Every: time.Hour}}
+	l.Allow(full, empty)
+
+	l.age(10 * time.Minute)
+	l.lastPruned = time.Time{}
+	l.Allow()
+
+	if _, ok := l.buckets[full.Key]; ok {
+		t.Error("the refilled bucket wasn't pruned")
+	}
+	if _, ok := l.buckets[empty.Key]; !ok {
+		t.Error("the bucket that is still refilling was pruned")
+	}
+}
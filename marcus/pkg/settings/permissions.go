@@ -0,0 +1,239 @@
+package settings
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// Permissions that can be limited to roles or users per guild.
+const (
+	PermTTS        = "tts"
+	PermElevenLabs = "elevenlabs"
+	PermAsk        = "ask"
+	PermAddMeme    = "addmeme"
+	PermMemeAdmin  = "meme-admin"
+	PermCacheAdmin = "cache-admin"
+	PermVolume     = "volume"
+)
+
+// ErrNotAllowed is matched by errors for someone missing a permission.
+var ErrNotAllowed = errors.New("missing permission")
+
+// Permission describes what a permission covers and who has it by default.
+type Permission struct {
+	Name        string
+	Description string
+
+	// AdminOnly permissions are limited to people who can manage the guild
+	// until they are granted to someone else.
+	AdminOnly bool
+}
+
+var Permissions = []Permission{
+	{Name: PermTTS, Description: "text to speech, including combos"},
+	{Name: PermElevenLabs, Description: "generating new ElevenLabs audio, which spends credits"},
+	{Name: PermAsk, Description: "asking the AI models questions"},
+	{Name: PermAddMeme, Description: "adding new memes with !addmeme"},
+	{Name: PermMemeAdmin, Description: "replacing existing memes", AdminOnly: true},
+	{Name: PermCacheAdmin, Description: "managing the TTS cache", AdminOnly: true},
+	{Name: PermVolume, Description: "setting the server's volume with !volume", AdminOnly: true},
+}
+
+// LookupPermission returns the permission with the given name.
+func LookupPermission(name string) (Permission, bool) {
+	for _, p := range Permissions {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return Permission{}, false
+}
+
+// Grant lists the roles and users a permission has been given to.
+type Grant struct {
+	Roles []snowflake.ID `json:"roles,omitempty"`
+	Users []snowflake.ID `json:"users,omitempty"`
+}
+
+// Member is who is asking for a permission.
+type Member struct {
+	UserID  snowflake.ID
+	RoleIDs []snowflake.ID
+	IsAdmin bool
+}
+
+// PermissionStore keeps which roles and users hold each permission in every
+// guild, saving changes to disk so they survive restarts. A permission with no
+// grants falls back to its default, everyone unless it is AdminOnly.
+type PermissionStore struct {
+	sync.Mutex
+	path   string
+	guilds map[snowflake.ID]map[string]Grant
+	logger *slog.Logger
+}
+
+// NewPermissionStore loads the permissions from the audio directory, starting
+// empty if the file does not exist yet.
+func NewPermissionStore(audioDir string, logger *slog.Logger) (*PermissionStore, error) {
+	p := &PermissionStore{
+		path:   filepath.Join(audioDir, "permissions.json"),
+		guilds: map[snowflake.ID]map[string]Grant{},
+		logger: logger,
+	}
+
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return p, nil
+		}
+		return nil, fmt.Errorf("failed to read permissions: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &p.guilds); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal permissions: %w", err)
+	}
+	if p.guilds == nil {
+		p.guilds = map[snowflake.ID]map[string]Grant{}
+	}
+
+	return p, nil
+}
+
+// Allowed reports whether the member holds the permission. Admins hold every
+// permission, and a nil store only applies the defaults.
+func (p *PermissionStore) Allowed(guildID snowflake.ID, name string, member Member) bool {
+	if member.IsAdmin {
+		return true
+	}
+
+	perm, ok := LookupPermission(name)
+	if !ok {
+		return false
+	}
+
+	var grant Grant
+	if p != nil {
+		p.Lock()
+		grant = p.guilds[guildID][name]
+		p.Unlock()
+	}
+
+	if len(grant.Roles) == 0 && len(grant.Users) == 0 {
+		return !perm.AdminOnly
+	}
+	if slices.Contains(grant.Users, member.UserID) {
+		return true
+	}
+	for _, roleID := range member.RoleIDs {
+		if slices.Contains(grant.Roles, roleID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the grants for every permission in the guild.
+func (p *PermissionStore) Get(guildID snowflake.ID) map[string]Grant {
+	p.Lock()
+	defer p.Unlock()
+
+	grants := map[string]Grant{}
+	for name, grant := range p.guilds[guildID] {
+		grants[name] = Grant{Roles: slices.Clone(grant.Roles), Users: slices.Clone(grant.Users)}
+	}
+	return grants
+}
+
+// Allow grants the permission to a role or user.
+func (p *PermissionStore) Allow(guildID snowflake.ID, name string, id snowflake.ID, isRole bool) error {
+	if _, ok := LookupPermission(name); !ok {
+		return fmt.Errorf("unknown permission '%s'", name)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	if p.guilds[guildID] == nil {
+		p.guilds[guildID] = map[string]Grant{}
+	}
+
+	grant := p.guilds[guildID][name]
+	if isRole && !slices.Contains(grant.Roles, id) {
+		grant.Roles = append(grant.Roles, id)
+	} else if !isRole && !slices.Contains(grant.Users, id) {
+		grant.Users = append(grant.Users, id)
+	}
+	p.guilds[guildID][name] = grant
+	return p.save()
+}
+
+// Remove takes the permission away from a role or user. Once nobody is left
+// the permission goes back to its default.
+func (p *PermissionStore) Remove(guildID snowflake.ID, name string, id snowflake.ID) error {
+	if _, ok := LookupPermission(name); !ok {
+		return fmt.Errorf("unknown permission '%s'", name)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	grant, ok := p.guilds[guildID][name]
+	if !ok {
+		return nil
+	}
+
+	grant.Roles = slices.DeleteFunc(grant.Roles, func(r snowflake.ID) bool { return r == id })
+	grant.Users = slices.DeleteFunc(grant.Users, func(u snowflake.ID) bool { return u == id })
+	if len(grant.Roles) == 0 && len(grant.Users) == 0 {
+		delete(p.guilds[guildID], name)
+	} else {
+		p.guilds[guildID][name] = grant
+	}
+	return p.save()
+}
+
+// Reset removes every grant for the permission, putting it back to its default.
+func (p *PermissionStore) Reset(guildID snowflake.ID, name string) error {
+	if _, ok := LookupPermission(name); !ok {
+		return fmt.Errorf("unknown permission '%s'", name)
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	delete(p.guilds[guildID], name)
+	return p.save()
+}
+
+// save writes the permissions atomically using a temp file + rename, the caller must hold the lock.
+func (p *PermissionStore) save() error {
+	if err := os.MkdirAll(filepath.Dir(p.path), 0755); err != nil {
+		return fmt.Errorf("failed to create permissions directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(p.guilds, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal permissions: %w", err)
+	}
+
+	tempPath := p.path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write temp permissions file: %w", err)
+	}
+
+	if err := os.Rename(tempPath, p.path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rename permissions file: %w", err)
+	}
+
+	p.logger.Info("saved permissions", "path", p.path)
+	return nil
+}
@@ -0,0 +1,103 @@
+package settings
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+func TestAllowed(t *testing.T) {
+	const (
+		guildID    snowflake.ID = 10
+		userID     snowflake.ID = 30
+		otherID    snowflake.ID = 31
+		djRoleID   snowflake.ID = 50
+		modsRoleID snowflake.ID = 51
+	)
+
+	type grant struct {
+		perm   string
+		id     snowflake.ID
+		isRole bool
+	}
+
+	tests := []struct {
+		name   string
+		grants []grant
+		perm   string
+		member Member
+		// guildID defaults to the one granted in
+		guildID snowflake.ID
+		want    bool
+	}{
+		{name: "everyone by default", perm: PermTTS, member: Member{UserID: userID}, want: true},
+		{name: "admin only by default", perm: PermVolume, member: Member{UserID: userID}, want: false},
+		{name: "admins hold admin only permissions", perm: PermVolume, member: Member{UserID: userID, IsAdmin: true}, want: true},
+		{name: "unknown permission", perm: "launch-missiles", member: Member{UserID: userID}, want: false},
+		{name: "admins hold unknown permissions", perm: "launch-missiles", member: Member{UserID: userID, IsAdmin: true}, want: true},
+		{name: "granted to the user", grants: []grant{{perm: PermVolume, id: userID}}, perm: PermVolume, member: Member{UserID: userID}, want: true},
+		{name: "granted to the user's role", grants: []grant{{perm: PermVolume, id: djRoleID, isRole: true}}, perm: PermVolume, member: Member{UserID: userID, RoleIDs: []snowflake.ID{modsRoleID, djRoleID}}, want: true},
+		{name: "granted to someone else", grants: []grant{{perm: PermTTS, id: otherID}}, perm: PermTTS, member: Member{UserID: userID}, want: false},
+		{name: "granted to another role", grants: []grant{{perm: PermTTS, id: djRoleID, isRole: true}}, perm: PermTTS, member: Member{UserID: userID, RoleIDs: []snowflake.ID{modsRoleID}}, want: false},
+		{name: "a user's ID isn't a role", grants: []grant{{perm: PermTTS, id: userID, isRole: true}}, perm: PermTTS, member: Member{UserID: userID}, want: false},
+		{name: "admins hold granted permissions", grants: []grant{{perm: PermTTS, id: otherID}}, perm: PermTTS, member: Member{UserID: userID, IsAdmin: true}, want: true},
+		{name: "grants are per permission", grants: []grant{{perm: PermAsk, id: otherID}}, perm: PermTTS, member: Member{UserID: userID}, want: true},
+		{name: "grants are per server", grants: []grant{{perm: PermVolume, id: userID}}, perm: PermVolume, member: Member{UserID: userID}, guildID: guildID + 1, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPermissionStore(t.TempDir(), slog.New(slog.DiscardHandler))
+			if err != nil {
+				t.Fatalf("failed to create permission store: %v", err)
+			}
+			for _, g := range tt.grants {
+				if err := p.Allow(guildID, g.perm, g.id, g.isRole); err != nil {
+					t.Fatalf("failed to grant %s: %v", g.perm, err)
+				}
+			}
+
+			checkGuild := guildID
+			if tt.guildID != 0 {
+				checkGuild = tt.guildID
+			}
+			if got := p.Allowed(checkGuild, tt.perm, tt.member); got != tt.want {
+				t.Errorf("Allowed(%s) = %v, want %v", tt.perm, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllowedAfterRemove(t *testing.T) {
+	p, err := NewPermissionStore(t.TempDir(), slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("failed to create permission store: %v", err)
+	}
+	member := Member{UserID: 30}
+
+	if err := p.Allow(10, PermTTS, 31, false); err != nil {
+		t.Fatalf("failed to grant: %v", err)
+	}
+	if p.Allowed(10, PermTTS, member) {
+		t.Error("allowed while only granted to someone else")
+	}
+
+	// once nobody holds it the permission goes back to its default
+	if err := p.Remove(10, PermTTS, 31); err != nil {
+		t.Fatalf("failed to remove: %v", err)
+	}
+	if !p.Allowed(10, PermTTS, member) {
+		t.Error("not allowed after the last grant was removed")
+	}
+}
+
+func TestAllowedNilStore(t *testing.T) {
+	var p *PermissionStore
+	if !p.Allowed(10, PermTTS, Member{UserID: 30}) {
+		t.Error("a nil store doesn't allow the everyone default")
+	}
+	if p.Allowed(10, PermVolume, Member{UserID: 30}) {
+		t.Error("a nil store allows an admin only permission")
+	}
+}
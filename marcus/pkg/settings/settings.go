@@ -0,0 +1,244 @@
+package settings
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	KeyPrefix        = "prefix"
+	KeyDefaultVoice  = "default-voice"
+	KeyTextChannels  = "text-channels"
+	KeySFWChannels   = "sfw-channels"
+	KeyVoiceChannel  = "voice-channel"
+	KeyPersona       = "persona"
+	KeyMaxTTSLength  = "max-tts-length"
+	KeyCommandGroups = "commands"
+
+	DefaultPrefix       = "!"
+	DefaultMaxTTSLength = 1000
+	MaxTTSLengthLimit   = 5000
+	MaxPersonaLength    = 1500
+)
+
+// Command groups that can be turned on and off per guild. !config is never
+// part of a group so admins can't lock themselves out.
+const (
+	GroupTTS    = "tts"
+	GroupAI     = "ai"
+	GroupMemes  = "memes"
+	GroupFun    = "fun"
+	GroupCombo  = "combo"
+	GroupIntro  = "intro"
+	GroupVolume = "volume"
+)
+
+var Groups = []string{GroupTTS, GroupAI, GroupMemes, GroupFun, GroupCombo, GroupIntro, GroupVolume}
+
+// Setting describes a single per-guild setting.
+type Setting struct {
+	Key         string
+	Description string
+	Default     string
+
+	// normalize validates a new value, returning it in the form it is stored in.
+	normalize func(string) (string, error)
+}
+
+// Settings lists every setting in the order they are shown by !config list.
+var Settings = []Setting{
+	{
+		Key:         KeyPrefix,
+		Description: "prefix for commands, v! always works for voices",
+		Default:     DefaultPrefix,
+		normalize:   normalizePrefix,
+	},
+	{
+		Key:         KeyDefaultVoice,
+		Description: "voice used by !marcus and intros when no voice is given",
+		normalize:   normalizeName,
+	},
+	{
+		Key:         KeyTextChannels,
+		Description: "text channels commands are accepted in, comma separated, empty for all",
+		normalize:   normalizeList,
+	},
+	{
+		Key:         KeySFWChannels,
+		Description: "text channels where slurs are refused, comma separated",
+		Default:     "general",
+		normalize:   normalizeList,
+	},
+	{
+		Key:         KeyVoiceChannel,
+		Description: "voice channel to play in when you aren't in one",
+		normalize:   normalizeChannel,
+	},
+	{
+		Key:         KeyPersona,
+		Description: "personality used by !ask-marcus instead of Marcus, which can use {{user}}, {{server}}, {{channel}}, {{time}}, {{voice_members}} and {{recent_memes}}",
+		normalize:   normalizePersona,
+	},
+	{
+		Key:         KeyMaxTTSLength,
+		Description: "longest message that will be spoken, in characters",
+		Default:     strconv.Itoa(DefaultMaxTTSLength),
+		normalize:   normalizeMaxTTSLength,
+	},
+	{
+		Key:         KeyCommandGroups,
+		Description: fmt.Sprintf("command groups that are turned on, comma separated from %s, or all", strings.Join(Groups, ", ")),
+		Default:     "all",
+		normalize:   normalizeGroups,
+	},
+}
+
+// Lookup returns the setting with the given key.
+func Lookup(key string) (Setting, bool) {
+	for _, s := range Settings {
+		if s.Key == key {
+			return s, true
+		}
+	}
+	return Setting{}, false
+}
+
+// Normalize validates value for the setting, returning what should be stored.
+func (s Setting) Normalize(value string) (string, error) {
+	if s.normalize == nil {
+		return value, nil
+	}
+	return s.normalize(value)
+}
+
+// Guild is a read-only view of one guild's settings with defaults applied.
+type Guild struct {
+	values map[string]string
+}
+
+// Get returns the raw value of a setting, falling back to its default.
+func (g Guild) Get(key string) string {
+	if value, ok := g.values[key]; ok {
+		return value
+	}
+	s, _ := Lookup(key)
+	return s.Default
+}
+
+// IsSet reports whether the guild has changed the setting from its default.
+func (g Guild) IsSet(key string) bool {
+	_, ok := g.values[key]
+	return ok
+}
+
+func (g Guild) Prefix() string {
+	return g.Get(KeyPrefix)
+}
+
+// DefaultVoice returns the guild's default voice, or "" to use the built-in one.
+func (g Guild) DefaultVoice() string {
+	return g.Get(KeyDefaultVoice)
+}
+
+func (g Guild) TextChannels() []string {
+	return splitList(g.Get(KeyTextChannels))
+}
+
+func (g Guild) SFWChannels() []string {
+	return splitList(g.Get(KeySFWChannels))
+}
+
+func (g Guild) VoiceChannel() string {
+	return g.Get(KeyVoiceChannel)
+}
+
+func (g Guild) Persona() string {
+	return g.Get(KeyPersona)
+}
+
+func (g Guild) MaxTTSLength() int {
+	length, err := strconv.Atoi(g.Get(KeyMaxTTSLength))
+	if err != nil {
+		return DefaultMaxTTSLength
+	}
+	return length
+}
+
+// GroupEnabled reports whether commands in the group can be used.
+func (g Guild) GroupEnabled(group string) bool {
+	enabled := g.Get(KeyCommandGroups)
+	return enabled == "all" || slices.Contains(splitList(enabled), group)
+}
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+func normalizePrefix(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == "":
+		return "", fmt.Errorf("the prefix can't be empty")
+	case len(value) > 3:
+		return "", fmt.Errorf("the prefix can be at most 3 characters")
+	case strings.ContainsAny(value, " <>"):
+		return "", fmt.Errorf("the prefix can't contain spaces or angle brackets")
+	case value == "v!":
+		return "", fmt.Errorf("v! is reserved for voices")
+	}
+	return value, nil
+}
+
+func normalizeName(value string) (string, error) {
+	return strings.ToLower(strings.TrimSpace(value)), nil
+}
+
+func normalizeChannel(value string) (string, error) {
+	return strings.TrimPrefix(strings.TrimSpace(value), "#"), nil
+}
+
+func normalizeList(value string) (string, error) {
+	var items []string
+	for item := range strings.SplitSeq(value, ",") {
+		item = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(item), "#"))
+		if item != "" && !slices.Contains(items, item) {
+			items = append(items, item)
+		}
+	}
+	return strings.Join(items, ","), nil
+}
+
+func normalizePersona(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if len(value) > MaxPersonaLength {
+		return "", fmt.Errorf("the persona can be at most %d characters", MaxPersonaLength)
+	}
+	return value, nil
+}
+
+func normalizeMaxTTSLength(value string) (string, error) {
+	length, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || length < 1 || length > MaxTTSLengthLimit {
+		return "", fmt.Errorf("the max TTS length must be a number from 1 to %d", MaxTTSLengthLimit)
+	}
+	return strconv.Itoa(length), nil
+}
+
+func normalizeGroups(value string) (string, error) {
+	if strings.EqualFold(strings.TrimSpace(value), "all") {
+		return "all", nil
+	}
+
+	groups, _ := normalizeList(value)
+	for _, group := range splitList(groups) {
+		if !slices.Contains(Groups, group) {
+			return "", fmt.Errorf("unknown command group '%s', try one of: %s", group, strings.Join(Groups, ", "))
+		}
+	}
+	return groups, nil
+}
@@ -0,0 +1,126 @@
+package settings
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// Store keeps every guild's settings, saving changes to disk so they survive
+// restarts. Only settings that differ from their default are stored.
+type Store struct {
+	sync.Mutex
+	path   string
+	guilds map[snowflake.ID]map[string]string
+	logger *slog.Logger
+}
+
+// NewStore loads the guild settings from the audio directory, starting empty
+// if the file does not exist yet.
+func NewStore(audioDir string, logger *slog.Logger) (*Store, error) {
+	s := &Store{
+		path:   filepath.Join(audioDir, "guild_settings.json"),
+		guilds: map[snowflake.ID]map[string]string{},
+		logger: logger,
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read guild settings: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &s.guilds); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal guild settings: %w", err)
+	}
+	if s.guilds == nil {
+		s.guilds = map[snowflake.ID]map[string]string{}
+	}
+
+	return s, nil
+}
+
+// Get returns the guild's settings. A nil store returns the defaults.
+func (s *Store) Get(guildID snowflake.ID) Guild {
+	if s == nil {
+		return Guild{}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	return Guild{values: maps.Clone(s.guilds[guildID])}
+}
+
+// Set validates and stores a setting, returning the value that was saved.
+func (s *Store) Set(guildID snowflake.ID, key, value string) (string, error) {
+	setting, ok := Lookup(key)
+	if !ok {
+		return "", fmt.Errorf("unknown setting '%s'", key)
+	}
+
+	value, err := setting.Normalize(value)
+	if err != nil {
+		return "", err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if value == setting.Default {
+		delete(s.guilds[guildID], key)
+		return value, s.save()
+	}
+
+	if s.guilds[guildID] == nil {
+		s.guilds[guildID] = map[string]string{}
+	}
+	s.guilds[guildID][key] = value
+	return value, s.save()
+}
+
+// Reset puts a setting back to its default.
+func (s *Store) Reset(guildID snowflake.ID, key string) error {
+	if _, ok := Lookup(key); !ok {
+		return fmt.Errorf("unknown setting '%s'", key)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.guilds[guildID], key)
+	return s.save()
+}
+
+// save writes the settings atomically using a temp file + rename, the caller must hold the lock.
+func (s *Store) save() error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create guild settings directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(s.guilds, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal guild settings: %w", err)
+	}
+
+	tempPath := s.path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write temp guild settings file: %w", err)
+	}
+
+	if err := os.Rename(tempPath, s.path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rename guild settings file: %w", err)
+	}
+
+	s.logger.Info("saved guild settings", "path", s.path)
+	return nil
+}
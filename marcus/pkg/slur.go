@@ -10,8 +10,8 @@ import (
 )
 
 func (c *Command) SaySlur() {
-	if util.MessageInChannel(c.MessageEvent, "general") {
-		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, "I can't say slurs in general anymore :x:", "refusing to say a slur")
+	if channels := c.guild.SFWChannels(); len(channels) > 0 && util.MessageInChannels(c.MessageEvent, channels) {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, "I can't say slurs in this channel anymore :x:", "refusing to say a slur")
 		//c.Session.ChannelMessageSendEmbed(c.MessageEvent.ChannelID, &discordgo.MessageEmbed{
 		//	Title: util.GetRandomEmbedTitle(),
 		//	Image: &discordgo.MessageEmbedImage{
@@ -45,7 +45,7 @@ func (c *Command) SaySlur() {
 
 	msg := fmt.Sprintf("||```\n%s\n```||", content)
 	if fileName, cached := c.TTS.InputIsCached(content); cached {
-		go c.TTS.SpeakFile(c.MessageEvent, fileName, c.TTSOpts.ChannelName)
+		go c.TTS.SpeakFile(c.request(), fileName, c.TTSOpts.ChannelName)
 	} else {
 		msg = msg + "\nUnfortunately we can't generate a voice message for this slur :cry:"
 	}
@@ -0,0 +1,222 @@
+package pkg
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"marcus/pkg/settings"
+	"marcus/pkg/tts"
+	"slices"
+	"strings"
+)
+
+// AITool is a bot feature a model can use while answering a question.
+type AITool struct {
+	Definition ToolDefinition
+	// Run does the work for the command that asked, returning what the model
+	// is told. Errors are told to the model as well, so it can try again.
+	Run func(c *Command, args json.RawMessage) (string, error)
+}
+
+const (
+	maxToolMemeNames   = 50
+	maxToolCachedLines = 10
+)
+
+// aiTools are every tool a model can be given. Only the ones listed in
+// ai.tools are offered.
+var aiTools = map[string]AITool{
+	"play_meme": {
+		Definition: ToolDefinition{
+			Name:        "play_meme",
+			Description: "Play a meme sound effect in the voice channel by name, such as 'bruh'.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"name":{"type":"string","description":"the meme's name"}},"required":["name"]}`),
+		},
+		Run: playMemeTool,
+	},
+	"set_voice": {
+		Definition: ToolDefinition{
+			Name:        "set_voice",
+			Description: "Choose the TTS voice your answer is spoken in.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"voice":{"type":"string","description":"the voice's name"}},"required":["voice"]}`),
+		},
+		Run: setVoiceTool,
+	},
+	"get_joke": {
+		Definition: ToolDefinition{
+			Name:        "get_joke",
+			Description: "Get a random joke.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{}}`),
+		},
+		Run: func(*Command, json.RawMessage) (string, error) {
+			return fetchJoke()
+		},
+	},
+	"get_fact": {
+		Definition: ToolDefinition{
+			Name:        "get_fact",
+			Description: "Get a random useless fact.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{}}`),
+		},
+		Run: func(*Command, json.RawMessage) (string, error) {
+			return fetchFact()
+		},
+	},
+	"search_cached_lines": {
+		Definition: ToolDefinition{
+			Name:        "search_cached_lines",
+			Description: "Search lines that have been spoken before, which can be replayed for free.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"text":{"type":"string","description":"text the line contains"}},"required":["text"]}`),
+		},
+		Run: searchCachedLinesTool,
+	},
+}
+
+func playMemeTool(c *Command, args json.RawMessage) (string, error) {
+	var params struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	if !c.guild.GroupEnabled(settings.GroupMemes) {
+		return "", errors.New("memes are turned off in this server")
+	}
+	if c.MemeSet == nil {
+		return "", errors.New("there are no memes")
+	}
+
+	name := strings.ToLower(strings.TrimSpace(params.Name))
+	meme, found := c.MemeSet.GetMeme(name)
+	if !found {
+		var names []string
+		c.MemeSet.Range(func(key, _ any) bool {
+			names = append(names, key.(string))
+			return true
+		})
+		slices.Sort(names)
+		return "", fmt.Errorf("there's no meme called '%s', try one of: %s", name, strings.Join(names[:min(len(names), maxToolMemeNames)], ", "))
+	}
+
+	c.MemeSet.Played(*c.MessageEvent.GuildID, name)
+	c.TTS.SpeakFile(c.request(), meme, c.TTSOpts.ChannelName)
+	return fmt.Sprintf("playing %s", name), nil
+}
+
+func setVoiceTool(c *Command, args json.RawMessage) (string, error) {
+	var params struct {
+		Voice string `json:"voice"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	voice := strings.ToLower(strings.TrimSpace(params.Voice))
+	if _, err := c.TTS.GetGeneratorForVoice(voice); err != nil {
+		return "", fmt.Errorf("there's no voice called '%s', try one of: %s", voice, strings.Join(c.TTS.ListSupportedVoiceNames(), ", "))
+	}
+
+	c.TTS.Voice = voice
+	return fmt.Sprintf("your answer will be spoken as %s", voice), nil
+}
+
+func searchCachedLinesTool(c *Command, args json.RawMessage) (string, error) {
+	var params struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+
+	entries, err := tts.SearchCacheByText(c.Config.Get().Storage.AudioDir, params.Text, c.Logger)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "nothing cached matches", nil
+	}
+
+	lines := make([]string, 0, maxToolCachedLines)
+	for _, entry := range entries[:min(len(entries), maxToolCachedLines)] {
+		lines = append(lines, entry.Text)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Toolbox is the tools one question may use, with a cap on how many calls
+// it can make.
+type Toolbox struct {
+	command  *Command
+	tools    []AITool
+	maxCalls int
+	calls    []string
+}
+
+// toolbox returns the tools allowed by the config, or nil if there are none.
+func (c *Command) toolbox() *Toolbox {
+	cfg := c.Config.Get().AI
+	if cfg.MaxToolCalls == 0 {
+		return nil
+	}
+
+	t := &Toolbox{command: c, maxCalls: cfg.MaxToolCalls}
+	for _, name := range cfg.Tools {
+		tool, ok := aiTools[name]
+		if !ok {
+			c.Logger.Warn("ignoring unknown AI tool", "tool", name)
+			continue
+		}
+		t.tools = append(t.tools, tool)
+	}
+	if len(t.tools) == 0 {
+		return nil
+	}
+	return t
+}
+
+// Definitions returns the tools to offer, or nil once the calls are used up.
+func (t *Toolbox) Definitions() []ToolDefinition {
+	if t == nil || len(t.calls) >= t.maxCalls {
+		return nil
+	}
+
+	definitions := make([]ToolDefinition, 0, len(t.tools))
+	for _, tool := range t.tools {
+		definitions = append(definitions, tool.Definition)
+	}
+	return definitions
+}
+
+// Run runs an allowed tool and returns what to tell the model.
+func (t *Toolbox) Run(call ToolCall) string {
+	if len(t.calls) >= t.maxCalls {
+		return "you can't use any more tools, answer the question now"
+	}
+
+	i := slices.IndexFunc(t.tools, func(tool AITool) bool { return tool.Definition.Name == call.Name })
+	if i < 0 {
+		return fmt.Sprintf("there's no tool called '%s'", call.Name)
+	}
+	t.calls = append(t.calls, call.Name)
+
+	result, err := t.tools[i].Run(t.command, json.RawMessage(cmp.Or(call.Arguments, "{}")))
+	t.command.Logger.Info("AI used a tool", "tool", call.Name, "args", call.Arguments, "err", err)
+	if err != nil {
+		return fmt.Sprintf("error: %v", err)
+	}
+	return result
+}
+
+// Calls returns the names of the tools that were run, in order.
+func (t *Toolbox) Calls() []string {
+	if t == nil {
+		return nil
+	}
+	return t.calls
+}
+
+func (t *Toolbox) used() bool {
+	return len(t.Calls()) > 0
+}
@@ -8,7 +8,8 @@ import (
 )
 
 type CacheTTSGenerator struct {
-	Logger *slog.Logger
+	Logger   *slog.Logger
+	AudioDir string
 }
 
 func (c CacheTTSGenerator) Name() string {
@@ -18,7 +19,7 @@ func (c CacheTTSGenerator) Name() string {
 func (c CacheTTSGenerator) GenerateTTS(input, voice string) ([]byte, error) {
 	// Use new cache lookup with fallback to legacy
 	provider := "marcus"
-	fileName := getFileNameWithFallback(provider, voice, input, c.Logger)
+	fileName := getFileNameWithFallback(c.AudioDir, provider, voice, input, c.Logger)
 
 	if !fileIsCached(fileName) {
 		return nil, fmt.Errorf("cached file not found for voice '%s'", voice)
@@ -0,0 +1,299 @@
+package tts
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A bundle is a gzipped tar of cached lines for moving them between caches.
+// The manifest comes first, then each line's audio at provider/voice/hash.wav.
+const (
+	bundleVersion  = "1"
+	bundleManifest = "manifest.json"
+)
+
+// BundleManifest lists the lines in a bundle.
+type BundleManifest struct {
+	Version   string        `json:"version"`
+	CreatedAt time.Time     `json:"created_at"`
+	Entries   []BundleEntry `json:"entries"`
+}
+
+// BundleEntry is a cached line in a bundle, with its audio's checksum.
+type BundleEntry struct {
+	Provider string `json:"provider"`
+	Voice    string `json:"voice"`
+	CacheEntry
+	SHA256 string `json:"sha256"`
+}
+
+// path is where the entry's audio is in the bundle.
+func (e BundleEntry) path() string {
+	return path.Join(sanitizeDirectoryName(e.Provider), sanitizeDirectoryName(e.Voice), e.Hash+".wav")
+}
+
+// BundleFilter picks the lines to export. Empty fields match everything.
+type BundleFilter struct {
+	Provider string
+	Voice    string
+	// Since and Until bound when the line was cached, Until is exclusive.
+	Since  time.Time
+	Until  time.Time
+	Search string
+}
+
+func (f BundleFilter) matches(line CachedLine) bool {
+	return (f.Provider == "" || strings.EqualFold(f.Provider, line.Provider)) &&
+		(f.Voice == "" || strings.EqualFold(f.Voice, line.Voice)) &&
+		(f.Since.IsZero() || !line.CreatedAt.Before(f.Since)) &&
+		(f.Until.IsZero() || line.CreatedAt.Before(f.Until))
+}
+
+// ExportCache writes the cached lines matching filter to w as a bundle,
+// returning its manifest. Lines whose audio is missing are left out.
+func ExportCache(baseDir string, filter BundleFilter, w io.Writer, logger *slog.Logger) (*BundleManifest, error) {
+	lines, err := ListCache(baseDir, filter.Search, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &BundleManifest{Version: bundleVersion, CreatedAt: time.Now(), Entries: []BundleEntry{}}
+	files := map[string]string{}
+	for _, line := range lines {
+		if !filter.matches(line) {
+			continue
+		}
+		file, err := CachedAudioPath(baseDir, line.Provider, line.Voice, line.Hash)
+		if err != nil {
+			logger.Warn("skipping cache entry", "provider", line.Provider, "voice", line.Voice, "hash", line.Hash, "err", err)
+			continue
+		}
+		entry := BundleEntry{Provider: line.Provider, Voice: line.Voice, CacheEntry: line.CacheEntry}
+		if _, exported := files[entry.path()]; exported {
+			continue
+		}
+
+		entry.SHA256, entry.FileSize, err = checksum(file)
+		if err != nil {
+			logger.Warn("skipping cached line without audio", "file", file, "err", err)
+			continue
+		}
+		manifest.Entries = append(manifest.Entries, entry)
+		files[entry.path()] = file
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	if err := writeBundleFile(tw, bundleManifest, int64(len(data)), bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	for _, entry := range manifest.Entries {
+		if err := exportAudio(tw, entry, files[entry.path()]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return manifest, nil
+}
+
+func exportAudio(tw *tar.Writer, entry BundleEntry, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to read cached audio: %w", err)
+	}
+	defer f.Close()
+	// the size is the one checksummed, in case the file changed since
+	return writeBundleFile(tw, entry.path(), entry.FileSize, f)
+}
+
+func writeBundleFile(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	header := &tar.Header{Name: name, Mode: 0644, Size: size, ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s to the bundle: %w", name, err)
+	}
+	if _, err := io.CopyN(tw, r, size); err != nil {
+		return fmt.Errorf("failed to write %s to the bundle: %w", name, err)
+	}
+	return nil
+}
+
+// checksum returns a file's SHA-256 and size.
+func checksum(file string) (string, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// BundleImportResult is what ImportCache merged into the cache.
+type BundleImportResult struct {
+	Imported int
+	// Duplicates were already cached.
+	Duplicates int
+	// Rejected are the bundle's lines that weren't imported, and why.
+	Rejected map[string]string
+}
+
+// ImportCache merges a bundle into the cache. Lines already cached are kept,
+// audio that doesn't match its checksum is rejected, and the metadata and
+// master_metadata.json are rebuilt to include what was imported.
+func ImportCache(baseDir string, r io.Reader, logger *slog.Logger) (BundleImportResult, error) {
+	result := BundleImportResult{Rejected: map[string]string{}}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return result, fmt.Errorf("not a cache bundle: %w", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != bundleManifest {
+		return result, errors.New("not a cache bundle, it doesn't start with a manifest")
+	}
+	var manifest BundleManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return result, fmt.Errorf("failed to read the bundle's manifest: %w", err)
+	}
+	if manifest.Version != bundleVersion {
+		return result, fmt.Errorf("unsupported bundle version %q", manifest.Version)
+	}
+
+	expected := map[string]BundleEntry{}
+	for _, entry := range manifest.Entries {
+		if generateHash(entry.Text) != entry.Hash {
+			result.Rejected[entry.path()] = "hash doesn't match its text"
+			continue
+		}
+		if _, err := CachedAudioPath(baseDir, entry.Provider, entry.Voice, entry.Hash); err != nil {
+			result.Rejected[entry.path()] = err.Error()
+			continue
+		}
+		expected[entry.path()] = entry
+	}
+
+	// metadata path -> entries to merge into it
+	merged := map[string][]BundleEntry{}
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		entry, ok := expected[header.Name]
+		if !ok {
+			continue
+		}
+		delete(expected, header.Name)
+
+		file, _ := CachedAudioPath(baseDir, entry.Provider, entry.Voice, entry.Hash)
+		if info, err := os.Stat(file); err == nil {
+			// keep what's there, but make sure the metadata knows about it
+			result.Duplicates++
+			entry.FileSize = info.Size()
+		} else {
+			if err := importAudio(file, entry, tr); err != nil {
+				result.Rejected[header.Name] = err.Error()
+				continue
+			}
+			result.Imported++
+		}
+		metadataPath := filepath.Join(filepath.Dir(file), "metadata.json")
+		merged[metadataPath] = append(merged[metadataPath], entry)
+	}
+	for name := range expected {
+		result.Rejected[name] = "audio is missing from the bundle"
+	}
+
+	for metadataPath, entries := range merged {
+		if err := mergeMetadata(metadataPath, entries, logger); err != nil {
+			return result, err
+		}
+	}
+	return result, updateMasterMetadata(baseDir, logger)
+}
+
+// importAudio writes an entry's audio into the cache if it matches its checksum.
+func importAudio(file string, entry BundleEntry, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	tempName := file + ".tmp"
+	f, err := os.Create(tempName)
+	if err != nil {
+		return fmt.Errorf("failed to write audio: %w", err)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	switch {
+	case err != nil:
+		err = fmt.Errorf("failed to write audio: %w", err)
+	case size != entry.FileSize || hex.EncodeToString(hash.Sum(nil)) != entry.SHA256:
+		err = errors.New("audio doesn't match its checksum")
+	default:
+		err = os.Rename(tempName, file)
+	}
+	if err != nil {
+		os.Remove(tempName)
+	}
+	return err
+}
+
+// mergeMetadata adds entries to a metadata file, skipping hashes it already has.
+func mergeMetadata(metadataPath string, entries []BundleEntry, logger *slog.Logger) error {
+	metadata := loadOrCreateMetadata(metadataPath, entries[0].Provider, entries[0].Voice, logger)
+	known := map[string]bool{}
+	for _, entry := range metadata.CacheEntries {
+		known[entry.Hash] = true
+	}
+
+	added := 0
+	for _, entry := range entries {
+		if known[entry.Hash] {
+			continue
+		}
+		known[entry.Hash] = true
+		metadata.CacheEntries = append(metadata.CacheEntries, entry.CacheEntry)
+		added++
+	}
+	if added == 0 {
+		return nil
+	}
+	return saveMetadataAtomic(metadataPath, metadata, logger)
+}
@@ -0,0 +1,171 @@
+package tts
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// cacheTestLines caches a few lines across voices, hello a week ago.
+func cacheTestLines(t *testing.T, dir string) {
+	t.Helper()
+	cacheLine(t, dir, "liam", "hello")
+	cacheLine(t, dir, "liam", "bye")
+	cacheLine(t, dir, "tim", "hello there")
+
+	path := filepath.Join(dir, "elevenlabs", "liam", "metadata.json")
+	metadata, err := readMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range metadata.CacheEntries {
+		if metadata.CacheEntries[i].Text == "hello" {
+			metadata.CacheEntries[i].CreatedAt = time.Now().AddDate(0, 0, -7)
+		}
+	}
+	if err := saveMetadataAtomic(path, metadata, slog.New(slog.DiscardHandler)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter BundleFilter
+		want   []string
+	}{
+		{name: "everything", want: []string{"bye", "hello", "hello there"}},
+		{name: "voice", filter: BundleFilter{Voice: "Liam"}, want: []string{"bye", "hello"}},
+		{name: "provider", filter: BundleFilter{Provider: "tiktok"}},
+		{name: "search", filter: BundleFilter{Search: "HELLO"}, want: []string{"hello", "hello there"}},
+		{name: "since", filter: BundleFilter{Since: time.Now().AddDate(0, 0, -1)}, want: []string{"bye", "hello there"}},
+		{name: "until", filter: BundleFilter{Until: time.Now().AddDate(0, 0, -1)}, want: []string{"hello"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cacheTestLines(t, dir)
+
+			var bundle bytes.Buffer
+			manifest, err := ExportCache(dir, tt.filter, &bundle, slog.New(slog.DiscardHandler))
+			if err != nil {
+				t.Fatalf("failed to export: %v", err)
+			}
+			var got []string
+			for _, entry := range manifest.Entries {
+				got = append(got, entry.Text)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("exported %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportCache(t *testing.T) {
+	tests := []struct {
+		name           string
+		existing       []string
+		tamper         string
+		wantImported   int
+		wantDuplicates int
+		wantRejected   int
+	}{
+		{name: "into an empty cache", wantImported: 3},
+		{name: "skips lines already cached", existing: []string{"hello", "other"}, wantImported: 2, wantDuplicates: 1},
+		{name: "rejects audio that doesn't match its checksum", tamper: "bye", wantImported: 2, wantRejected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.DiscardHandler)
+			src := t.TempDir()
+			cacheTestLines(t, src)
+			bundle := &bytes.Buffer{}
+			if _, err := ExportCache(src, BundleFilter{}, bundle, logger); err != nil {
+				t.Fatalf("failed to export: %v", err)
+			}
+			if tt.tamper != "" {
+				bundle = tamperBundle(t, bundle, generateHash(tt.tamper)+".wav")
+			}
+
+			dst := t.TempDir()
+			for _, text := range tt.existing {
+				cacheLine(t, dst, "liam", text)
+			}
+			result, err := ImportCache(dst, bundle, logger)
+			if err != nil {
+				t.Fatalf("failed to import: %v", err)
+			}
+			if result.Imported != tt.wantImported || result.Duplicates != tt.wantDuplicates || len(result.Rejected) != tt.wantRejected {
+				t.Errorf("ImportCache() = %+v, want %d imported, %d duplicates and %d rejected", result, tt.wantImported, tt.wantDuplicates, tt.wantRejected)
+			}
+
+			if problems, err := VerifyCache(dst); err != nil || len(problems) != 0 {
+				t.Errorf("cache has problems after importing: %+v %v", problems, err)
+			}
+			stats, err := GetCacheStats(dst, logger)
+			if err != nil {
+				t.Fatalf("failed to read master metadata: %v", err)
+			}
+			wantFiles := tt.wantImported + len(tt.existing)
+			if stats.TotalFiles != wantFiles {
+				t.Errorf("master metadata has %d files, want %d", stats.TotalFiles, wantFiles)
+			}
+
+			// the original dates come along
+			lines, _ := ListCache(dst, "hello", logger)
+			for _, line := range lines {
+				if line.Text == "hello" && time.Since(line.CreatedAt) < 24*time.Hour && !slices.Contains(tt.existing, "hello") {
+					t.Errorf("hello was cached at %s, want a week ago", line.CreatedAt)
+				}
+			}
+		})
+	}
+}
+
+func TestImportCacheNotABundle(t *testing.T) {
+	if _, err := ImportCache(t.TempDir(), bytes.NewReader([]byte("not a bundle")), slog.New(slog.DiscardHandler)); err == nil {
+		t.Error("importing something that isn't a bundle should fail")
+	}
+}
+
+// tamperBundle rewrites a bundle with the audio of the file ending in name changed.
+func tamperBundle(t *testing.T, bundle *bytes.Buffer, name string) *bytes.Buffer {
+	t.Helper()
+	gz, err := gzip.NewReader(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+
+	out := &bytes.Buffer{}
+	gzOut := gzip.NewWriter(out)
+	tw := tar.NewWriter(gzOut)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		if filepath.Base(header.Name) == name {
+			data = bytes.ToUpper(data)
+		}
+		tw.WriteHeader(header)
+		tw.Write(data)
+	}
+	tw.Close()
+	gzOut.Close()
+	return out
+}
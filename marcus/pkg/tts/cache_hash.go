@@ -4,7 +4,6 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"log/slog"
-	"os"
 	"path/filepath"
 )
 
@@ -16,12 +15,7 @@ func generateHash(input string) string {
 }
 
 // getCachePath returns the new hierarchical cache path for a given provider, voice, and input
-func getCachePath(provider, voice, input string) string {
-	baseDir := os.Getenv("AUDIO_DIR")
-	if baseDir == "" {
-		baseDir = filepath.Join(".", "audio")
-	}
-
+func getCachePath(baseDir, provider, voice, input string) string {
 	// Normalize provider and voice names
 	providerDir := sanitizeDirectoryName(provider)
 	voiceDir := sanitizeDirectoryName(voice)
@@ -35,6 +29,16 @@ func getCachePath(provider, voice, input string) string {
 	return filepath.Join(baseDir, providerDir, voiceDir, hash+".wav")
 }
 
+// effectsDir is the directory in each voice's cache that audio with effects
+// applied is kept in. It has no metadata, so renders aren't listed as lines.
+const effectsDir = "fx"
+
+// getEffectCachePath gets the cache path for input with effects applied. It's
+// apart from the lines, so no line's text can be served a render.
+func getEffectCachePath(baseDir, provider, voice string, effects EffectChain, input string) string {
+	return filepath.Join(baseDir, sanitizeDirectoryName(provider), sanitizeDirectoryName(voice), effectsDir, generateHash(effects.CacheKey(input))+".wav")
+}
+
 // getProviderFromGeneratorName maps generator names to provider names for the cache hierarchy
 func getProviderFromGeneratorName(generatorName string) string {
 	if generatorName == "tiktok" {
@@ -44,29 +48,29 @@ func getProviderFromGeneratorName(generatorName string) string {
 }
 
 // getCachePathForGenerator gets the cache path using the generator's name as provider
-func getCachePathForGenerator(generator Generator, voice, input string) string {
+func getCachePathForGenerator(baseDir string, generator Generator, voice, input string) string {
 	provider := getProviderFromGeneratorName(generator.Name())
-	return getCachePath(provider, voice, input)
+	return getCachePath(baseDir, provider, voice, input)
 }
 
 // getFileNameWithFallback tries new cache path first, then falls back to legacy if it exists
-func getFileNameWithFallback(provider, voice, input string, logger *slog.Logger) string {
+func getFileNameWithFallback(baseDir, provider, voice, input string, logger *slog.Logger) string {
 	// Try new hash-based path first
-	newPath := getCachePath(provider, voice, input)
+	newPath := getCachePath(baseDir, provider, voice, input)
 	if fileIsCached(newPath) {
 		logger.Info("found file in new cache structure", "path", newPath)
 		return newPath
 	}
 
 	// Fall back to legacy flat structure
-	legacyPath := legacyFileName(input)
+	legacyPath := legacyFileName(baseDir, input)
 	if fileIsCached(legacyPath) {
 		logger.Info("found file in legacy cache structure", "path", legacyPath)
 		return legacyPath
 	}
 
 	// Also check voice-prefixed legacy format
-	voicePrefixedLegacy := getFileName(input, voice)
+	voicePrefixedLegacy := getFileName(baseDir, input, voice)
 	if fileIsCached(voicePrefixedLegacy) {
 		logger.Info("found file in voice-prefixed legacy cache", "path", voicePrefixedLegacy)
 		return voicePrefixedLegacy
@@ -76,17 +80,3 @@ func getFileNameWithFallback(provider, voice, input string, logger *slog.Logger)
 	logger.Info("no cached file found", "path", newPath)
 	return newPath
 }
-
-// ensureCacheDirectory creates the cache directory structure if it doesn't exist
-func ensureCacheDirectory(provider, voice string) error {
-	baseDir := os.Getenv("AUDIO_DIR")
-	if baseDir == "" {
-		baseDir = filepath.Join(".", "audio")
-	}
-
-	providerDir := sanitizeDirectoryName(provider)
-	voiceDir := sanitizeDirectoryName(voice)
-
-	dir := filepath.Join(baseDir, providerDir, voiceDir)
-	return os.MkdirAll(dir, 0755)
-}
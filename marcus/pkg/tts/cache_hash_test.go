@@ -0,0 +1,126 @@
+package tts
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetCachePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		voice    string
+		input    string
+		want     string
+	}{
+		{name: "elevenlabs", provider: "elevenlabs", voice: "liam", input: "hello", want: "elevenlabs/liam/2cf24dba5fb0.wav"},
+		{name: "same text, same hash", provider: "elevenlabs", voice: "tim", input: "hello", want: "elevenlabs/tim/2cf24dba5fb0.wav"},
+		{name: "hash is case sensitive", provider: "elevenlabs", voice: "liam", input: "Hello", want: "elevenlabs/liam/185f8db32271.wav"},
+		{name: "unsafe voice names are sanitized", provider: "elevenlabs", voice: "a/b:c", input: "hello", want: "elevenlabs/a_b_c/2cf24dba5fb0.wav"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := getCachePath("/audio", tt.provider, tt.voice, tt.input)
+			if want := filepath.Join("/audio", filepath.FromSlash(tt.want)); got != want {
+				t.Errorf("getCachePath(%q, %q, %q) = %q, want %q", tt.provider, tt.voice, tt.input, got, want)
+			}
+		})
+	}
+}
+
+func TestGetEffectCachePath(t *testing.T) {
+	effects := EffectChain{Reverse{}}
+	got := getEffectCachePath("/audio", "elevenlabs", "liam", effects, "hello")
+	if want := filepath.Join("/audio", "elevenlabs", "liam", effectsDir, generateHash("hello |fx:reverse")+".wav"); got != want {
+		t.Errorf("getEffectCachePath = %q, want %q", got, want)
+	}
+	// typing the cache key must not find the render
+	if typed := getCachePath("/audio", "elevenlabs", "liam", effects.CacheKey("hello")); typed == got {
+		t.Errorf("the text %q is cached at the render's path %q", effects.CacheKey("hello"), got)
+	}
+}
+
+func TestGetProviderFromGeneratorName(t *testing.T) {
+	for generator, want := range map[string]string{
+		"tiktok":                "marcus",
+		ElevenLabsGeneratorName: ElevenLabsGeneratorName,
+		"cache":                 "cache",
+	} {
+		if got := getProviderFromGeneratorName(generator); got != want {
+			t.Errorf("getProviderFromGeneratorName(%q) = %q, want %q", generator, got, want)
+		}
+	}
+}
+
+func TestGetFileNameWithFallback(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.DiscardHandler)
+
+	cached := getCachePath(dir, "elevenlabs", "liam", "new line")
+	legacy := filepath.Join(dir, "old_line.wav")
+	for _, file := range []string{cached, legacy} {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "cached", input: "new line", want: cached},
+		{name: "legacy flat file", input: "old line", want: legacy},
+		{name: "not cached uses the new layout", input: "brand new", want: getCachePath(dir, "elevenlabs", "liam", "brand new")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getFileNameWithFallback(dir, "elevenlabs", "liam", tt.input, logger); got != tt.want {
+				t.Errorf("getFileNameWithFallback(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachedAudioPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		voice    string
+		hash     string
+		want     string
+	}{
+		{name: "valid", provider: "elevenlabs", voice: "liam", hash: "2cf24dba5fb0", want: "/audio/elevenlabs/liam/2cf24dba5fb0.wav"},
+		{name: "hash with a path", provider: "elevenlabs", voice: "liam", hash: "../../etc/passwd"},
+		{name: "short hash", provider: "elevenlabs", voice: "liam", hash: "2cf24d"},
+		{name: "uppercase hash", provider: "elevenlabs", voice: "liam", hash: "2CF24DBA5FB0"},
+		{name: "parent directory voice", provider: "elevenlabs", voice: "..", hash: "2cf24dba5fb0"},
+		{name: "empty provider", provider: "", voice: "liam", hash: "2cf24dba5fb0"},
+		{name: "slashes are sanitized", provider: "../elevenlabs", voice: "liam", hash: "2cf24dba5fb0", want: "/audio/.._elevenlabs/liam/2cf24dba5fb0.wav"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CachedAudioPath("/audio", tt.provider, tt.voice, tt.hash)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("CachedAudioPath = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want := filepath.FromSlash(tt.want); got != want {
+				t.Errorf("CachedAudioPath = %q, want %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package tts
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// PruneResult is what PruneCache removed, or would have removed on a dry run.
+type PruneResult struct {
+	// TempFiles are audio left half written by a crash.
+	TempFiles int
+	// MissingEntries are metadata entries whose audio file is gone.
+	MissingEntries int
+	// Expired are lines cached before the cutoff.
+	Expired int
+	Freed   int64
+}
+
+// metadataFiles returns the path of every provider/voice metadata.json.
+func metadataFiles(baseDir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(baseDir, "*", "*", "metadata.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find cache metadata: %w", err)
+	}
+	return paths, nil
+}
+
+// readMetadata reads one provider/voice metadata.json.
+func readMetadata(path string) (*CacheMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var metadata CacheMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// PruneCache removes leftover temp files and metadata entries for audio that
+// no longer exists. When before isn't zero, lines cached before it are deleted
+// too. Nothing is changed on a dry run.
+func PruneCache(baseDir string, before time.Time, dryRun bool, logger *slog.Logger) (PruneResult, error) {
+	var result PruneResult
+
+	temps, err := filepath.Glob(filepath.Join(baseDir, "*", "*", "*.tmp"))
+	if err != nil {
+		return result, fmt.Errorf("failed to find temp files: %w", err)
+	}
+	fxTemps, err := filepath.Glob(filepath.Join(baseDir, "*", "*", effectsDir, "*.tmp"))
+	if err != nil {
+		return result, fmt.Errorf("failed to find temp files: %w", err)
+	}
+	temps = append(temps, fxTemps...)
+	for _, temp := range temps {
+		info, err := os.Stat(temp)
+		// the bot may be writing it right now
+		if err != nil || time.Since(info.ModTime()) < time.Minute {
+			continue
+		}
+		result.TempFiles++
+		result.Freed += info.Size()
+		if !dryRun {
+			if err := os.Remove(temp); err != nil {
+				return result, fmt.Errorf("failed to remove temp file: %w", err)
+			}
+		}
+	}
+
+	paths, err := metadataFiles(baseDir)
+	if err != nil {
+		return result, err
+	}
+	for _, path := range paths {
+		if err := pruneMetadata(path, before, dryRun, &result, logger); err != nil {
+			return result, err
+		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	return result, updateMasterMetadata(baseDir, logger)
+}
+
+func pruneMetadata(path string, before time.Time, dryRun bool, result *PruneResult, logger *slog.Logger) error {
+	metadata, err := readMetadata(path)
+	if err != nil {
+		logger.Warn("skipping unreadable cache metadata", "path", path, "err", err)
+		return nil
+	}
+
+	dir := filepath.Dir(path)
+	kept := metadata.CacheEntries[:0]
+	for _, entry := range metadata.CacheEntries {
+		file := filepath.Join(dir, entry.Hash+".wav")
+		info, err := os.Stat(file)
+		switch {
+		case err != nil:
+			result.MissingEntries++
+		case !before.IsZero() && entry.CreatedAt.Before(before):
+			result.Expired++
+			result.Freed += info.Size()
+			if !dryRun {
+				if err := os.Remove(file); err != nil {
+					return fmt.Errorf("failed to remove cached audio: %w", err)
+				}
+			}
+		default:
+			kept = append(kept, entry)
+		}
+	}
+
+	if dryRun || len(kept) == len(metadata.CacheEntries) {
+		return nil
+	}
+	metadata.CacheEntries = kept
+	return saveMetadataAtomic(path, metadata, logger)
+}
+
+// CacheProblem is something wrong with a cached file or its metadata.
+type CacheProblem struct {
+	Path    string
+	Problem string
+}
+
+// VerifyCache checks every cached line's audio exists, is the size its
+// metadata says and has the hash of its text, and that every audio file has
+// metadata.
+func VerifyCache(baseDir string) ([]CacheProblem, error) {
+	var problems []CacheProblem
+	report := func(path, format string, args ...any) {
+		problems = append(problems, CacheProblem{Path: path, Problem: fmt.Sprintf(format, args...)})
+	}
+
+	paths, err := metadataFiles(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[string]bool{}
+	for _, path := range paths {
+		metadata, err := readMetadata(path)
+		if err != nil {
+			report(path, "unreadable metadata: %v", err)
+			continue
+		}
+
+		dir := filepath.Dir(path)
+		for _, entry := range metadata.CacheEntries {
+			file := filepath.Join(dir, entry.Hash+".wav")
+			if known[file] {
+				report(file, "listed more than once")
+				continue
+			}
+			known[file] = true
+
+			if hash := generateHash(entry.Text); hash != entry.Hash {
+				report(file, "hash doesn't match its text %q, which hashes to %s", entry.Text, hash)
+			}
+			info, err := os.Stat(file)
+			if err != nil {
+				report(file, "missing audio for %q", entry.Text)
+				continue
+			}
+			if info.Size() != entry.FileSize {
+				report(file, "is %d bytes, metadata says %d", info.Size(), entry.FileSize)
+			}
+		}
+	}
+
+	// only provider/voice/hash.wav is cache, flat files are the legacy layout
+	files, err := filepath.Glob(filepath.Join(baseDir, "*", "*", "*.wav"))
+	if err != nil {
+		return problems, fmt.Errorf("failed to find cached audio: %w", err)
+	}
+	for _, file := range files {
+		if !known[file] {
+			report(file, "audio isn't in the metadata")
+		}
+	}
+
+	return problems, nil
+}
@@ -0,0 +1,150 @@
+package tts
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// cacheLine writes text's audio and metadata into the cache in dir.
+func cacheLine(t *testing.T, dir, voice, text string) string {
+	t.Helper()
+	path := getCachePath(dir, "elevenlabs", voice, text)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("audio for "+text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateMetadata(dir, "elevenlabs", voice, generateHash(text), text, int64(len("audio for "+text)), slog.New(slog.DiscardHandler)); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPruneCache(t *testing.T) {
+	tests := []struct {
+		name        string
+		breakCache  func(hello string)
+		expireAll   bool
+		dryRun      bool
+		want        PruneResult
+		wantEntries int
+	}{
+		{name: "nothing to prune", wantEntries: 2},
+		{
+			name:        "missing audio",
+			breakCache:  func(hello string) { os.Remove(hello) },
+			want:        PruneResult{MissingEntries: 1},
+			wantEntries: 1,
+		},
+		{
+			name: "old temp file",
+			breakCache: func(hello string) {
+				temp := hello + ".tmp"
+				os.WriteFile(temp, []byte("half"), 0644)
+				old := time.Now().Add(-time.Hour)
+				os.Chtimes(temp, old, old)
+			},
+			want:        PruneResult{TempFiles: 1, Freed: 4},
+			wantEntries: 2,
+		},
+		{
+			name:        "temp file still being written",
+			breakCache:  func(hello string) { os.WriteFile(hello+".tmp", []byte("half"), 0644) },
+			wantEntries: 2,
+		},
+		{
+			name:        "lines older than the cutoff",
+			expireAll:   true,
+			want:        PruneResult{Expired: 2, Freed: int64(len("audio for hello") + len("audio for bye"))},
+			wantEntries: 0,
+		},
+		{
+			name:        "dry run changes nothing",
+			breakCache:  func(hello string) { os.Remove(hello) },
+			dryRun:      true,
+			want:        PruneResult{MissingEntries: 1},
+			wantEntries: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			hello := cacheLine(t, dir, "liam", "hello")
+			cacheLine(t, dir, "liam", "bye")
+			if tt.breakCache != nil {
+				tt.breakCache(hello)
+			}
+
+			var before time.Time
+			if tt.expireAll {
+				before = time.Now().Add(time.Minute)
+			}
+			got, err := PruneCache(dir, before, tt.dryRun, slog.New(slog.DiscardHandler))
+			if err != nil {
+				t.Fatalf("failed to prune: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("PruneCache() = %+v, want %+v", got, tt.want)
+			}
+
+			metadata, err := readMetadata(filepath.Join(filepath.Dir(hello), "metadata.json"))
+			if err != nil {
+				t.Fatalf("failed to read metadata: %v", err)
+			}
+			if len(metadata.CacheEntries) != tt.wantEntries {
+				t.Errorf("%d entries left, want %d", len(metadata.CacheEntries), tt.wantEntries)
+			}
+		})
+	}
+}
+
+func TestVerifyCache(t *testing.T) {
+	tests := []struct {
+		name       string
+		breakCache func(hello string)
+		want       int
+	}{
+		{name: "healthy"},
+		{name: "missing audio", breakCache: func(hello string) { os.Remove(hello) }, want: 1},
+		{name: "wrong size", breakCache: func(hello string) { os.WriteFile(hello, []byte("short"), 0644) }, want: 1},
+		{
+			name: "audio without metadata",
+			breakCache: func(hello string) {
+				os.WriteFile(filepath.Join(filepath.Dir(hello), "ffffffffffff.wav"), []byte("stray"), 0644)
+			},
+			want: 1,
+		},
+		{
+			name: "unreadable metadata",
+			breakCache: func(hello string) {
+				os.WriteFile(filepath.Join(filepath.Dir(hello), "metadata.json"), []byte("{"), 0644)
+			},
+			// and both lines' audio is then unknown
+			want: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			hello := cacheLine(t, dir, "liam", "hello")
+			cacheLine(t, dir, "liam", "bye")
+			if tt.breakCache != nil {
+				tt.breakCache(hello)
+			}
+
+			problems, err := VerifyCache(dir)
+			if err != nil {
+				t.Fatalf("failed to verify: %v", err)
+			}
+			if len(problems) != tt.want {
+				t.Errorf("found %d problems %+v, want %d", len(problems), problems, tt.want)
+			}
+		})
+	}
+}
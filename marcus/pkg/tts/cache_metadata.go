@@ -3,9 +3,13 @@ package tts
 import (
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -124,12 +128,7 @@ func saveMetadataAtomic(metadataPath string, metadata *CacheMetadata, logger *sl
 }
 
 // updateMetadata adds a new cache entry to the metadata file
-func updateMetadata(provider, voice, hash, text string, fileSize int64, logger *slog.Logger) error {
-	baseDir := os.Getenv("AUDIO_DIR")
-	if baseDir == "" {
-		baseDir = filepath.Join(".", "audio")
-	}
-
+func updateMetadata(baseDir, provider, voice, hash, text string, fileSize int64, logger *slog.Logger) error {
 	metadataPath := filepath.Join(baseDir, sanitizeDirectoryName(provider), sanitizeDirectoryName(voice), "metadata.json")
 
 	metadata := loadOrCreateMetadata(metadataPath, provider, voice, logger)
@@ -144,7 +143,7 @@ func updateMetadata(provider, voice, hash, text string, fileSize int64, logger *
 			if err := saveMetadataAtomic(metadataPath, metadata, logger); err != nil {
 				return err
 			}
-			return updateMasterMetadata(logger)
+			return updateMasterMetadata(baseDir, logger)
 		}
 	}
 
@@ -159,19 +158,14 @@ func updateMetadata(provider, voice, hash, text string, fileSize int64, logger *
 		return err
 	}
 
-	return updateMasterMetadata(logger)
+	return updateMasterMetadata(baseDir, logger)
 }
 
 // loadMasterMetadata loads the master metadata file
-func loadMasterMetadata(logger *slog.Logger) *MasterMetadata {
+func loadMasterMetadata(baseDir string, logger *slog.Logger) *MasterMetadata {
 	masterLock.Lock()
 	defer masterLock.Unlock()
 
-	baseDir := os.Getenv("AUDIO_DIR")
-	if baseDir == "" {
-		baseDir = filepath.Join(".", "audio")
-	}
-
 	masterPath := filepath.Join(baseDir, "master_metadata.json")
 
 	if _, err := os.Stat(masterPath); err == nil {
@@ -205,15 +199,10 @@ func newMasterMetadata() *MasterMetadata {
 }
 
 // saveMasterMetadata saves the master metadata atomically
-func saveMasterMetadata(master *MasterMetadata, logger *slog.Logger) error {
+func saveMasterMetadata(baseDir string, master *MasterMetadata, logger *slog.Logger) error {
 	masterLock.Lock()
 	defer masterLock.Unlock()
 
-	baseDir := os.Getenv("AUDIO_DIR")
-	if baseDir == "" {
-		baseDir = filepath.Join(".", "audio")
-	}
-
 	masterPath := filepath.Join(baseDir, "master_metadata.json")
 
 	// Ensure directory exists
@@ -244,12 +233,7 @@ func saveMasterMetadata(master *MasterMetadata, logger *slog.Logger) error {
 }
 
 // updateMasterMetadata recalculates and updates the master metadata by scanning all provider metadata files
-func updateMasterMetadata(logger *slog.Logger) error {
-	baseDir := os.Getenv("AUDIO_DIR")
-	if baseDir == "" {
-		baseDir = filepath.Join(".", "audio")
-	}
-
+func updateMasterMetadata(baseDir string, logger *slog.Logger) error {
 	master := newMasterMetadata()
 
 	// Walk through all provider/voice directories
@@ -307,7 +291,7 @@ func updateMasterMetadata(logger *slog.Logger) error {
 	}
 
 	master.LastUpdated = time.Now()
-	return saveMasterMetadata(master, logger)
+	return saveMasterMetadata(baseDir, master, logger)
 }
 
 // sanitizeDirectoryName sanitizes a string to be used as a directory name
@@ -316,22 +300,17 @@ func sanitizeDirectoryName(name string) string {
 }
 
 // GetCacheStats returns cache statistics from the master metadata
-func GetCacheStats(logger *slog.Logger) (*MasterMetadata, error) {
+func GetCacheStats(baseDir string, logger *slog.Logger) (*MasterMetadata, error) {
 	// Ensure master metadata is up to date
-	if err := updateMasterMetadata(logger); err != nil {
+	if err := updateMasterMetadata(baseDir, logger); err != nil {
 		return nil, err
 	}
 
-	return loadMasterMetadata(logger), nil
+	return loadMasterMetadata(baseDir, logger), nil
 }
 
 // SearchCacheByText searches for cache entries containing the given text
-func SearchCacheByText(searchText string, logger *slog.Logger) ([]CacheEntry, error) {
-	baseDir := os.Getenv("AUDIO_DIR")
-	if baseDir == "" {
-		baseDir = filepath.Join(".", "audio")
-	}
-
+func SearchCacheByText(baseDir, searchText string, logger *slog.Logger) ([]CacheEntry, error) {
 	var results []CacheEntry
 
 	err := filepath.Walk(baseDir, func(path string, info os.FileInfo, err error) error {
@@ -374,3 +353,68 @@ func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(substr) == 0 ||
 		len(s) > 0 && (s[0:len(substr)] == substr || contains(s[1:], substr)))
 }
+
+// CachedLine is a cache entry along with the provider and voice it was made with.
+type CachedLine struct {
+	CacheEntry
+	Provider string
+	Voice    string
+}
+
+// ListCache returns every cached line whose text contains search, ignoring
+// case, newest first. An empty search returns everything.
+func ListCache(baseDir, search string, logger *slog.Logger) ([]CachedLine, error) {
+	search = strings.ToLower(search)
+	var results []CachedLine
+
+	err := filepath.WalkDir(baseDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || d.Name() != "metadata.json" {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			logger.Warn("failed to read cache metadata", "path", path, "err", err)
+			return nil
+		}
+
+		var metadata CacheMetadata
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			logger.Warn("failed to unmarshal cache metadata", "path", path, "err", err)
+			return nil
+		}
+
+		for _, entry := range metadata.CacheEntries {
+			if strings.Contains(strings.ToLower(entry.Text), search) {
+				results = append(results, CachedLine{CacheEntry: entry, Provider: metadata.Provider, Voice: metadata.Voice})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cache: %w", err)
+	}
+
+	slices.SortFunc(results, func(a, b CachedLine) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return results, nil
+}
+
+var cacheHashPattern = regexp.MustCompile(`^[0-9a-f]{12}$`)
+
+// CachedAudioPath returns where a cached line's audio is, refusing anything
+// that could point outside the cache.
+func CachedAudioPath(baseDir, provider, voice, hash string) (string, error) {
+	if !cacheHashPattern.MatchString(hash) {
+		return "", fmt.Errorf("invalid hash %q", hash)
+	}
+
+	providerDir, voiceDir := sanitizeDirectoryName(provider), sanitizeDirectoryName(voice)
+	for _, dir := range []string{providerDir, voiceDir} {
+		if dir == "" || dir == "." || dir == ".." {
+			return "", fmt.Errorf("invalid provider or voice %q", dir)
+		}
+	}
+	return filepath.Join(baseDir, providerDir, voiceDir, hash+".wav"), nil
+}
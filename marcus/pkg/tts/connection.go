@@ -0,0 +1,544 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"marcus/pkg/config"
+	"marcus/pkg/metrics"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/caffeinatedtoad/dca"
+	"github.com/disgoorg/disgo/voice"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// ErrShuttingDown is returned for clips that can't play because Marcus is
+// shutting down.
+var ErrShuttingDown = errors.New("marcus is shutting down")
+
+// silenceFrame is sent a few times after each clip so Discord doesn't
+// interpolate the end of the audio.
+var silenceFrame = []byte{0xF8, 0xFF, 0xFE}
+
+// PlayRequest is a single clip waiting to be played in a guild.
+type PlayRequest struct {
+	GuildID     snowflake.ID
+	ChannelID   snowflake.ID
+	RequesterID snowflake.ID
+	Audio       []byte
+	Options     *dca.EncodeOptions
+	QueuedAt    time.Time
+
+	done chan error
+}
+
+// OpusFrames is a clip encoded for Discord, read one frame at a time until
+// io.EOF.
+type OpusFrames interface {
+	OpusFrame() ([]byte, error)
+	Cleanup()
+}
+
+// Encoder encodes audio into opus frames.
+type Encoder func(audio []byte, opts *dca.EncodeOptions) (OpusFrames, error)
+
+// EncodeDCA encodes audio with dca, which runs ffmpeg.
+func EncodeDCA(audio []byte, opts *dca.EncodeOptions) (OpusFrames, error) {
+	return dca.EncodeMem(bytes.NewReader(audio), opts)
+}
+
+// ConnectionManager keeps one voice connection per guild alive between clips,
+// playing queued clips in order. Connections are closed once they have been
+// idle for the configured voice.idle_timeout, or when Leave is called because
+// the channel emptied.
+type ConnectionManager struct {
+	VoiceManager voice.Manager
+	Config       *config.Store
+	Logger       *slog.Logger
+	// Encode is EncodeDCA unless replaced, such as by tests without ffmpeg.
+	Encode Encoder
+
+	mu      sync.Mutex
+	players map[snowflake.ID]*guildPlayer
+	closing bool
+
+	// draining is closed when shutdown starts, and abort when its deadline
+	// passes and the clips still playing are cut off.
+	draining chan struct{}
+	abort    chan struct{}
+}
+
+func NewConnectionManager(logger *slog.Logger, manager voice.Manager, cfg *config.Store) *ConnectionManager {
+	return &ConnectionManager{
+		VoiceManager: manager,
+		Config:       cfg,
+		Logger:       logger,
+		Encode:       EncodeDCA,
+		players:      map[snowflake.ID]*guildPlayer{},
+		draining:     make(chan struct{}),
+		abort:        make(chan struct{}),
+	}
+}
+
+// Play queues audio for the guild and blocks until it has been played.
+func (m *ConnectionManager) Play(req *PlayRequest) error {
+	p := m.player(req.GuildID)
+	if p == nil {
+		return ErrShuttingDown
+	}
+
+	req.done = make(chan error, 1)
+	req.QueuedAt = time.Now()
+	p.enqueue(req)
+	return <-req.done
+}
+
+// Shutdown stops new clips from being queued and waits for the queued ones
+// to play, disconnecting from each voice channel once its guild is done. When
+// ctx ends first, playback is cut off and the remaining clips are dropped.
+func (m *ConnectionManager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closing {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closing = true
+	players := slices.Collect(maps.Values(m.players))
+	m.mu.Unlock()
+
+	close(m.draining)
+
+	var err error
+	for _, p := range players {
+		select {
+		case <-p.stopped:
+			continue
+		case <-ctx.Done():
+		}
+
+		if err == nil {
+			err = fmt.Errorf("clips were still playing: %w", ctx.Err())
+			m.Logger.Warn("shutdown deadline passed, cutting off playback")
+			close(m.abort)
+		}
+		<-p.stopped
+	}
+	return err
+}
+
+// ChannelID returns the voice channel the bot is connected to in the guild, if any.
+func (m *ConnectionManager) ChannelID(guildID snowflake.ID) *snowflake.ID {
+	m.mu.Lock()
+	p, ok := m.players[guildID]
+	m.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		return nil
+	}
+	return new(p.channelID)
+}
+
+// Channels returns the voice channel the bot is connected to in each guild.
+func (m *ConnectionManager) Channels() map[snowflake.ID]snowflake.ID {
+	m.mu.Lock()
+	players := slices.Collect(maps.Values(m.players))
+	m.mu.Unlock()
+
+	channels := map[snowflake.ID]snowflake.ID{}
+	for _, p := range players {
+		p.mu.Lock()
+		if p.conn != nil {
+			channels[p.guildID] = p.channelID
+		}
+		p.mu.Unlock()
+	}
+	return channels
+}
+
+// QueuedClip describes a clip that is playing or waiting to.
+type QueuedClip struct {
+	ChannelID   snowflake.ID `json:"channel_id"`
+	RequesterID snowflake.ID `json:"requester_id"`
+	QueuedAt    time.Time    `json:"queued_at"`
+}
+
+// QueueStatus is what a guild's player is doing.
+type QueueStatus struct {
+	// ChannelID is the voice channel the bot is connected to, if any.
+	ChannelID *snowflake.ID `json:"channel_id"`
+	Playing   *QueuedClip   `json:"playing"`
+	Queued    []QueuedClip  `json:"queued"`
+}
+
+// Queue returns the clip playing in the guild and the ones waiting after it.
+func (m *ConnectionManager) Queue(guildID snowflake.ID) QueueStatus {
+	status := QueueStatus{Queued: []QueuedClip{}}
+
+	m.mu.Lock()
+	p, ok := m.players[guildID]
+	m.mu.Unlock()
+	if !ok {
+		return status
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn != nil {
+		status.ChannelID = new(p.channelID)
+	}
+	if p.playing != nil {
+		status.Playing = new(p.playing.clip())
+	}
+	for _, req := range p.queue {
+		status.Queued = append(status.Queued, req.clip())
+	}
+	return status
+}
+
+func (r *PlayRequest) clip() QueuedClip {
+	return QueuedClip{ChannelID: r.ChannelID, RequesterID: r.RequesterID, QueuedAt: r.QueuedAt}
+}
+
+// QueueDepth returns how many clips are waiting in the guild, not counting the one playing.
+func (m *ConnectionManager) QueueDepth(guildID snowflake.ID) int {
+	m.mu.Lock()
+	p, ok := m.players[guildID]
+	m.mu.Unlock()
+	if !ok {
+		return 0
+	}
+
+	return p.queued()
+}
+
+// Leave disconnects from the guild once the current clip finishes, unless
+// more clips are queued.
+func (m *ConnectionManager) Leave(guildID snowflake.ID) {
+	m.mu.Lock()
+	p, ok := m.players[guildID]
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	select {
+	case p.leave <- struct{}{}:
+	default:
+	}
+}
+
+func (m *ConnectionManager) idleTimeout() time.Duration {
+	return m.Config.Get().Voice.IdleTimeout.Duration()
+}
+
+// player returns the guild's player, or nil when shutting down.
+func (m *ConnectionManager) player(guildID snowflake.ID) *guildPlayer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closing {
+		return nil
+	}
+
+	p, ok := m.players[guildID]
+	if !ok {
+		p = &guildPlayer{
+			manager: m,
+			guildID: guildID,
+			wake:    make(chan struct{}, 1),
+			leave:   make(chan struct{}, 1),
+			stopped: make(chan struct{}),
+			logger:  m.Logger.With("guildID", guildID),
+		}
+		m.players[guildID] = p
+		go p.run()
+	}
+	return p
+}
+
+// guildPlayer owns the voice connection for one guild. Only its run goroutine
+// opens, closes or writes to the connection.
+type guildPlayer struct {
+	manager *ConnectionManager
+	guildID snowflake.ID
+	logger  *slog.Logger
+
+	mu        sync.Mutex
+	queue     []*PlayRequest
+	conn      voice.Conn
+	channelID snowflake.ID
+	stopRead  context.CancelFunc
+	playing   *PlayRequest
+	// closed is set once run has returned, so nothing more will be played.
+	closed bool
+
+	wake    chan struct{}
+	leave   chan struct{}
+	stopped chan struct{}
+}
+
+func (p *guildPlayer) enqueue(req *PlayRequest) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		req.done <- ErrShuttingDown
+		return
+	}
+	p.queue = append(p.queue, req)
+	p.mu.Unlock()
+	metrics.VoiceQueueDepth.Inc()
+
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (p *guildPlayer) queued() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue)
+}
+
+// next takes the next clip off the queue and marks it as playing.
+func (p *guildPlayer) next() *PlayRequest {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.playing = nil
+	if len(p.queue) == 0 {
+		return nil
+	}
+	req := p.queue[0]
+	p.queue = p.queue[1:]
+	p.playing = req
+	metrics.VoiceQueueDepth.Dec()
+	return req
+}
+
+func (p *guildPlayer) run() {
+	idle := time.NewTimer(p.manager.idleTimeout())
+	defer idle.Stop()
+	defer close(p.stopped)
+
+	for {
+		select {
+		case <-p.manager.draining:
+			p.drain()
+			return
+		case <-p.wake:
+		case <-p.leave:
+			if p.queued() > 0 {
+				continue
+			}
+			p.logger.Info("leaving voice channel, nobody is listening")
+			p.disconnect()
+			continue
+		case <-idle.C:
+			if p.connected() {
+				p.logger.Info("leaving voice channel after being idle", "idleTimeout", p.manager.idleTimeout())
+				p.disconnect()
+			}
+			continue
+		}
+
+		for req := p.next(); req != nil; req = p.next() {
+			req.done <- p.play(req)
+		}
+		idle.Reset(p.manager.idleTimeout())
+	}
+}
+
+// drain plays what's left in the queue for shutdown, then disconnects and
+// turns away anything queued afterwards.
+func (p *guildPlayer) drain() {
+	for req := p.next(); req != nil; req = p.next() {
+		req.done <- p.play(req)
+	}
+	p.disconnect()
+
+	p.mu.Lock()
+	p.closed = true
+	left := p.queue
+	p.queue = nil
+	p.mu.Unlock()
+
+	for _, req := range left {
+		metrics.VoiceQueueDepth.Dec()
+		req.done <- ErrShuttingDown
+	}
+}
+
+func (p *guildPlayer) connected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conn != nil
+}
+
+// connect makes sure we are connected to channelID, moving if we're
+// currently in a different channel.
+func (p *guildPlayer) connect(channelID snowflake.ID) (voice.Conn, error) {
+	p.mu.Lock()
+	conn, current := p.conn, p.channelID
+	p.mu.Unlock()
+
+	// the voice manager drops connections that were closed underneath us,
+	// such as when the bot is kicked from the channel.
+	if conn != nil && p.manager.VoiceManager.GetConn(p.guildID) != conn {
+		p.logger.Info("voice connection was closed elsewhere, reconnecting")
+		p.disconnect()
+		conn = nil
+	}
+
+	if conn != nil && current == channelID {
+		return conn, nil
+	}
+
+	if conn != nil {
+		p.logger.Info("moving to a different voice channel", "from", current, "to", channelID)
+		p.disconnect()
+	}
+
+	p.logger.Info("joining voice channel", "channelID", channelID)
+	conn = p.manager.VoiceManager.CreateConn(p.guildID)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := conn.Open(ctx, channelID, false, true); err != nil {
+		metrics.VoiceConnectFailures.Inc()
+		conn.Close(ctx)
+		return nil, fmt.Errorf("failed to join voice channel: %v", err)
+	}
+
+	if err := conn.SetSpeaking(ctx, voice.SpeakingFlagMicrophone); err != nil {
+		metrics.VoiceConnectFailures.Inc()
+		conn.Close(ctx)
+		return nil, fmt.Errorf("failed to start speaking: %v", err)
+	}
+
+	readCtx, stopRead := context.WithCancel(context.Background())
+
+	// drop any incoming audio, this is expected by the discord API.
+	// we auto deafen, so we may not even get anything, but it can't hurt
+	// to do what's expected here.
+	go func() {
+		for {
+			select {
+			case <-readCtx.Done():
+				return
+			default:
+				// drop any errors,
+				if _, err := conn.UDP().ReadPacket(); err != nil {
+					return
+				}
+				time.Sleep(time.Millisecond * 20)
+			}
+		}
+	}()
+
+	p.mu.Lock()
+	p.conn, p.channelID, p.stopRead = conn, channelID, stopRead
+	p.mu.Unlock()
+
+	return conn, nil
+}
+
+func (p *guildPlayer) disconnect() {
+	p.mu.Lock()
+	conn, stopRead := p.conn, p.stopRead
+	p.conn, p.stopRead = nil, nil
+	p.mu.Unlock()
+
+	if conn == nil {
+		return
+	}
+
+	stopRead()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn.Close(ctx)
+	p.logger.Info("disconnected from voice channel")
+}
+
+func (p *guildPlayer) play(req *PlayRequest) error {
+	select {
+	case <-p.manager.abort:
+		return ErrShuttingDown
+	default:
+	}
+
+	opts := req.Options
+	if opts == nil {
+		opts = dca.StdEncodeOptions
+	}
+
+	encode := p.manager.Encode
+	if encode == nil {
+		encode = EncodeDCA
+	}
+	encodeSession, err := encode(req.Audio, opts)
+	if err != nil {
+		return fmt.Errorf("failed to create encoding session: %v", err)
+	}
+	defer encodeSession.Cleanup()
+
+	conn, err := p.connect(req.ChannelID)
+	if err != nil {
+		return err
+	}
+
+	p.logger.Info("Starting to play audio", "channelID", req.ChannelID, "requester", req.RequesterID)
+	var playErr error
+	for {
+		select {
+		case <-p.manager.abort:
+			p.logger.Warn("cutting off audio to shut down")
+			return ErrShuttingDown
+		default:
+		}
+
+		frame, err := encodeSession.OpusFrame()
+		if err != nil {
+			if err == io.EOF {
+				p.logger.Info("finished playing audio")
+				break
+			}
+			p.logger.Error("failed to read opus frame", "err", err)
+			playErr = fmt.Errorf("failed to read opus frame: %w", err)
+			break
+		}
+
+		_, err = conn.UDP().Write(frame)
+		if err != nil {
+			metrics.VoiceFramesTotal.WithLabelValues("dropped").Inc()
+			p.logger.Error("failed to write packet", "err", err)
+			// the connection is probably broken, start fresh for the next clip
+			p.disconnect()
+			return fmt.Errorf("failed to send audio to voice: %w", err)
+		}
+		metrics.VoiceFramesTotal.WithLabelValues("written").Inc()
+
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if p.connected() {
+		for range 5 {
+			_, _ = conn.UDP().Write(silenceFrame)
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	return playErr
+}
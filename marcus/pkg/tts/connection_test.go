@@ -0,0 +1,228 @@
+package tts_test
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"marcus/pkg/config"
+	"marcus/pkg/fake"
+	"marcus/pkg/settings"
+	"marcus/pkg/tts"
+	"marcus/pkg/util"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const (
+	guildID   snowflake.ID = 10
+	textID    snowflake.ID = 20
+	userID    snowflake.ID = 30
+	generalID snowflake.ID = 40
+	gamingID  snowflake.ID = 41
+)
+
+// newPlayer is a connection manager playing into a fake voice manager.
+func newPlayer(t *testing.T) (*tts.ConnectionManager, *fake.VoiceManager) {
+	voice := fake.NewVoiceManager()
+	connections := tts.NewConnectionManager(slog.New(slog.DiscardHandler), voice, config.NewStaticStore(config.Default()))
+	connections.Encode = fake.Encode
+	t.Cleanup(func() { _ = connections.Shutdown(context.Background()) })
+	return connections, voice
+}
+
+func TestConnectionManagerPlay(t *testing.T) {
+	connections, voice := newPlayer(t)
+
+	requests := []struct {
+		channelID snowflake.ID
+		audio     string
+	}{
+		{generalID, "the first clip, long enough for a few frames"},
+		{generalID, "second"},
+		{gamingID, "somewhere else"},
+	}
+	for _, r := range requests {
+		err := connections.Play(&tts.PlayRequest{GuildID: guildID, ChannelID: r.channelID, RequesterID: userID, Audio: []byte(r.audio)})
+		if err != nil {
+			t.Fatalf("failed to play %q: %v", r.audio, err)
+		}
+	}
+
+	clips := voice.Clips()
+	if len(clips) != len(requests) {
+		t.Fatalf("played %d clips, want %d", len(clips), len(requests))
+	}
+	for i, r := range requests {
+		if clips[i].ChannelID != r.channelID || string(clips[i].Audio()) != r.audio {
+			t.Errorf("clip %d = %q in %s, want %q in %s", i, clips[i].Audio(), clips[i].ChannelID, r.audio, r.channelID)
+		}
+	}
+	if len(clips[0].Frames) != 3 {
+		t.Errorf("first clip has %d frames, want 3", len(clips[0].Frames))
+	}
+
+	// the connection is kept between clips in the same channel
+	if joins := voice.Joins(); !slices.Equal(joins, []snowflake.ID{generalID, gamingID}) {
+		t.Errorf("joined %v, want general then gaming", joins)
+	}
+	if channelID := connections.ChannelID(guildID); channelID == nil || *channelID != gamingID {
+		t.Errorf("connected to %v, want gaming", channelID)
+	}
+}
+
+func TestConnectionManagerJoinFails(t *testing.T) {
+	connections, voice := newPlayer(t)
+	voice.OpenErr = errors.New("no permission to connect")
+
+	err := connections.Play(&tts.PlayRequest{GuildID: guildID, ChannelID: generalID, Audio: []byte("hi")})
+	if err == nil {
+		t.Fatal("playing should fail when the channel can't be joined")
+	}
+	if clips := voice.Clips(); len(clips) != 0 {
+		t.Errorf("played %d clips, want none", len(clips))
+	}
+}
+
+func TestConnectionManagerWriteFails(t *testing.T) {
+	connections, voice := newPlayer(t)
+	writeErr := errors.New("connection reset")
+	voice.WriteErr = writeErr
+
+	err := connections.Play(&tts.PlayRequest{GuildID: guildID, ChannelID: generalID, Audio: []byte("hi")})
+	if !errors.Is(err, writeErr) {
+		t.Fatalf("err = %v, want the write error", err)
+	}
+	if channelID := connections.ChannelID(guildID); channelID != nil {
+		t.Errorf("still connected to %s after the connection broke", channelID)
+	}
+
+	// the next clip starts a fresh connection
+	voice.WriteErr = nil
+	if err := connections.Play(&tts.PlayRequest{GuildID: guildID, ChannelID: generalID, Audio: []byte("again")}); err != nil {
+		t.Fatalf("failed to play after reconnecting: %v", err)
+	}
+	if joins := voice.Joins(); !slices.Equal(joins, []snowflake.ID{generalID, generalID}) {
+		t.Errorf("joined %v, want general twice", joins)
+	}
+	if clips := voice.Clips(); len(clips) != 1 || string(clips[0].Audio()) != "again" {
+		t.Errorf("played %d clips, want just the second one", len(clips))
+	}
+}
+
+func TestConnectionManagerShutdown(t *testing.T) {
+	connections, voice := newPlayer(t)
+
+	if err := connections.Play(&tts.PlayRequest{GuildID: guildID, ChannelID: generalID, Audio: []byte("hi")}); err != nil {
+		t.Fatalf("failed to play: %v", err)
+	}
+	if err := connections.Shutdown(context.Background()); err != nil {
+		t.Fatalf("failed to shut down: %v", err)
+	}
+
+	err := connections.Play(&tts.PlayRequest{GuildID: guildID, ChannelID: generalID, Audio: []byte("too late")})
+	if !errors.Is(err, tts.ErrShuttingDown) {
+		t.Errorf("err = %v, want ErrShuttingDown", err)
+	}
+	if len(voice.Clips()) != 1 || len(slices.Collect(voice.Conns())) != 0 {
+		t.Errorf("want one clip played and every connection closed")
+	}
+}
+
+func TestGenerateAndPlay(t *testing.T) {
+	tests := []struct {
+		name         string
+		inVoice      snowflake.ID
+		voiceChannel string
+		target       string
+		content      string
+		wantChannel  snowflake.ID
+		wantMessage  string
+	}{
+		{name: "requester's channel", inVoice: gamingID, content: "hello", wantChannel: gamingID},
+		{name: "named channel", inVoice: gamingID, target: "General", content: "hello", wantChannel: generalID},
+		{name: "server default channel", voiceChannel: "Gaming", content: "hello", wantChannel: gamingID},
+		{name: "not in voice", content: "hello", wantMessage: "you need to be in a voice channel to use this command"},
+		{name: "missing channel", target: "Nowhere", content: "hello", wantMessage: "failed to find voice channel with name 'Nowhere': failed to find channel with the name Nowhere"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.DiscardHandler)
+			dir := t.TempDir()
+			cfg := config.Default()
+			cfg.Storage.AudioDir = dir
+
+			discord := fake.NewDiscord(t)
+			discord.AddTextChannel(guildID, textID, "general")
+			discord.AddVoiceChannel(guildID, generalID, "General")
+			discord.AddVoiceChannel(guildID, gamingID, "Gaming")
+			discord.JoinVoice(guildID, userID, tt.inVoice)
+
+			guildSettings, err := settings.NewStore(dir, logger)
+			if err != nil {
+				t.Fatalf("failed to create settings store: %v", err)
+			}
+			if tt.voiceChannel != "" {
+				if _, err := guildSettings.Set(guildID, settings.KeyVoiceChannel, tt.voiceChannel); err != nil {
+					t.Fatalf("failed to set voice channel: %v", err)
+				}
+			}
+
+			connections, voice := newPlayer(t)
+			generator := &fake.Generator{Voices: []string{"marcus"}}
+			speaker := &tts.TTS{Config: config.NewStaticStore(cfg), Connections: connections, Logger: logger, Generators: []tts.Generator{generator}}
+			speaker.UseGuildSettings(guildSettings.Get(guildID))
+
+			r := util.MessageRequest(discord.MessageCreate(t, guildID, textID, userID, "!marcus "+tt.content))
+			speaker.GenerateAndPlay(r, tt.content, tt.target)
+
+			if tt.wantMessage != "" {
+				messages := discord.WaitForMessages(t, 1)
+				if messages[0].Content != tt.wantMessage || messages[0].ChannelID != textID {
+					t.Errorf("replied %q in %s, want %q in the text channel", messages[0].Content, messages[0].ChannelID, tt.wantMessage)
+				}
+				return
+			}
+
+			clips := voice.WaitForClips(t, 1)
+			if clips[0].ChannelID != tt.wantChannel || string(clips[0].Audio()) != "marcus: "+tt.content {
+				t.Errorf("played %q in %s, want %q in %s", clips[0].Audio(), clips[0].ChannelID, "marcus: "+tt.content, tt.wantChannel)
+			}
+			if spoken := generator.Spoken(); !slices.Equal(spoken, []string{tt.content}) {
+				t.Errorf("spoken = %q, want %q", spoken, tt.content)
+			}
+
+			// the second time it's played from the cache
+			speaker.GenerateAndPlay(r, tt.content, tt.target)
+			voice.WaitForClips(t, 2)
+			if spoken := generator.Spoken(); len(spoken) != 1 {
+				t.Errorf("generated %d times, want the cache to be used", len(spoken))
+			}
+		})
+	}
+}
+
+func TestPlayingIsTracked(t *testing.T) {
+	cfg := config.Default()
+	cfg.Storage.AudioDir = t.TempDir()
+	discord := fake.NewDiscord(t)
+	discord.AddVoiceChannel(guildID, generalID, "General")
+	discord.JoinVoice(guildID, userID, generalID)
+
+	connections, voice := newPlayer(t)
+	var playing sync.WaitGroup
+	speaker := &tts.TTS{Config: config.NewStaticStore(cfg), Connections: connections, Logger: slog.New(slog.DiscardHandler), Generators: []tts.Generator{&fake.Generator{}}, Playing: &playing}
+
+	r := util.MessageRequest(discord.MessageCreate(t, guildID, textID, userID, "!marcus hello"))
+	speaker.GenerateAndPlay(r, "hello", "")
+	speaker.Speak(r, []byte("airhorn"), "")
+	playing.Wait()
+
+	// both clips have finished by the time Wait returns
+	if clips := voice.Clips(); len(clips) != 2 {
+		t.Errorf("played %d clips before Wait returned, want 2", len(clips))
+	}
+}
@@ -0,0 +1,350 @@
+package tts
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// MaxEffects caps how many effects a single request can chain together.
+const MaxEffects = 8
+
+// Effect transforms PCM audio. Effects must not modify their input, and are
+// pure Go so they can be run without ffmpeg or Discord.
+type Effect interface {
+	// Name describes the effect and its parameters, it is part of the cache key
+	// for effected TTS output.
+	Name() string
+	Apply(p *PCM) *PCM
+}
+
+// EffectChain applies its effects in order.
+type EffectChain []Effect
+
+func (c EffectChain) Apply(p *PCM) *PCM {
+	for _, effect := range c {
+		p = effect.Apply(p)
+	}
+	return p
+}
+
+func (c EffectChain) String() string {
+	names := make([]string, 0, len(c))
+	for _, effect := range c {
+		names = append(names, effect.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+// CacheKey returns what effected audio for content is hashed under, so the
+// same line with different effects gets its own file in the fx cache.
+func (c EffectChain) CacheKey(content string) string {
+	if len(c) == 0 {
+		return content
+	}
+	return fmt.Sprintf("%s |fx:%s", content, c)
+}
+
+// EffectPresets are the named chains usable as v!<voice>-fx:<preset> or --fx <preset>.
+var EffectPresets = map[string]EffectChain{
+	"chipmunk":  {Pitch{Semitones: 7}},
+	"deep":      {Pitch{Semitones: -5}},
+	"demon":     {Pitch{Semitones: -8}, Reverb{Mix: 0.4, Decay: 0.8}},
+	"slowmo":    {Speed{Factor: 0.75}},
+	"fast":      {Speed{Factor: 1.5}},
+	"cave":      {Reverb{Mix: 0.5, Decay: 0.85}},
+	"bassboost": {BassBoost{GainDB: 12}},
+	"backwards": {Reverse{}},
+}
+
+// ListEffectPresets returns the preset names in alphabetical order.
+func ListEffectPresets() []string {
+	var names []string
+	for name := range EffectPresets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ParseEffects pulls effect flags out of content and returns the chain along
+// with the content that is left over. Supported flags:
+//
+//	--speed <0.25-4>, --pitch <-12-12 semitones>, --reverb, --bass, --reverse, --fx <preset>
+//
+// Content without any flags is returned untouched.
+func ParseEffects(content string) (EffectChain, string, error) {
+	if !strings.Contains(content, "--") {
+		return nil, content, nil
+	}
+
+	var chain EffectChain
+	var rest []string
+	fields := strings.Fields(content)
+	for i := 0; i < len(fields); i++ {
+		flag, isFlag := strings.CutPrefix(fields[i], "--")
+		if !isFlag || flag == "" {
+			rest = append(rest, fields[i])
+			continue
+		}
+
+		value := func() (string, error) {
+			if i+1 >= len(fields) {
+				return "", fmt.Errorf("--%s needs a value", flag)
+			}
+			i++
+			return fields[i], nil
+		}
+
+		switch strings.ToLower(flag) {
+		case "speed":
+			v, err := value()
+			if err != nil {
+				return nil, "", err
+			}
+			factor, err := strconv.ParseFloat(v, 64)
+			if err != nil || factor < 0.25 || factor > 4 {
+				return nil, "", fmt.Errorf("--speed must be a number between 0.25 and 4")
+			}
+			chain = append(chain, Speed{Factor: factor})
+		case "pitch":
+			v, err := value()
+			if err != nil {
+				return nil, "", err
+			}
+			semitones, err := strconv.ParseFloat(v, 64)
+			if err != nil || semitones < -12 || semitones > 12 {
+				return nil, "", fmt.Errorf("--pitch must be a number of semitones between -12 and 12")
+			}
+			chain = append(chain, Pitch{Semitones: semitones})
+		case "reverb":
+			chain = append(chain, Reverb{Mix: 0.35, Decay: 0.8})
+		case "bass", "bassboost":
+			chain = append(chain, BassBoost{GainDB: 12})
+		case "reverse":
+			chain = append(chain, Reverse{})
+		case "fx":
+			v, err := value()
+			if err != nil {
+				return nil, "", err
+			}
+			preset, ok := EffectPresets[strings.ToLower(v)]
+			if !ok {
+				return nil, "", fmt.Errorf("unknown effect preset '%s', try one of: %s", v, strings.Join(ListEffectPresets(), ", "))
+			}
+			chain = append(chain, preset...)
+		default:
+			// not one of ours, leave it in the content
+			rest = append(rest, fields[i])
+		}
+	}
+
+	if len(chain) > MaxEffects {
+		return nil, "", fmt.Errorf("too many effects, at most %d can be chained", MaxEffects)
+	}
+
+	return chain, strings.Join(rest, " "), nil
+}
+
+// Speed plays the audio faster or slower, changing the pitch with it like a tape.
+type Speed struct {
+	Factor float64
+}
+
+func (s Speed) Name() string {
+	return fmt.Sprintf("speed=%.2f", s.Factor)
+}
+
+func (s Speed) Apply(p *PCM) *PCM {
+	return resample(p, s.Factor)
+}
+
+// Pitch shifts the audio up or down without changing its length.
+type Pitch struct {
+	Semitones float64
+}
+
+func (s Pitch) Name() string {
+	return fmt.Sprintf("pitch=%+.1f", s.Semitones)
+}
+
+func (s Pitch) Apply(p *PCM) *PCM {
+	ratio := math.Pow(2, s.Semitones/12)
+	// stretch the audio by the ratio, then resample it back to the
+	// original length which moves the pitch by the same ratio.
+	return resample(timeStretch(p, ratio), ratio)
+}
+
+// Reverb is a small Schroeder reverb, four comb filters feeding two all-pass filters.
+type Reverb struct {
+	// Mix is how much of the reverberated signal is heard, 0 to 1.
+	Mix float64
+	// Decay is the comb filter feedback, higher values ring for longer.
+	Decay float64
+}
+
+func (r Reverb) Name() string {
+	return fmt.Sprintf("reverb=%.2f/%.2f", r.Mix, r.Decay)
+}
+
+func (r Reverb) Apply(p *PCM) *PCM {
+	combDelays := []float64{29.7, 37.1, 41.1, 43.7}
+	allPassDelays := []float64{5.0, 1.7}
+	const allPassGain = 0.7
+
+	// leave room for the tail to ring out
+	tail := p.SampleRate * 3 / 2
+	frames := p.Frames() + tail
+	out := make([]float32, frames*p.Channels)
+
+	for ch := 0; ch < p.Channels; ch++ {
+		dry := make([]float64, frames)
+		for i := 0; i < p.Frames(); i++ {
+			dry[i] = float64(p.Samples[i*p.Channels+ch])
+		}
+
+		wet := make([]float64, frames)
+		for _, ms := range combDelays {
+			delay := int(ms * float64(p.SampleRate) / 1000)
+			buf := make([]float64, frames)
+			for i := range frames {
+				buf[i] = dry[i]
+				if i >= delay {
+					buf[i] += r.Decay * buf[i-delay]
+				}
+				wet[i] += buf[i] / float64(len(combDelays))
+			}
+		}
+
+		for _, ms := range allPassDelays {
+			delay := int(ms * float64(p.SampleRate) / 1000)
+			in := slices.Clone(wet)
+			for i := range frames {
+				wet[i] = -allPassGain * in[i]
+				if i >= delay {
+					wet[i] += in[i-delay] + allPassGain*wet[i-delay]
+				}
+			}
+		}
+
+		for i := range frames {
+			out[i*p.Channels+ch] = float32(dry[i]*(1-r.Mix) + wet[i]*r.Mix)
+		}
+	}
+
+	return &PCM{Samples: out, SampleRate: p.SampleRate, Channels: p.Channels}
+}
+
+// BassBoost raises everything below ~120Hz with a low-shelf biquad.
+type BassBoost struct {
+	GainDB float64
+}
+
+func (b BassBoost) Name() string {
+	return fmt.Sprintf("bass=%.1f", b.GainDB)
+}
+
+func (b BassBoost) Apply(p *PCM) *PCM {
+	// coefficients from the RBJ audio EQ cookbook, low shelf with a slope of 1
+	const cutoff = 120.0
+	a := math.Pow(10, b.GainDB/40)
+	w0 := 2 * math.Pi * cutoff / float64(p.SampleRate)
+	cos := math.Cos(w0)
+	alpha := math.Sin(w0) / 2 * math.Sqrt2
+	sqrtA := 2 * math.Sqrt(a) * alpha
+
+	b0 := a * ((a + 1) - (a-1)*cos + sqrtA)
+	b1 := 2 * a * ((a - 1) - (a+1)*cos)
+	b2 := a * ((a + 1) - (a-1)*cos - sqrtA)
+	a0 := (a + 1) + (a-1)*cos + sqrtA
+	a1 := -2 * ((a - 1) + (a+1)*cos)
+	a2 := (a + 1) + (a-1)*cos - sqrtA
+
+	out := make([]float32, len(p.Samples))
+	for ch := 0; ch < p.Channels; ch++ {
+		var x1, x2, y1, y2 float64
+		for i := ch; i < len(p.Samples); i += p.Channels {
+			x := float64(p.Samples[i])
+			y := (b0*x + b1*x1 + b2*x2 - a1*y1 - a2*y2) / a0
+			x2, x1 = x1, x
+			y2, y1 = y1, y
+			out[i] = float32(y)
+		}
+	}
+
+	return &PCM{Samples: out, SampleRate: p.SampleRate, Channels: p.Channels}
+}
+
+// Reverse plays the audio backwards.
+type Reverse struct{}
+
+func (r Reverse) Name() string {
+	return "reverse"
+}
+
+func (r Reverse) Apply(p *PCM) *PCM {
+	frames := p.Frames()
+	out := make([]float32, len(p.Samples))
+	for i := range frames {
+		copy(out[i*p.Channels:(i+1)*p.Channels], p.Samples[(frames-1-i)*p.Channels:(frames-i)*p.Channels])
+	}
+	return &PCM{Samples: out, SampleRate: p.SampleRate, Channels: p.Channels}
+}
+
+// resample reads through the audio factor times faster using linear interpolation.
+func resample(p *PCM, factor float64) *PCM {
+	frames := p.Frames()
+	outFrames := int(float64(frames) / factor)
+	out := make([]float32, outFrames*p.Channels)
+
+	for i := range outFrames {
+		pos := float64(i) * factor
+		idx := int(pos)
+		frac := float32(pos - float64(idx))
+		next := min(idx+1, frames-1)
+		for ch := 0; ch < p.Channels; ch++ {
+			a := p.Samples[idx*p.Channels+ch]
+			b := p.Samples[next*p.Channels+ch]
+			out[i*p.Channels+ch] = a + (b-a)*frac
+		}
+	}
+
+	return &PCM{Samples: out, SampleRate: p.SampleRate, Channels: p.Channels}
+}
+
+// timeStretch changes the length of the audio by stretch without changing its
+// pitch, by overlap-adding Hann windowed grains.
+func timeStretch(p *PCM, stretch float64) *PCM {
+	const window = 2048
+	const hop = window / 2
+
+	// a periodic Hann window at 50% overlap sums to one
+	win := make([]float32, window)
+	for i := range win {
+		win[i] = float32(0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/window))
+	}
+
+	frames := p.Frames()
+	outFrames := int(float64(frames) * stretch)
+	out := make([]float32, (outFrames+window)*p.Channels)
+
+	for outPos := -hop; outPos < outFrames; outPos += hop {
+		inPos := int(float64(outPos) / stretch)
+		for i := range window {
+			src, dst := inPos+i, outPos+i
+			if src < 0 || dst < 0 {
+				continue
+			}
+			if src >= frames {
+				break
+			}
+			for ch := 0; ch < p.Channels; ch++ {
+				out[dst*p.Channels+ch] += p.Samples[src*p.Channels+ch] * win[i]
+			}
+		}
+	}
+
+	return &PCM{Samples: out[:outFrames*p.Channels], SampleRate: p.SampleRate, Channels: p.Channels}
+}
@@ -0,0 +1,214 @@
+package tts
+
+import (
+	"log/slog"
+	"marcus/pkg/config"
+	"math"
+	"os/exec"
+	"slices"
+	"testing"
+)
+
+// sine returns ms of a stereo sine wave at freq, at half volume.
+func sine(freq float64, ms int) *PCM {
+	frames := PCMSampleRate * ms / 1000
+	samples := make([]float32, frames*PCMChannels)
+	for i := range frames {
+		s := float32(0.5 * math.Sin(2*math.Pi*freq*float64(i)/PCMSampleRate))
+		samples[i*PCMChannels] = s
+		samples[i*PCMChannels+1] = s
+	}
+	return &PCM{Samples: samples, SampleRate: PCMSampleRate, Channels: PCMChannels}
+}
+
+// peak returns the loudest sample, skipping the first skip frames.
+func peak(p *PCM, skip int) float32 {
+	var loudest float32
+	for _, s := range p.Samples[skip*p.Channels:] {
+		loudest = max(loudest, float32(math.Abs(float64(s))))
+	}
+	return loudest
+}
+
+func TestEffects(t *testing.T) {
+	tests := []struct {
+		name       string
+		effect     Effect
+		input      *PCM
+		wantName   string
+		wantFrames int
+		check      func(t *testing.T, in, out *PCM)
+	}{
+		{name: "speed up", effect: Speed{Factor: 2}, input: sine(440, 1000), wantName: "speed=2.00", wantFrames: 24000},
+		{name: "slow down", effect: Speed{Factor: 0.5}, input: sine(440, 1000), wantName: "speed=0.50", wantFrames: 96000},
+		// pitch keeps the length, give or take the frame rounding loses
+		{name: "pitch up", effect: Pitch{Semitones: 7}, input: sine(440, 1000), wantName: "pitch=+7.0", wantFrames: 47999},
+		{name: "pitch down", effect: Pitch{Semitones: -5}, input: sine(440, 1000), wantName: "pitch=-5.0", wantFrames: 47999},
+		{
+			name: "reverb rings out", effect: Reverb{Mix: 0.35, Decay: 0.8}, input: sine(440, 100), wantName: "reverb=0.35/0.80", wantFrames: 4800 + 72000,
+			check: func(t *testing.T, in, out *PCM) {
+				if tail := peak(out, in.Frames()); tail == 0 {
+					t.Error("reverb left the tail silent")
+				}
+			},
+		},
+		{
+			name: "bass boost raises low notes", effect: BassBoost{GainDB: 12}, input: sine(50, 500), wantName: "bass=12.0", wantFrames: 24000,
+			check: func(t *testing.T, in, out *PCM) {
+				if got := peak(out, 4800); got < 1.5 {
+					t.Errorf("50Hz peaks at %.2f after the boost, want over 1.5", got)
+				}
+			},
+		},
+		{
+			name: "bass boost leaves high notes", effect: BassBoost{GainDB: 12}, input: sine(5000, 500), wantName: "bass=12.0", wantFrames: 24000,
+			check: func(t *testing.T, in, out *PCM) {
+				if got := peak(out, 4800); math.Abs(float64(got)-0.5) > 0.05 {
+					t.Errorf("5kHz peaks at %.2f after the boost, want 0.5", got)
+				}
+			},
+		},
+		{
+			name: "reverse", effect: Reverse{}, input: &PCM{Samples: []float32{0.1, 0.2, 0.3, 0.4, 0.5, 0.6}, SampleRate: PCMSampleRate, Channels: 2}, wantName: "reverse", wantFrames: 3,
+			check: func(t *testing.T, in, out *PCM) {
+				if want := []float32{0.5, 0.6, 0.3, 0.4, 0.1, 0.2}; !slices.Equal(out.Samples, want) {
+					t.Errorf("reversed to %v, want %v", out.Samples, want)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			original := slices.Clone(tt.input.Samples)
+			out := tt.effect.Apply(tt.input)
+
+			if name := tt.effect.Name(); name != tt.wantName {
+				t.Errorf("Name() = %q, want %q", name, tt.wantName)
+			}
+			if out.Frames() != tt.wantFrames || out.SampleRate != tt.input.SampleRate || out.Channels != tt.input.Channels {
+				t.Errorf("got %d frames at %dHz with %d channels, want %d at %dHz with %d", out.Frames(), out.SampleRate, out.Channels, tt.wantFrames, tt.input.SampleRate, tt.input.Channels)
+			}
+			if !slices.Equal(tt.input.Samples, original) {
+				t.Error("the effect modified its input")
+			}
+			if tt.check != nil {
+				tt.check(t, tt.input, out)
+			}
+		})
+	}
+}
+
+func TestEffectChainCacheKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		chain EffectChain
+		want  string
+	}{
+		{name: "no effects", want: "hello"},
+		{name: "one effect", chain: EffectChain{Reverse{}}, want: "hello |fx:reverse"},
+		{name: "in order", chain: EffectChain{Speed{Factor: 1.5}, Pitch{Semitones: -3}}, want: "hello |fx:speed=1.50,pitch=-3.0"},
+		{name: "order matters", chain: EffectChain{Pitch{Semitones: -3}, Speed{Factor: 1.5}}, want: "hello |fx:pitch=-3.0,speed=1.50"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.chain.CacheKey("hello"); got != tt.want {
+				t.Errorf("CacheKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEffects(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		wantEffects string
+		wantContent string
+		wantErr     bool
+	}{
+		{name: "no flags", content: "hello  there", wantContent: "hello  there"},
+		{name: "speed", content: "hi --speed 1.5", wantEffects: "speed=1.50", wantContent: "hi"},
+		{name: "pitch", content: "--pitch -3 hi", wantEffects: "pitch=-3.0", wantContent: "hi"},
+		{name: "switches", content: "hi --reverb --BASS --bassboost --reverse", wantEffects: "reverb=0.35/0.80,bass=12.0,bass=12.0,reverse", wantContent: "hi"},
+		{name: "preset", content: "hi --fx demon", wantEffects: "pitch=-8.0,reverb=0.40/0.80", wantContent: "hi"},
+		{name: "unknown flags stay", content: "hi --loud -- there", wantContent: "hi --loud -- there"},
+		{name: "speed out of range", content: "hi --speed 5", wantErr: true},
+		{name: "pitch isn't a number", content: "hi --pitch up", wantErr: true},
+		{name: "missing value", content: "hi --speed", wantErr: true},
+		{name: "unknown preset", content: "hi --fx robot", wantErr: true},
+		{name: "too many", content: "hi --reverse --reverse --reverse --reverse --reverse --reverse --reverse --reverse --reverse", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, content, err := ParseEffects(tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEffects(%q) err = %v, want error %v", tt.content, err, tt.wantErr)
+			}
+			if chain.String() != tt.wantEffects || content != tt.wantContent {
+				t.Errorf("ParseEffects(%q) = %q, %q, want %q, %q", tt.content, chain, content, tt.wantEffects, tt.wantContent)
+			}
+		})
+	}
+}
+
+// sineGenerator speaks everything as a short sine wave, counting the calls.
+type sineGenerator struct {
+	calls []string
+}
+
+func (g *sineGenerator) Name() string                           { return ElevenLabsGeneratorName }
+func (g *sineGenerator) SupportsVoice(voice string) bool        { return voice == "liam" }
+func (g *sineGenerator) ListSupportedVoices() ([]string, error) { return []string{"liam"}, nil }
+
+func (g *sineGenerator) GenerateTTS(input, _ string) ([]byte, error) {
+	g.calls = append(g.calls, input)
+	return sine(440, 100).WAV(), nil
+}
+
+func TestGenerateEffectsCache(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("effects need ffmpeg to decode audio")
+	}
+
+	logger := slog.New(slog.DiscardHandler)
+	cfg := config.Default()
+	cfg.Storage.AudioDir = t.TempDir()
+	generator := &sineGenerator{}
+	speaker := &TTS{Config: config.NewStaticStore(cfg), Logger: logger, Generators: []Generator{generator}}
+
+	steps := []struct {
+		name      string
+		content   string
+		effects   EffectChain
+		wantCalls []string
+	}{
+		{name: "effects generate the line", content: "hello", effects: EffectChain{Reverse{}}, wantCalls: []string{"hello"}},
+		{name: "the render is cached", content: "hello", effects: EffectChain{Reverse{}}, wantCalls: []string{"hello"}},
+		{name: "other effects reuse the line", content: "hello", effects: EffectChain{Speed{Factor: 2}}, wantCalls: []string{"hello"}},
+		{name: "text can't be served a render", content: "hello |fx:reverse", wantCalls: []string{"hello", "hello |fx:reverse"}},
+	}
+	for _, step := range steps {
+		if _, err := speaker.Generate("liam", step.content, step.effects); err != nil {
+			t.Fatalf("%s: failed to generate: %v", step.name, err)
+		}
+		if !slices.Equal(generator.calls, step.wantCalls) {
+			t.Errorf("%s: generated %q, want %q", step.name, generator.calls, step.wantCalls)
+		}
+	}
+
+	lines, err := ListCache(cfg.Storage.AudioDir, "", logger)
+	if err != nil {
+		t.Fatalf("failed to list cache: %v", err)
+	}
+	var texts []string
+	for _, line := range lines {
+		texts = append(texts, line.Text)
+	}
+	slices.Sort(texts)
+	if want := []string{"hello", "hello |fx:reverse"}; !slices.Equal(texts, want) {
+		t.Errorf("cached lines = %q, want only what was typed %q", texts, want)
+	}
+}
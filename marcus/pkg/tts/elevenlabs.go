@@ -7,19 +7,21 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
-	"os"
 	"strings"
 	"sync"
 	"time"
 )
 
+const ElevenLabsGeneratorName = "elevenlabs"
+
 type ElevenLabsTTSGenerator struct {
 	Logger *slog.Logger
+	APIKey string
 }
 
-func NewElevenLabsTTSGenerator(logger *slog.Logger) (ElevenLabsTTSGenerator, error) {
+func NewElevenLabsTTSGenerator(logger *slog.Logger, apiKey string) (ElevenLabsTTSGenerator, error) {
 	voiceRefresher.Lock()
-	gen := ElevenLabsTTSGenerator{Logger: logger}
+	gen := ElevenLabsTTSGenerator{Logger: logger, APIKey: apiKey}
 	if time.Now().Before(voiceRefresher.LastChecked.Add(time.Minute*10)) && len(voiceRefresher.SupportedElevenLabsVoices) != 0 {
 		voiceRefresher.Unlock()
 		return gen, nil
@@ -35,7 +37,7 @@ func NewElevenLabsTTSGenerator(logger *slog.Logger) (ElevenLabsTTSGenerator, err
 }
 
 func (e ElevenLabsTTSGenerator) Name() string {
-	return "elevenlabs"
+	return ElevenLabsGeneratorName
 }
 
 type SupportedVoicesRefresher struct {
@@ -59,10 +61,10 @@ func (e ElevenLabsTTSGenerator) GenerateTTS(input, voice string) ([]byte, error)
 
 	voiceRefresher.Lock()
 	voiceEntry, ok := voiceRefresher.SupportedElevenLabsVoices[voice]
+	voiceRefresher.Unlock()
 	if !ok {
 		return nil, fmt.Errorf("unsupported voice: %s", voice)
 	}
-	voiceRefresher.Unlock()
 
 	req, err := e.newElevenLabsRequest("https://api.elevenlabs.io/v1/text-to-dialogue", map[string]interface{}{
 		"inputs": []map[string]interface{}{
@@ -165,10 +167,54 @@ func (e ElevenLabsTTSGenerator) ListSupportedVoices() ([]string, error) {
 	return names, nil
 }
 
+// Subscription is the part of the ElevenLabs subscription response we use.
+type Subscription struct {
+	Tier                        string `json:"tier"`
+	CharacterCount              int    `json:"character_count"`
+	CharacterLimit              int    `json:"character_limit"`
+	NextCharacterCountResetUnix int64  `json:"next_character_count_reset_unix"`
+}
+
+func (s Subscription) Remaining() int {
+	return max(s.CharacterLimit-s.CharacterCount, 0)
+}
+
+func (s Subscription) ResetsAt() time.Time {
+	return time.Unix(s.NextCharacterCountResetUnix, 0)
+}
+
+// Subscription returns how many characters the account has used this billing period.
+func (e ElevenLabsTTSGenerator) Subscription() (Subscription, error) {
+	req, err := e.newElevenLabsRequest("https://api.elevenlabs.io/v1/user/subscription", nil, http.MethodGet)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return Subscription{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return Subscription{}, fmt.Errorf("elevenlabs api returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	subscription := Subscription{}
+	if err := json.Unmarshal(body, &subscription); err != nil {
+		return Subscription{}, err
+	}
+	return subscription, nil
+}
+
 func (e ElevenLabsTTSGenerator) newElevenLabsRequest(url string, body map[string]interface{}, method string) (*http.Request, error) {
-	apiKey := os.Getenv("ELEVEN_LABS_API_KEY")
-	if apiKey == "" {
-		err := fmt.Errorf("ELEVEN_LABS_API_KEY environment variable is not set")
+	if e.APIKey == "" {
+		err := fmt.Errorf("no ElevenLabs API key is configured (ELEVEN_LABS_API_KEY)")
 		e.Logger.Error("ElevenLabs API key missing", "err", err)
 		return nil, err
 	}
@@ -195,7 +241,7 @@ func (e ElevenLabsTTSGenerator) newElevenLabsRequest(url string, body map[string
 		}
 	}
 
-	req.Header.Set("xi-api-key", apiKey)
+	req.Header.Set("xi-api-key", e.APIKey)
 
 	return req, nil
 }
@@ -0,0 +1,78 @@
+package tts
+
+// Concat joins clips end to end. A positive gapMs puts that much silence
+// between each clip, while crossfadeMs overlaps the end of one clip with the
+// start of the next and fades between them. Clips are expected to share the
+// sample rate and channel count produced by DecodePCM.
+func Concat(clips []*PCM, gapMs, crossfadeMs int) *PCM {
+	out := &PCM{SampleRate: PCMSampleRate, Channels: PCMChannels}
+	if len(clips) == 0 {
+		return out
+	}
+	out.SampleRate = clips[0].SampleRate
+	out.Channels = clips[0].Channels
+
+	gap := gapMs * out.SampleRate / 1000 * out.Channels
+	for i, clip := range clips {
+		if i == 0 {
+			out.Samples = append(out.Samples, clip.Samples...)
+			continue
+		}
+
+		if crossfadeMs <= 0 {
+			out.Samples = append(out.Samples, make([]float32, gap)...)
+			out.Samples = append(out.Samples, clip.Samples...)
+			continue
+		}
+
+		// can't fade over more audio than either side has
+		fade := min(crossfadeMs*out.SampleRate/1000, out.Frames(), clip.Frames())
+		start := (out.Frames() - fade) * out.Channels
+		for f := range fade {
+			in := float32(f) / float32(fade)
+			for ch := 0; ch < out.Channels; ch++ {
+				idx := f*out.Channels + ch
+				out.Samples[start+idx] = out.Samples[start+idx]*(1-in) + clip.Samples[idx]*in
+			}
+		}
+		out.Samples = append(out.Samples, clip.Samples[fade*out.Channels:]...)
+	}
+
+	return out
+}
+
+// Mix overlays clips on top of each other, all starting at the same time. The
+// result is as long as the longest clip, and is scaled down if the combined
+// peak would clip.
+func Mix(clips ...*PCM) *PCM {
+	out := &PCM{SampleRate: PCMSampleRate, Channels: PCMChannels}
+	if len(clips) == 0 {
+		return out
+	}
+	out.SampleRate = clips[0].SampleRate
+	out.Channels = clips[0].Channels
+
+	length := 0
+	for _, clip := range clips {
+		length = max(length, len(clip.Samples))
+	}
+
+	out.Samples = make([]float32, length)
+	for _, clip := range clips {
+		for i, s := range clip.Samples {
+			out.Samples[i] += s
+		}
+	}
+
+	var peak float32
+	for _, s := range out.Samples {
+		peak = max(peak, s, -s)
+	}
+	if peak > 1 {
+		for i := range out.Samples {
+			out.Samples[i] /= peak
+		}
+	}
+
+	return out
+}
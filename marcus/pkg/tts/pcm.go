@@ -0,0 +1,95 @@
+package tts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os/exec"
+)
+
+const (
+	PCMSampleRate = 48000
+	PCMChannels   = 2
+)
+
+// PCM is interleaved audio with samples in the range [-1, 1]. Effects and
+// mixing work on this format; it is only converted back to 16-bit when
+// written out as a WAV for dca to encode.
+type PCM struct {
+	Samples    []float32
+	SampleRate int
+	Channels   int
+}
+
+// Frames returns the number of samples per channel.
+func (p *PCM) Frames() int {
+	return len(p.Samples) / p.Channels
+}
+
+// DurationMs returns the length of the audio in milliseconds.
+func (p *PCM) DurationMs() int {
+	return p.Frames() * 1000 / p.SampleRate
+}
+
+// DecodePCM converts any audio ffmpeg understands (mp3 from ElevenLabs, wav
+// memes) into 48kHz stereo PCM, matching what dca encodes for Discord.
+func DecodePCM(audio []byte) (*PCM, error) {
+	cmd := exec.Command("ffmpeg",
+		"-hide_banner",
+		"-loglevel", "error",
+		"-i", "pipe:0",
+		"-f", "s16le",
+		"-ar", fmt.Sprint(PCMSampleRate),
+		"-ac", fmt.Sprint(PCMChannels),
+		"pipe:1",
+	)
+	cmd.Stdin = bytes.NewReader(audio)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to decode audio: %v: %s", err, stderr.String())
+	}
+
+	raw := stdout.Bytes()
+	samples := make([]float32, len(raw)/2)
+	for i := range samples {
+		samples[i] = float32(int16(binary.LittleEndian.Uint16(raw[i*2:]))) / math.MaxInt16
+	}
+
+	return &PCM{Samples: samples, SampleRate: PCMSampleRate, Channels: PCMChannels}, nil
+}
+
+// WAV encodes the audio as a 16-bit PCM wav file, clipping anything outside [-1, 1].
+func (p *PCM) WAV() []byte {
+	dataLen := len(p.Samples) * 2
+	buf := bytes.NewBuffer(make([]byte, 0, 44+dataLen))
+
+	buf.WriteString("RIFF")
+	_ = binary.Write(buf, binary.LittleEndian, uint32(36+dataLen))
+	buf.WriteString("WAVE")
+
+	buf.WriteString("fmt ")
+	_ = binary.Write(buf, binary.LittleEndian, uint32(16))
+	_ = binary.Write(buf, binary.LittleEndian, uint16(1)) // PCM
+	_ = binary.Write(buf, binary.LittleEndian, uint16(p.Channels))
+	_ = binary.Write(buf, binary.LittleEndian, uint32(p.SampleRate))
+	_ = binary.Write(buf, binary.LittleEndian, uint32(p.SampleRate*p.Channels*2))
+	_ = binary.Write(buf, binary.LittleEndian, uint16(p.Channels*2))
+	_ = binary.Write(buf, binary.LittleEndian, uint16(16))
+
+	buf.WriteString("data")
+	_ = binary.Write(buf, binary.LittleEndian, uint32(dataLen))
+
+	sample := make([]byte, 2)
+	for _, s := range p.Samples {
+		s = max(-1, min(1, s))
+		binary.LittleEndian.PutUint16(sample, uint16(int16(s*math.MaxInt16)))
+		buf.Write(sample)
+	}
+
+	return buf.Bytes()
+}
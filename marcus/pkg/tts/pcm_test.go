@@ -0,0 +1,54 @@
+package tts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestPCM(t *testing.T) {
+	tests := []struct {
+		name         string
+		pcm          *PCM
+		wantFrames   int
+		wantDuration int
+		wantSamples  []int16
+	}{
+		{name: "empty", pcm: &PCM{SampleRate: PCMSampleRate, Channels: PCMChannels}, wantSamples: []int16{}},
+		{name: "stereo", pcm: &PCM{Samples: []float32{0, 1, -1, 0.5}, SampleRate: PCMSampleRate, Channels: 2}, wantFrames: 2, wantSamples: []int16{0, 32767, -32767, 16383}},
+		{name: "clips", pcm: &PCM{Samples: []float32{2, -2}, SampleRate: PCMSampleRate, Channels: 1}, wantFrames: 2, wantSamples: []int16{32767, -32767}},
+		{name: "a second", pcm: sine(440, 1000), wantFrames: 48000, wantDuration: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if frames := tt.pcm.Frames(); frames != tt.wantFrames {
+				t.Errorf("Frames() = %d, want %d", frames, tt.wantFrames)
+			}
+			if duration := tt.pcm.DurationMs(); duration != tt.wantDuration {
+				t.Errorf("DurationMs() = %d, want %d", duration, tt.wantDuration)
+			}
+
+			wav := tt.pcm.WAV()
+			if len(wav) != 44+len(tt.pcm.Samples)*2 || !bytes.HasPrefix(wav, []byte("RIFF")) || string(wav[8:16]) != "WAVEfmt " || string(wav[36:40]) != "data" {
+				t.Fatalf("WAV() wrote a bad header: % x", wav[:min(len(wav), 44)])
+			}
+			if channels, rate := binary.LittleEndian.Uint16(wav[22:]), binary.LittleEndian.Uint32(wav[24:]); int(channels) != tt.pcm.Channels || int(rate) != tt.pcm.SampleRate {
+				t.Errorf("WAV() is %d channels at %dHz, want %d at %dHz", channels, rate, tt.pcm.Channels, tt.pcm.SampleRate)
+			}
+			if tt.wantSamples == nil {
+				return
+			}
+			samples := make([]int16, len(tt.pcm.Samples))
+			if err := binary.Read(bytes.NewReader(wav[44:]), binary.LittleEndian, samples); err != nil {
+				t.Fatal(err)
+			}
+			for i := range samples {
+				if samples[i] != tt.wantSamples[i] {
+					t.Errorf("WAV() samples = %v, want %v", samples, tt.wantSamples)
+					break
+				}
+			}
+		})
+	}
+}
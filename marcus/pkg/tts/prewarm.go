@@ -0,0 +1,264 @@
+package tts
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"marcus/pkg/config"
+	"marcus/pkg/metrics"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Phrase is a line of text to prewarm in a voice.
+type Phrase struct {
+	Voice string
+	Text  string
+}
+
+// PrewarmResult is how a prewarm went.
+type PrewarmResult struct {
+	Generated int
+	// Skipped were already cached.
+	Skipped int
+	// OverQuota weren't generated because the run's ElevenLabs characters ran out.
+	OverQuota int
+	Failed    int
+	// Characters is how many characters were generated.
+	Characters int
+}
+
+// PrewarmOptions limit a prewarm.
+type PrewarmOptions struct {
+	// Concurrency is how many phrases are generated at once, at least 1.
+	Concurrency int
+	// MaxCharacters caps the ElevenLabs characters generated, 0 for no limit.
+	MaxCharacters int
+}
+
+// ReadPhrases reads a phrase list, one phrase per line, ignoring blank lines
+// and # comments. A [voice] line starts that voice's phrases, the ones before
+// it are in voice.
+func ReadPhrases(r io.Reader, voice string) ([]Phrase, error) {
+	var phrases []Phrase
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			voice = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		phrases = append(phrases, Phrase{Voice: voice, Text: line})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read phrases: %w", err)
+	}
+	return phrases, nil
+}
+
+// ConfigPhrases returns the phrases in the prewarm config's file and list.
+func ConfigPhrases(cfg config.PrewarmConfig) ([]Phrase, error) {
+	var phrases []Phrase
+	if cfg.File != "" {
+		f, err := os.Open(cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open phrase list: %w", err)
+		}
+		defer f.Close()
+		if phrases, err = ReadPhrases(f, DefaultVoice); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, voice := range slices.Sorted(maps.Keys(cfg.Phrases)) {
+		for _, text := range cfg.Phrases[voice] {
+			if text = strings.TrimSpace(text); text != "" {
+				phrases = append(phrases, Phrase{Voice: voice, Text: text})
+			}
+		}
+	}
+	return phrases, nil
+}
+
+// Prewarm generates the phrases that aren't cached yet, through the same
+// path as any other request, so they play straight away when asked for. It
+// stops early when ctx is cancelled.
+func (t *TTS) Prewarm(ctx context.Context, phrases []Phrase, opts PrewarmOptions) PrewarmResult {
+	var (
+		mu     sync.Mutex
+		result PrewarmResult
+		// charged is the ElevenLabs characters generated, the only ones
+		// MaxCharacters limits
+		charged int
+		seen    = map[Phrase]bool{}
+		queue   = make(chan Phrase)
+		wg      sync.WaitGroup
+	)
+	count := func(field *int, outcome string) {
+		mu.Lock()
+		*field++
+		mu.Unlock()
+		metrics.PrewarmPhrases.WithLabelValues(outcome).Inc()
+	}
+
+	for range max(opts.Concurrency, 1) {
+		wg.Go(func() {
+			for phrase := range queue {
+				if t.isCached(phrase.Voice, phrase.Text) {
+					t.Logger.Debug("prewarm phrase already cached", "voice", phrase.Voice, "phrase", phrase.Text)
+					count(&result.Skipped, "skipped")
+					continue
+				}
+
+				// ElevenLabs characters are reserved before generating so
+				// workers can't overspend between them, other voices are free
+				characters := utf8.RuneCountInString(phrase.Text)
+				cost := 0
+				if generator, err := t.GetGeneratorForVoice(phrase.Voice); err == nil && generator.Name() == ElevenLabsGeneratorName {
+					cost = characters
+				}
+				mu.Lock()
+				overQuota := cost > 0 && opts.MaxCharacters > 0 && charged+cost > opts.MaxCharacters
+				if !overQuota {
+					result.Characters += characters
+					charged += cost
+				}
+				mu.Unlock()
+				if overQuota {
+					count(&result.OverQuota, "over_quota")
+					continue
+				}
+
+				if _, err := t.Generate(phrase.Voice, phrase.Text, nil); err != nil {
+					t.Logger.Warn("failed to prewarm phrase", "voice", phrase.Voice, "phrase", phrase.Text, "err", err)
+					mu.Lock()
+					result.Characters -= characters
+					charged -= cost
+					mu.Unlock()
+					count(&result.Failed, "failed")
+					continue
+				}
+				t.Logger.Info("prewarmed phrase", "voice", phrase.Voice, "phrase", phrase.Text)
+				count(&result.Generated, "generated")
+			}
+		})
+	}
+
+feed:
+	for _, phrase := range phrases {
+		phrase.Voice = strings.ToLower(strings.TrimSpace(phrase.Voice))
+		if seen[phrase] {
+			continue
+		}
+		seen[phrase] = true
+		select {
+		case queue <- phrase:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return result
+}
+
+// isCached reports whether content is cached in voice, without generating it.
+func (t *TTS) isCached(voice, content string) bool {
+	generator, err := t.GetGeneratorForVoice(voice)
+	if err != nil {
+		return fileIsCached(getFileName(t.audioDir(), content, voice))
+	}
+	provider := getProviderFromGeneratorName(generator.Name())
+	return fileIsCached(getFileNameWithFallback(t.audioDir(), provider, voice, content, t.Logger))
+}
+
+// Prewarmer runs the configured prewarm at startup and on a schedule.
+type Prewarmer struct {
+	Config *config.Store
+	Logger *slog.Logger
+
+	// running stops a run starting while the last one is still going
+	running sync.Mutex
+}
+
+// Run prewarms the configured phrases once, generating no more than
+// prewarm.max_characters or what's left on the ElevenLabs account.
+func (p *Prewarmer) Run(ctx context.Context) (PrewarmResult, error) {
+	if !p.running.TryLock() {
+		return PrewarmResult{}, errors.New("a prewarm is already running")
+	}
+	defer p.running.Unlock()
+
+	cfg := p.Config.Get()
+	phrases, err := ConfigPhrases(cfg.Prewarm)
+	if err != nil || len(phrases) == 0 {
+		return PrewarmResult{}, err
+	}
+	return p.Prewarm(ctx, phrases, PrewarmOptions{Concurrency: cfg.Prewarm.Concurrency, MaxCharacters: cfg.Prewarm.MaxCharacters})
+}
+
+// Prewarm generates the phrases that aren't cached, also stopping at what's
+// left on the ElevenLabs account.
+func (p *Prewarmer) Prewarm(ctx context.Context, phrases []Phrase, opts PrewarmOptions) (PrewarmResult, error) {
+	if cfg := p.Config.Get(); cfg.ElevenLabs.APIKey != "" {
+		subscription, err := ElevenLabsTTSGenerator{Logger: p.Logger, APIKey: cfg.ElevenLabs.APIKey}.Subscription()
+		switch {
+		case err != nil:
+			p.Logger.Warn("failed to get the ElevenLabs quota, only max_characters limits the prewarm", "err", err)
+		case subscription.Remaining() == 0:
+			return PrewarmResult{}, fmt.Errorf("the ElevenLabs account has no characters left until %s", subscription.ResetsAt().Format(time.DateTime))
+		case opts.MaxCharacters == 0 || subscription.Remaining() < opts.MaxCharacters:
+			opts.MaxCharacters = subscription.Remaining()
+		}
+	}
+
+	t, err := NewTTS(p.Logger, p.Config, nil)
+	if err != nil {
+		return PrewarmResult{}, err
+	}
+	p.Logger.Info("prewarming", "phrases", len(phrases), "concurrency", opts.Concurrency, "max_characters", opts.MaxCharacters)
+	result := t.Prewarm(ctx, phrases, opts)
+	p.Logger.Info("prewarm finished", "generated", result.Generated, "skipped", result.Skipped, "over_quota", result.OverQuota, "failed", result.Failed, "characters", result.Characters)
+	return result, nil
+}
+
+// Start prewarms in the background at startup, if prewarm.on_startup is set,
+// then every prewarm.interval until ctx is cancelled. Config reloads are
+// picked up between runs.
+func (p *Prewarmer) Start(ctx context.Context) {
+	go func() {
+		if p.Config.Get().Prewarm.OnStartup {
+			p.runLogged(ctx)
+		}
+		for {
+			// with no interval, keep checking in case a reload sets one
+			wait := cmp.Or(p.Config.Get().Prewarm.Interval.Duration(), time.Minute)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			if p.Config.Get().Prewarm.Interval > 0 {
+				p.runLogged(ctx)
+			}
+		}
+	}()
+}
+
+func (p *Prewarmer) runLogged(ctx context.Context) {
+	if _, err := p.Run(ctx); err != nil {
+		p.Logger.Error("failed to prewarm", "err", err)
+	}
+}
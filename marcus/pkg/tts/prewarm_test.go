@@ -0,0 +1,116 @@
+package tts_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"marcus/pkg/config"
+	"marcus/pkg/fake"
+	"marcus/pkg/tts"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadPhrases(t *testing.T) {
+	list := `
+# greetings
+hello there
+
+[Liam]
+good morning
+  [tim]
+see you later
+`
+	phrases, err := tts.ReadPhrases(strings.NewReader(list), "marcus")
+	if err != nil {
+		t.Fatalf("failed to read phrases: %v", err)
+	}
+	want := []tts.Phrase{
+		{Voice: "marcus", Text: "hello there"},
+		{Voice: "Liam", Text: "good morning"},
+		{Voice: "tim", Text: "see you later"},
+	}
+	if !slices.Equal(phrases, want) {
+		t.Errorf("ReadPhrases() = %+v, want %+v", phrases, want)
+	}
+}
+
+func TestPrewarm(t *testing.T) {
+	many := make([]tts.Phrase, 20)
+	for i := range many {
+		many[i] = tts.Phrase{Voice: "liam", Text: fmt.Sprintf("phrase %d", i)}
+	}
+
+	tests := []struct {
+		name    string
+		cached  []string
+		phrases []tts.Phrase
+		opts    tts.PrewarmOptions
+		err     error
+		want    tts.PrewarmResult
+	}{
+		{
+			name:    "skips cached phrases",
+			cached:  []string{"hello"},
+			phrases: []tts.Phrase{{Voice: "liam", Text: "hello"}, {Voice: "liam", Text: "bye"}},
+			want:    tts.PrewarmResult{Generated: 1, Skipped: 1, Characters: 3},
+		},
+		{
+			name:    "duplicates once",
+			phrases: []tts.Phrase{{Voice: "liam", Text: "hello"}, {Voice: " Liam", Text: "hello"}, {Voice: "tim", Text: "hello"}},
+			want:    tts.PrewarmResult{Generated: 2, Characters: 10},
+		},
+		{
+			name:    "stops at the character limit",
+			phrases: []tts.Phrase{{Voice: "liam", Text: "hello"}, {Voice: "liam", Text: "goodbye"}, {Voice: "liam", Text: "hi"}},
+			opts:    tts.PrewarmOptions{MaxCharacters: 8},
+			want:    tts.PrewarmResult{Generated: 2, OverQuota: 1, Characters: 7},
+		},
+		{
+			name:    "free voices don't use the limit",
+			phrases: []tts.Phrase{{Voice: "liam", Text: "hello"}, {Voice: "jessie", Text: "goodbye"}, {Voice: "liam", Text: "hi"}},
+			opts:    tts.PrewarmOptions{MaxCharacters: 5},
+			want:    tts.PrewarmResult{Generated: 2, OverQuota: 1, Characters: 12},
+		},
+		{
+			name:    "failures don't use the limit",
+			phrases: []tts.Phrase{{Voice: "liam", Text: "hello"}, {Voice: "nobody", Text: "hello"}},
+			err:     errors.New("out of credits"),
+			opts:    tts.PrewarmOptions{MaxCharacters: 5},
+			want:    tts.PrewarmResult{Failed: 2},
+		},
+		{
+			name:    "concurrently",
+			phrases: many,
+			opts:    tts.PrewarmOptions{Concurrency: 4},
+			want:    tts.PrewarmResult{Generated: 20, Characters: 170},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.Storage.AudioDir = t.TempDir()
+			generator := &fake.Generator{Provider: tts.ElevenLabsGeneratorName, Voices: []string{"liam", "tim"}}
+			free := &fake.Generator{Voices: []string{"jessie"}}
+			speaker := &tts.TTS{Config: config.NewStaticStore(cfg), Logger: slog.New(slog.DiscardHandler), Generators: []tts.Generator{generator, free}}
+
+			for _, text := range tt.cached {
+				if _, err := speaker.Generate("liam", text, nil); err != nil {
+					t.Fatalf("failed to cache %q: %v", text, err)
+				}
+			}
+			generator.Err = tt.err
+
+			got := speaker.Prewarm(context.Background(), tt.phrases, tt.opts)
+			if got != tt.want {
+				t.Errorf("Prewarm() = %+v, want %+v", got, tt.want)
+			}
+			if generated := len(generator.Spoken()) + len(free.Spoken()) - len(tt.cached); tt.err == nil && generated != tt.want.Generated {
+				t.Errorf("generated %d phrases, want %d", generated, tt.want.Generated)
+			}
+		})
+	}
+}
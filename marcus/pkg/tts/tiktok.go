@@ -7,13 +7,13 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
-	"os"
 	"strings"
 )
 
 type TikTokTTSGenerator struct {
 	HTTPClient *http.Client
 	Logger     *slog.Logger
+	SessionID  string
 }
 
 func (t TikTokTTSGenerator) Name() string {
@@ -27,7 +27,7 @@ func (t TikTokTTSGenerator) GenerateTTS(input, voice string) ([]byte, error) {
 	t.Logger.Info("requesting TTS generation", "text_speaker", voice)
 	req, _ := http.NewRequest(http.MethodPost, "https://api16-normal-useast5.us.tiktokv.com/media/api/text/speech/invoke/", nil)
 	req.Header.Set("User-Agent", "com.zhiliaoapp.musically/2022600030 (Linux; U; Android 7.1.2; es_ES; SM-G988N; Build/NRD90M;tt-ok/3.12.13.1)")
-	req.Header.Set("Cookie", fmt.Sprintf("sessionid=%s", os.Getenv("magic_key")))
+	req.Header.Set("Cookie", fmt.Sprintf("sessionid=%s", t.SessionID))
 
 	q := req.URL.Query()
 	q.Set("aid", "1233")
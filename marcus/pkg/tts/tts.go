@@ -1,24 +1,24 @@
 package tts
 
 import (
-	"bytes"
-	"context"
-	"io"
+	"marcus/pkg/config"
+	"marcus/pkg/metrics"
+	"marcus/pkg/settings"
 	"marcus/pkg/util"
 	"math/rand"
 
-	"github.com/caffeinatedtoad/dca"
-	"github.com/disgoorg/disgo/events"
-	"github.com/disgoorg/disgo/voice"
 	"github.com/disgoorg/snowflake/v2"
 
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -29,10 +29,24 @@ type Opts struct {
 }
 
 type TTS struct {
-	VoiceManager voice.Manager
-	Logger       *slog.Logger
-	Generators   []Generator
-	Voice        string
+	Config      *config.Store
+	Connections *ConnectionManager
+	Logger      *slog.Logger
+	Generators  []Generator
+	Voice       string
+	Volumes     *VolumeStore
+	Effects     EffectChain
+	Settings    settings.Guild
+
+	// CanGenerate, when set, is asked before audio that isn't cached is
+	// generated, so requests can be refused before they cost anything.
+	CanGenerate func(generatorName, content string) error
+	// GenerationFailed, when set, is told about audio CanGenerate allowed
+	// that then failed to generate, so anything it reserved can be given back.
+	GenerationFailed func(generatorName, content string)
+	// Playing, when set, tracks the clips GenerateAndPlay and Speak play in
+	// the background, so shutdown can wait for them.
+	Playing *sync.WaitGroup
 }
 
 type Generator interface {
@@ -48,90 +62,169 @@ const (
 
 var (
 	TooLongMessages = []string{
-		"The requested TTS string was too long :( (must 300 characters or less)",
-		"Your text is so long it made the TTS engine sweat profusely. (must 300 characters or less)",
-		"The TTS engine just committed seppuku rather than process that wall of text. Congratulations. (must 300 characters or less)",
-		"Your massive text dump made the TTS engine physically ill. Hope you're proud of yourself. (must 300 characters or less)",
+		"The requested TTS string was too long :( (must be less than %d characters)",
+		"Your text is so long it made the TTS engine sweat profusely. (must be less than %d characters)",
+		"The TTS engine just committed seppuku rather than process that wall of text. Congratulations. (must be less than %d characters)",
+		"Your massive text dump made the TTS engine physically ill. Hope you're proud of yourself. (must be less than %d characters)",
 	}
+
+	// ErrTooLong is matched by errors for text longer than the guild allows.
+	ErrTooLong = errors.New("text is too long")
+	// ErrGenerationFailed is matched by errors from a generator failing to make audio.
+	ErrGenerationFailed = errors.New("failed to generate TTS")
 )
 
-func NewTTS(logger *slog.Logger, manager voice.Manager) (*TTS, error) {
+// tooLongError is one of the TooLongMessages.
+type tooLongError string
+
+func (e tooLongError) Error() string        { return string(e) }
+func (e tooLongError) Is(target error) bool { return target == ErrTooLong }
+
+func NewTTS(logger *slog.Logger, cfg *config.Store, connections *ConnectionManager) (*TTS, error) {
 	if logger == nil {
 		logger = slog.Default()
 	}
 
 	tts := &TTS{
-		VoiceManager: manager,
-		Logger:       logger,
+		Config:      cfg,
+		Connections: connections,
+		Logger:      logger,
 		Generators: []Generator{
 			// TODO: Use tiktok Api again at some point
 			//TikTokTTSGenerator{
 			//	HTTPClient: http.DefaultClient,
 			//	Logger:     logger,
+			//	SessionID:  cfg.Get().TikTok.SessionID,
 			//},
 			CacheTTSGenerator{
-				Logger: logger,
+				Logger:   logger,
+				AudioDir: cfg.Get().Storage.AudioDir,
 			},
 		},
 		Voice: DefaultVoice,
 	}
+	tts.Generators = append(tts.Generators, newAudioGenerators(logger, cfg)...)
+
+	return tts, nil
+}
+
+// CheckGenerators sets up the generators that make new audio without making a
+// TTS, so GeneratorSetup knows whether they work before the first command.
+func CheckGenerators(logger *slog.Logger, cfg *config.Store) {
+	newAudioGenerators(logger, cfg)
+}
 
-	elevenlabs, err := NewElevenLabsTTSGenerator(logger)
+// newAudioGenerators returns the generators that make new audio and could be
+// set up, recording how each went for GeneratorSetup.
+func newAudioGenerators(logger *slog.Logger, cfg *config.Store) []Generator {
+	var generators []Generator
+	elevenlabs, err := NewElevenLabsTTSGenerator(logger, cfg.Get().ElevenLabs.APIKey)
+	setupResults.record(ElevenLabsGeneratorName, err)
 	if err != nil {
 		logger.Error("failed to initialize elevenlabs TTS generator", "err", err)
 	} else {
-		tts.Generators = append(tts.Generators, elevenlabs)
+		generators = append(generators, elevenlabs)
 	}
+	return generators
+}
 
-	return tts, nil
+// generatorSetup remembers whether the generators that make new audio could
+// be set up the last time they were tried, for health checks.
+type generatorSetup struct {
+	mu      sync.Mutex
+	results map[string]error
+}
+
+var setupResults = &generatorSetup{results: map[string]error{}}
+
+func (g *generatorSetup) record(name string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.results[name] = err
+}
+
+// GeneratorSetup returns the last setup error of each generator that makes new
+// audio, nil for ones that worked. Generators that haven't been tried are missing.
+func GeneratorSetup() map[string]error {
+	setupResults.mu.Lock()
+	defer setupResults.mu.Unlock()
+	return maps.Clone(setupResults.results)
+}
+
+// UseGuildSettings applies a guild's settings, switching to its default voice.
+func (t *TTS) UseGuildSettings(guild settings.Guild) {
+	t.Settings = guild
+	t.Voice = t.DefaultVoice()
+}
+
+// DefaultVoice returns the guild's default voice, falling back to marcus.
+func (t *TTS) DefaultVoice() string {
+	if voice := t.Settings.DefaultVoice(); voice != "" {
+		return voice
+	}
+	return DefaultVoice
+}
+
+// audioDir is the root of the TTS cache.
+func (t *TTS) audioDir() string {
+	return t.Config.Get().Storage.AudioDir
 }
 
 func (t *TTS) CacheAudio(generatorName, provider, voice, content string, data []byte) {
 	hash := generateHash(content)
-	fileName := getCachePath(provider, voice, content)
+	fileName := getCachePath(t.audioDir(), provider, voice, content)
 
 	// don't use the data slice directly,
 	// it's unsafe.
-	var cacheData []byte
+	cacheData := make([]byte, len(data))
 	copy(cacheData, data)
 
-	// Ensure directory exists
-	if err := ensureCacheDirectory(getProviderFromGeneratorName(generatorName), voice); err != nil {
-		t.Logger.Error("failed to create cache directory", "err", err)
+	if err := writeCacheFile(fileName, cacheData); err != nil {
+		t.Logger.Error("failed writing TTS cache file", "file", fileName, "err", err)
 		return
 	}
+	t.Logger.Info("cached TTS file", "file", fileName, "generator", generatorName, "bytes", len(cacheData), "hash", hash)
 
-	// Write audio file
-	err := os.WriteFile(fileName, cacheData, 0644)
-	if err != nil {
-		t.Logger.Error("failed writing ElevenLabs TTS file", "file", fileName, "err", err)
-		return
+	// Update metadata
+	if err := updateMetadata(t.audioDir(), getProviderFromGeneratorName(generatorName), voice, hash, content, int64(len(cacheData)), t.Logger); err != nil {
+		t.Logger.Warn("failed to update metadata", "err", err)
 	}
+}
 
-	t.Logger.Info("downloaded ElevenLabs TTS file", "file", fileName, "bytes", len(cacheData), "hash", hash)
+// writeCacheFile writes to a temp file first so a crash never leaves half an
+// audio file that would be played from the cache.
+func writeCacheFile(fileName string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(fileName), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
 
-	// Update metadata
-	if err := updateMetadata(getProviderFromGeneratorName(getProviderFromGeneratorName(generatorName)), voice, hash, content, int64(len(cacheData)), t.Logger); err != nil {
-		t.Logger.Warn("failed to update metadata", "err", err)
+	tempName := fileName + ".tmp"
+	if err := os.WriteFile(tempName, data, 0644); err != nil {
+		return err
 	}
+	if err := os.Rename(tempName, fileName); err != nil {
+		os.Remove(tempName)
+		return err
+	}
+	return nil
 }
 
 func (t *TTS) InputIsCached(input string) (string, bool) {
 	voice := strings.TrimSpace(t.Voice)
 	if voice == "" {
-		voice = DefaultVoice
+		voice = t.DefaultVoice()
 	}
 
 	// Try to determine provider from the voice
 	generator, err := t.GetGeneratorForVoice(voice)
 	if err != nil {
 		// Fallback to legacy check if no generator found
-		fileName := getFileName(input, voice)
+		fileName := getFileName(t.audioDir(), input, voice)
 		return fileName, fileIsCached(fileName)
 	}
 
 	provider := getProviderFromGeneratorName(generator.Name())
-	fileName := getFileNameWithFallback(provider, voice, input, t.Logger)
+	fileName := getFileNameWithFallback(t.audioDir(), provider, voice, input, t.Logger)
 	return fileName, fileIsCached(fileName)
 }
 
@@ -158,192 +251,289 @@ func (t *TTS) GetGeneratorForVoice(voice string) (Generator, error) {
 	return nil, fmt.Errorf("no generator found for voice: %s", voice)
 }
 
-func (t *TTS) GenerateAndPlay(e *events.MessageCreate, content, targetChannel string) {
-	if len(content) >= 1000 {
-		_, _ = util.SendMessageInChannel(e, e.ChannelID, TooLongMessages[rand.Intn(len(TooLongMessages))])
+// GenerateAndPlay speaks content in the target channel, or where the
+// requester is when it's empty, telling them if it can't.
+func (t *TTS) GenerateAndPlay(r *util.Request, content, targetChannel string) {
+	if err := t.checkLength(content); err != nil {
+		_ = r.Reply(err.Error())
 		return
 	}
 
-	var channelID *snowflake.ID
+	var channelID snowflake.ID
 	var err error
 	if targetChannel != "" {
-		channelID, err = t.getVoiceChannelByName(e, targetChannel)
+		channelID, err = t.voiceChannel(r, targetChannel)
 		if err != nil {
-			_, err = util.SendMessageInChannel(e, e.ChannelID, fmt.Sprintf("failed to find voice channel with name '%s': %v", targetChannel, err))
+			_ = r.Reply(err.Error())
 			t.Logger.Error("voice channel lookup failed", "targetChannel", targetChannel, "err", err)
 			return
 		}
 	}
 
+	audio, err := t.generateInVoice(content)
+	if err != nil {
+		_ = r.Reply(err.Error())
+		return
+	}
+
+	t.speakInBackground(r, audio, channelID)
+}
+
+// Render returns content spoken in t.Voice with the effects applied, as long
+// as it isn't longer than the guild allows.
+func (t *TTS) Render(content string) ([]byte, error) {
+	if err := t.checkLength(content); err != nil {
+		return nil, err
+	}
+	return t.generateInVoice(content)
+}
+
+// PlayText speaks content in the voice channel, returning once it has been
+// played. Unlike GenerateAndPlay it doesn't need a message to reply to.
+func (t *TTS) PlayText(guildID, channelID, requesterID snowflake.ID, content string) error {
+	audio, err := t.Render(content)
+	if err != nil {
+		return err
+	}
+	return t.PlayInChannel(guildID, channelID, requesterID, audio)
+}
+
+// PlayFile plays an audio file in the voice channel with the effects applied,
+// returning once it has been played.
+func (t *TTS) PlayFile(guildID, channelID, requesterID snowflake.ID, file string) error {
+	audio, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read audio file: %v", err)
+	}
+
+	audio, err = t.withEffects(audio)
+	if err != nil {
+		return err
+	}
+	return t.PlayInChannel(guildID, channelID, requesterID, audio)
+}
+
+// checkLength refuses content longer than the guild allows.
+func (t *TTS) checkLength(content string) error {
+	if maxLength := t.Settings.MaxTTSLength(); len(content) >= maxLength {
+		return tooLongError(fmt.Sprintf(TooLongMessages[rand.Intn(len(TooLongMessages))], maxLength))
+	}
+	return nil
+}
+
+// generateInVoice generates content in t.Voice, or the default voice if unset.
+func (t *TTS) generateInVoice(content string) ([]byte, error) {
 	voice := strings.ToLower(strings.TrimSpace(t.Voice))
 	if voice == "" {
-		voice = DefaultVoice
+		voice = t.DefaultVoice()
+	}
+
+	audio, err := t.Generate(voice, content, t.Effects)
+	if err != nil {
+		t.Logger.Error("failed to generate TTS", "voice", voice, "err", err)
+		return nil, err
+	}
+	return audio, nil
+}
+
+// withEffects runs audio through t.Effects, if there are any.
+func (t *TTS) withEffects(audio []byte) ([]byte, error) {
+	if len(t.Effects) == 0 {
+		return audio, nil
+	}
+
+	audio, err := applyEffects(audio, t.Effects)
+	if err != nil {
+		t.Logger.Error("failed to apply effects", "effects", t.Effects.String(), "err", err)
+		return nil, fmt.Errorf("failed to apply effects: %v", err)
 	}
+	return audio, nil
+}
 
+// Generate returns audio for content in the given voice run through effects,
+// reading it from the cache when possible and caching anything it generates.
+func (t *TTS) Generate(voice, content string, effects EffectChain) ([]byte, error) {
 	// Get generator for the voice
 	generator, err := t.GetGeneratorForVoice(voice)
 	if err != nil {
-		_, _ = util.SendMessageInChannel(e, e.ChannelID, fmt.Sprintf("failed to find TTS generator for voice '%s': %v", voice, err))
-		t.Logger.Error("failed to find TTS generator", "voice", voice, "err", err)
-		return
+		return nil, fmt.Errorf("failed to find TTS generator for voice '%s': %v", voice, err)
 	}
 
 	// Determine provider and check cache with fallback
 	provider := getProviderFromGeneratorName(generator.Name())
-	fileName := getFileNameWithFallback(provider, voice, content, t.Logger)
+
+	// effected output is cached separately, so a hit skips both
+	// generation and running the effects again.
+	var fxFile string
+	if len(effects) > 0 {
+		fxFile = getEffectCachePath(t.audioDir(), provider, voice, effects, content)
+		if fileIsCached(fxFile) {
+			metrics.TTSCacheTotal.WithLabelValues(provider, voice, "hit").Inc()
+			t.Logger.Info("using cached effected TTS", "file", fxFile, "voice", voice, "effects", effects.String())
+			audio, err := os.ReadFile(fxFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read cached TTS file: %v", err)
+			}
+			return audio, nil
+		}
+	}
+
+	fileName := getFileNameWithFallback(t.audioDir(), provider, voice, content, t.Logger)
 	var audio []byte
 
 	if !fileIsCached(fileName) {
+		metrics.TTSCacheTotal.WithLabelValues(provider, voice, "miss").Inc()
+		if t.CanGenerate != nil {
+			if err := t.CanGenerate(generator.Name(), content); err != nil {
+				return nil, err
+			}
+		}
+
 		t.Logger.Info("TTS not cached, generating", "file", fileName, "voice", voice, "provider", provider)
+		start := time.Now()
 		audio, err = generator.GenerateTTS(content, voice)
 		if err != nil {
-			_, _ = util.SendMessageInChannel(e, e.ChannelID, fmt.Sprintf("failed to generate TTS: %v\n Type !marcus-cache to see all cached files that can be played at any time.", err))
-			t.Logger.Error("failed to generate TTS", "file", fileName, "err", err)
-			return
+			metrics.TTSGenerationErrors.WithLabelValues(generator.Name()).Inc()
+			if t.GenerationFailed != nil {
+				t.GenerationFailed(generator.Name(), content)
+			}
+			return nil, fmt.Errorf("%w: %v", ErrGenerationFailed, err)
 		}
+		metrics.TTSGenerationDuration.WithLabelValues(generator.Name()).Observe(metrics.Since(start))
 
 		t.CacheAudio(generator.Name(), provider, voice, content, audio)
 
 	} else {
+		metrics.TTSCacheTotal.WithLabelValues(provider, voice, "hit").Inc()
 		t.Logger.Info("using cached TTS", "file", fileName, "voice", voice, "provider", provider)
 		audio, err = os.ReadFile(fileName)
 		if err != nil {
-			_, _ = util.SendMessageInChannel(e, e.ChannelID, fmt.Sprintf("failed to read cached TTS file: %v", err))
-			t.Logger.Error("failed to read cached TTS file", "file", fileName, "err", err)
-			return
+			return nil, fmt.Errorf("failed to read cached TTS file: %v", err)
 		}
 	}
 
-	if channelID != nil {
-		go t.speakInChannel(e, audio, channelID)
-	} else {
-		go t.speakInUserVoiceChannel(e, audio)
+	if fxFile != "" {
+		audio, err = applyEffects(audio, effects)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply effects: %v", err)
+		}
+		if err := writeCacheFile(fxFile, audio); err != nil {
+			t.Logger.Error("failed writing effected TTS cache file", "file", fxFile, "err", err)
+		}
 	}
-}
 
-func (t *TTS) SpeakFile(e *events.MessageCreate, file string, targetChannelName string) {
-	if !fileIsCached(file) {
-		_, _ = util.SendMessageInChannel(e, e.ChannelID, fmt.Sprintf("file '%s' not found in cache", file))
-		return
-	}
+	return audio, nil
+}
 
-	audio, err := os.ReadFile(file)
+// applyEffects runs the audio through the effect chain and returns it as a wav.
+func applyEffects(audio []byte, effects EffectChain) ([]byte, error) {
+	pcm, err := DecodePCM(audio)
 	if err != nil {
-		_, _ = util.SendMessageInChannel(e, e.ChannelID, fmt.Sprintf("failed to read cached TTS file: %v", err))
-		return
+		return nil, err
 	}
+	return effects.Apply(pcm).WAV(), nil
+}
 
-	if targetChannelName == "" {
-		go t.speakInUserVoiceChannel(e, audio)
+func (t *TTS) SpeakFile(r *util.Request, file string, targetChannelName string) {
+	if !fileIsCached(file) {
+		_ = r.Reply(fmt.Sprintf("file '%s' not found in cache", file))
+		return
 	}
 
-	channelID, err := t.getVoiceChannelByName(e, targetChannelName)
+	audio, err := os.ReadFile(file)
 	if err != nil {
-		_, err = util.SendMessageInChannel(e, e.ChannelID, fmt.Sprintf("failed to find voice channel with name '%s': %v", targetChannelName, err))
+		_ = r.Reply(fmt.Sprintf("failed to read cached TTS file: %v", err))
 		return
 	}
 
-	go t.speakInChannel(e, audio, channelID)
+	t.Speak(r, audio, targetChannelName)
 }
 
-func (t *TTS) Speak(e *events.MessageCreate, audio []byte, targetChannelName string) {
-	if targetChannelName == "" {
-		go t.speakInUserVoiceChannel(e, audio)
-	}
-
-	channelID, err := t.getVoiceChannelByName(e, targetChannelName)
+func (t *TTS) Speak(r *util.Request, audio []byte, targetChannelName string) {
+	audio, err := t.withEffects(audio)
 	if err != nil {
-		_, err = util.SendMessageInChannel(e, e.ChannelID, fmt.Sprintf("failed to find voice channel with name '%s': %v", targetChannelName, err))
+		_ = r.Reply(err.Error())
 		return
 	}
 
-	go t.speakInChannel(e, audio, channelID)
-}
-
-func (t *TTS) speakInUserVoiceChannel(e *events.MessageCreate, audio []byte) {
-	targetVoiceChannelId, foundInVC := util.GetUserVoiceChannel(e, e.Message.Author.ID)
-	if !foundInVC {
-		_, _ = util.SendMessageInChannel(e, e.ChannelID, "you need to be in a voice channel to use this command")
-		return
+	var channelID snowflake.ID
+	if targetChannelName != "" {
+		channelID, err = t.voiceChannel(r, targetChannelName)
+		if err != nil {
+			_ = r.Reply(err.Error())
+			return
+		}
 	}
 
-	t.speakInChannel(e, audio, targetVoiceChannelId)
+	t.speakInBackground(r, audio, channelID)
 }
 
-func (t *TTS) speakInChannel(e *events.MessageCreate, audio []byte, targetVoiceChannelId *snowflake.ID) {
-	t.Logger.Info("joining voice channel to play audio", "guild", e.GuildID, "channelID", targetVoiceChannelId)
-
-	encodeSession, err := dca.EncodeMem(bytes.NewReader(audio), dca.StdEncodeOptions)
-	if err != nil {
-		_, _ = util.SendMessageInChannel(e, e.ChannelID, fmt.Sprintf("failed to create encoding session: %v", err))
-		return
+// voiceChannel resolves where a request should be played: the named channel,
+// else the requester's voice channel, else the guild's default voice channel.
+func (t *TTS) voiceChannel(r *util.Request, name string) (snowflake.ID, error) {
+	if name != "" {
+		channelID, err := t.getVoiceChannelByName(r, name)
+		if err != nil {
+			return 0, fmt.Errorf("failed to find voice channel with name '%s': %v", name, err)
+		}
+		return *channelID, nil
 	}
-	defer encodeSession.Cleanup()
 
-	t.Logger.Info("Starting to play audio")
-	voiceConn := t.VoiceManager.CreateConn(*e.GuildID)
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	if channelID, ok := util.GetUserVoiceChannel(r, r.RequesterID); ok && channelID != nil {
+		return *channelID, nil
+	}
 
-	err = voiceConn.Open(ctx, *targetVoiceChannelId, false, true)
+	name = t.Settings.VoiceChannel()
+	if name == "" {
+		return 0, errors.New("you need to be in a voice channel to use this command")
+	}
+	channelID, err := t.getVoiceChannelByName(r, name)
 	if err != nil {
-		_, _ = util.SendMessageInChannel(e, e.ChannelID, fmt.Sprintf("failed to join voice channel: %v", err))
-		return
+		return 0, fmt.Errorf("failed to find the default voice channel '%s': %v", name, err)
 	}
-	defer voiceConn.Close(ctx)
+	return *channelID, nil
+}
 
-	err = voiceConn.SetSpeaking(ctx, voice.SpeakingFlagMicrophone)
-	if err != nil {
-		_, _ = util.SendMessageInChannel(e, e.ChannelID, fmt.Sprintf("failed to start speaking: %v", err))
+// speakInBackground plays audio without waiting for it, tracked by t.Playing.
+func (t *TTS) speakInBackground(r *util.Request, audio []byte, channelID snowflake.ID) {
+	if t.Playing == nil {
+		go t.speak(r, audio, channelID)
 		return
 	}
+	t.Playing.Go(func() { t.speak(r, audio, channelID) })
+}
 
-	// drop any incoming audio, this is expected by the discord API.
-	// we auto deafen, so we may not even get anything, but it can't hurt
-	// to do what's expected here.
-	go func() {
-	FakeRead:
-		for {
-			select {
-			case <-ctx.Done():
-				break FakeRead
-			default:
-				// drop any errors,
-				_, err = voiceConn.UDP().ReadPacket()
-				if err != nil {
-					break FakeRead
-				}
-				time.Sleep(time.Millisecond * 20)
-			}
-		}
-	}()
-
-	for {
-		frame, err := encodeSession.OpusFrame()
-		if err != nil {
-			if err == io.EOF {
-				t.Logger.Info("finished playing audio")
-				break
-			}
-			t.Logger.Error("failed to read opus frame", "err", err)
-			break
-		}
-
-		_, err = voiceConn.UDP().Write(frame)
-		if err != nil {
-			t.Logger.Error("failed to write packet", "err", err)
-			break
+// speak plays audio in the channel, or where voiceChannel finds when it's 0,
+// telling the requester if it can't.
+func (t *TTS) speak(r *util.Request, audio []byte, channelID snowflake.ID) {
+	var err error
+	if channelID == 0 {
+		if channelID, err = t.voiceChannel(r, ""); err != nil {
+			_ = r.Reply(err.Error())
+			return
 		}
+	}
 
-		time.Sleep(20 * time.Millisecond)
+	if err := t.PlayInChannel(r.GuildID, channelID, r.RequesterID, audio); err != nil {
+		_ = r.Reply(err.Error())
 	}
+}
 
-	t.Logger.Info("disconnected from voice channel", "channelID", targetVoiceChannelId)
-	time.Sleep(time.Millisecond * 200)
+// PlayInChannel queues audio to be played in the voice channel at the volume
+// of the user who requested it, returning once playback has finished.
+func (t *TTS) PlayInChannel(guildID, targetVoiceChannelId, requesterID snowflake.ID, audio []byte) error {
+	t.Logger.Info("queueing audio", "guild", guildID, "channelID", targetVoiceChannelId)
+	return t.Connections.Play(&PlayRequest{
+		GuildID:     guildID,
+		ChannelID:   targetVoiceChannelId,
+		RequesterID: requesterID,
+		Audio:       audio,
+		Options:     t.encodeOptions(guildID, requesterID),
+	})
 }
 
-func (t *TTS) getVoiceChannelByName(e *events.MessageCreate, channelName string) (*snowflake.ID, error) {
-	cc, err := e.Client().Rest.GetGuildChannels(*e.GuildID)
+func (t *TTS) getVoiceChannelByName(r *util.Request, channelName string) (*snowflake.ID, error) {
+	cc, err := r.Rest.GetGuildChannels(r.GuildID)
 	if err != nil {
 		return nil, err
 	}
@@ -357,11 +547,7 @@ func (t *TTS) getVoiceChannelByName(e *events.MessageCreate, channelName string)
 	return nil, fmt.Errorf("failed to find channel with the name %s", channelName)
 }
 
-func getFileName(input, voice string) string {
-	audioDir := os.Getenv("AUDIO_DIR")
-	if audioDir == "" {
-		audioDir = filepath.Join(".", "audio")
-	}
+func getFileName(audioDir, input, voice string) string {
 	// Ensure directory exists (best effort)
 	_ = os.MkdirAll(audioDir, 0755)
 
@@ -384,7 +570,7 @@ func getFileName(input, voice string) string {
 	full := filepath.Join(audioDir, voiceSafe+"__"+base) + ".wav"
 
 	// Backward compatibility: if legacy name exists, use it
-	legacy := legacyFileName(input)
+	legacy := legacyFileName(audioDir, input)
 	if fileIsCached(legacy) {
 		return legacy
 	}
@@ -392,13 +578,9 @@ func getFileName(input, voice string) string {
 }
 
 // legacyFileName replicates the old naming (spaces as underscores, no sanitization)
-func legacyFileName(input string) string {
+func legacyFileName(audioDir, input string) string {
 	name := strings.Join(strings.Split(input, " "), "_")
 	name = strings.TrimSuffix(name, ".")
-	audioDir := os.Getenv("AUDIO_DIR")
-	if audioDir == "" {
-		audioDir = filepath.Join(".", "audio")
-	}
 	return filepath.Join(audioDir, fmt.Sprintf("%s.wav", name))
 }
 
@@ -0,0 +1,182 @@
+package tts
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/caffeinatedtoad/dca"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const (
+	DefaultVolume = 100
+	MaxVolume     = 200
+)
+
+// VolumeSettings is the persisted form of the volume store. Volumes are
+// percentages of the original clip level, 100 being unchanged.
+type VolumeSettings struct {
+	Guilds map[snowflake.ID]int                  `json:"guilds"`
+	Users  map[snowflake.ID]map[snowflake.ID]int `json:"users"` // guild -> user -> volume
+}
+
+// VolumeStore keeps the per-guild playback volume and per-user overrides,
+// saving every change to disk so they survive restarts.
+type VolumeStore struct {
+	sync.Mutex
+	path     string
+	settings VolumeSettings
+	logger   *slog.Logger
+}
+
+// NewVolumeStore loads the volume settings from the audio directory, starting
+// empty if the file does not exist yet.
+func NewVolumeStore(audioDir string, logger *slog.Logger) (*VolumeStore, error) {
+	v := &VolumeStore{
+		path:   filepath.Join(audioDir, "volume.json"),
+		logger: logger,
+		settings: VolumeSettings{
+			Guilds: map[snowflake.ID]int{},
+			Users:  map[snowflake.ID]map[snowflake.ID]int{},
+		},
+	}
+
+	data, err := os.ReadFile(v.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return v, nil
+		}
+		return nil, fmt.Errorf("failed to read volume settings: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &v.settings); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal volume settings: %w", err)
+	}
+	if v.settings.Guilds == nil {
+		v.settings.Guilds = map[snowflake.ID]int{}
+	}
+	if v.settings.Users == nil {
+		v.settings.Users = map[snowflake.ID]map[snowflake.ID]int{}
+	}
+
+	return v, nil
+}
+
+// Get returns the volume to use for a clip requested by userID, preferring the
+// user's override over the guild volume.
+func (v *VolumeStore) Get(guildID, userID snowflake.ID) int {
+	v.Lock()
+	defer v.Unlock()
+
+	if volume, ok := v.settings.Users[guildID][userID]; ok {
+		return volume
+	}
+	if volume, ok := v.settings.Guilds[guildID]; ok {
+		return volume
+	}
+	return DefaultVolume
+}
+
+// GuildVolume returns the volume configured for the guild, ignoring overrides.
+func (v *VolumeStore) GuildVolume(guildID snowflake.ID) int {
+	v.Lock()
+	defer v.Unlock()
+
+	if volume, ok := v.settings.Guilds[guildID]; ok {
+		return volume
+	}
+	return DefaultVolume
+}
+
+// UserVolume returns the user's override, if they have one.
+func (v *VolumeStore) UserVolume(guildID, userID snowflake.ID) (int, bool) {
+	v.Lock()
+	defer v.Unlock()
+
+	volume, ok := v.settings.Users[guildID][userID]
+	return volume, ok
+}
+
+func (v *VolumeStore) SetGuildVolume(guildID snowflake.ID, volume int) error {
+	if err := validateVolume(volume); err != nil {
+		return err
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	v.settings.Guilds[guildID] = volume
+	return v.save()
+}
+
+func (v *VolumeStore) SetUserVolume(guildID, userID snowflake.ID, volume int) error {
+	if err := validateVolume(volume); err != nil {
+		return err
+	}
+
+	v.Lock()
+	defer v.Unlock()
+
+	if v.settings.Users[guildID] == nil {
+		v.settings.Users[guildID] = map[snowflake.ID]int{}
+	}
+	v.settings.Users[guildID][userID] = volume
+	return v.save()
+}
+
+func (v *VolumeStore) ClearUserVolume(guildID, userID snowflake.ID) error {
+	v.Lock()
+	defer v.Unlock()
+
+	delete(v.settings.Users[guildID], userID)
+	return v.save()
+}
+
+// save writes the settings atomically using a temp file + rename, the caller must hold the lock.
+func (v *VolumeStore) save() error {
+	if err := os.MkdirAll(filepath.Dir(v.path), 0755); err != nil {
+		return fmt.Errorf("failed to create volume settings directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(v.settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal volume settings: %w", err)
+	}
+
+	tempPath := v.path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write temp volume settings file: %w", err)
+	}
+
+	if err := os.Rename(tempPath, v.path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rename volume settings file: %w", err)
+	}
+
+	v.logger.Info("saved volume settings", "path", v.path)
+	return nil
+}
+
+func validateVolume(volume int) error {
+	if volume < 0 || volume > MaxVolume {
+		return fmt.Errorf("volume must be between 0 and %d", MaxVolume)
+	}
+	return nil
+}
+
+// encodeOptions returns the dca options for a clip requested by userID. dca
+// applies the volume to the decoded PCM before it is encoded to opus, where
+// 256 is the original level.
+func (t *TTS) encodeOptions(guildID, userID snowflake.ID) *dca.EncodeOptions {
+	opts := *dca.StdEncodeOptions
+	if t.Volumes == nil {
+		return &opts
+	}
+
+	opts.Volume = t.Volumes.Get(guildID, userID) * 256 / 100
+	return &opts
+}
@@ -0,0 +1,272 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"marcus/pkg/config"
+	"marcus/pkg/tts"
+	"marcus/pkg/usage"
+	"marcus/pkg/util"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+const usageUsage = "```\nUsage: !usage - shows how much of the ElevenLabs quota is left and who has used the most this month\n" +
+	"       !usage ai - shows what AI questions have cost today and this month\n```"
+
+// ErrOverBudget is matched by errors for audio that would go over a monthly
+// ElevenLabs budget.
+var ErrOverBudget = errors.New("over the ElevenLabs budget")
+
+// overBudgetError says whose budget would be gone over.
+type overBudgetError string
+
+func (e overBudgetError) Error() string        { return string(e) }
+func (e overBudgetError) Is(target error) bool { return target == ErrOverBudget }
+
+// ReserveElevenLabsBudget records the characters in content against the
+// user and guild, or returns an error if they would go over their monthly
+// ElevenLabs character budget. Give them back with ReleaseElevenLabsBudget if
+// the audio isn't generated.
+func ReserveElevenLabsBudget(cfg *config.Store, ledger *usage.Ledger, guildID, userID snowflake.ID, content string) error {
+	if ledger == nil {
+		return nil
+	}
+
+	budgets := cfg.Get().ElevenLabs
+	characters := float64(utf8.RuneCountInString(content))
+	err := ledger.Reserve(guildID, userID, characters, func(guildSpent, userSpent float64) error {
+		if limit := budgets.MonthlyUserCharacters; limit > 0 && userSpent+characters > float64(limit) {
+			return overBudgetError(fmt.Sprintf("that would take you over your ElevenLabs budget of %d characters this month (%d left), lines that have been said before can still be played", limit, max(limit-int(userSpent), 0)))
+		}
+		if limit := budgets.MonthlyGuildCharacters; limit > 0 && guildSpent+characters > float64(limit) {
+			return overBudgetError(fmt.Sprintf("that would take this server over its ElevenLabs budget of %d characters this month (%d left), lines that have been said before can still be played", limit, max(limit-int(guildSpent), 0)))
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrOverBudget) {
+		return fmt.Errorf("failed to record ElevenLabs usage: %w", err)
+	}
+	return err
+}
+
+// ReleaseElevenLabsBudget gives back characters reserved for audio that
+// wasn't generated.
+func ReleaseElevenLabsBudget(ledger *usage.Ledger, guildID, userID snowflake.ID, content string, logger *slog.Logger) {
+	if ledger == nil {
+		return
+	}
+	if err := ledger.Record(guildID, userID, -float64(utf8.RuneCountInString(content))); err != nil {
+		logger.Error("failed to release ElevenLabs usage", "err", err)
+	}
+}
+
+// generationFailed gives back the characters reserved for ElevenLabs audio
+// that couldn't be generated.
+func (c *Command) generationFailed(generatorName, content string) {
+	if generatorName != tts.ElevenLabsGeneratorName {
+		return
+	}
+	ReleaseElevenLabsBudget(c.ElevenLabsUsage, *c.MessageEvent.GuildID, c.MessageEvent.Message.Author.ID, content, c.Logger)
+}
+
+func (c *Command) ShowUsage() {
+	switch strings.ToLower(c.TTSOpts.Content) {
+	case "", "tts", "elevenlabs":
+		c.showElevenLabsUsage()
+	case "ai":
+		c.showAIUsage()
+	default:
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, usageUsage, "failed to send usage for usage")
+	}
+}
+
+func (c *Command) showElevenLabsUsage() {
+	guildID := *c.MessageEvent.GuildID
+	userID := c.MessageEvent.Message.Author.ID
+	cfg := c.Config.Get().ElevenLabs
+
+	b := strings.Builder{}
+	b.WriteString("**ElevenLabs usage**\n")
+
+	if cfg.APIKey == "" {
+		b.WriteString("ElevenLabs isn't set up.\n")
+	} else {
+		subscription, err := elevenLabsSubscription(c.Logger, cfg.APIKey)
+		if err != nil {
+			c.Logger.Error("failed to get ElevenLabs subscription", "err", err)
+			b.WriteString(fmt.Sprintf("Couldn't get the account quota: %v\n", err))
+		} else {
+			b.WriteString(fmt.Sprintf("Account: %d of %d characters left, resets <t:%d:R>\n", subscription.Remaining(), subscription.CharacterLimit, subscription.ResetsAt().Unix()))
+		}
+	}
+
+	if c.ElevenLabsUsage == nil {
+		b.WriteString("Usage tracking isn't available right now.")
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, b.String(), "failed to send usage")
+		return
+	}
+
+	guildSpent, userSpent := c.ElevenLabsUsage.Spent(guildID, userID)
+	b.WriteString(fmt.Sprintf("This server this month: %s\n", formatBudget(guildSpent, cfg.MonthlyGuildCharacters)))
+	b.WriteString(fmt.Sprintf("You this month: %s\n", formatBudget(userSpent, cfg.MonthlyUserCharacters)))
+
+	if top := c.ElevenLabsUsage.TopSpenders(guildID, 5); len(top) > 0 {
+		b.WriteString("\nTop spenders this month:\n")
+		for i, spender := range top {
+			b.WriteString(fmt.Sprintf("%d. <@%s> %d characters\n", i+1, spender.UserID, int(spender.Amount)))
+		}
+	}
+
+	util.SendMessageWithoutPings(c.MessageEvent, c.MessageEvent.ChannelID, b.String(), "failed to send usage")
+}
+
+// subscriptionTTL is how long the ElevenLabs account quota is reused for,
+// so !usage doesn't call ElevenLabs every time.
+const subscriptionTTL = time.Minute
+
+var subscriptionCache struct {
+	sync.Mutex
+	apiKey       string
+	fetched      time.Time
+	subscription tts.Subscription
+}
+
+// elevenLabsSubscription returns the account's subscription, fetching it
+// again once it's older than subscriptionTTL.
+func elevenLabsSubscription(logger *slog.Logger, apiKey string) (tts.Subscription, error) {
+	subscriptionCache.Lock()
+	defer subscriptionCache.Unlock()
+
+	if subscriptionCache.apiKey == apiKey && time.Since(subscriptionCache.fetched) < subscriptionTTL {
+		return subscriptionCache.subscription, nil
+	}
+
+	subscription, err := tts.ElevenLabsTTSGenerator{Logger: logger, APIKey: apiKey}.Subscription()
+	if err != nil {
+		return tts.Subscription{}, err
+	}
+	subscriptionCache.apiKey, subscriptionCache.fetched, subscriptionCache.subscription = apiKey, time.Now(), subscription
+	return subscription, nil
+}
+
+func formatBudget(spent float64, limit int) string {
+	if limit <= 0 {
+		return fmt.Sprintf("%d characters", int(spent))
+	}
+	return fmt.Sprintf("%d of %d characters (%d left)", int(spent), limit, max(limit-int(spent), 0))
+}
+
+// checkAISpend refuses a question once the bot, guild or user has reached a
+// daily or monthly AI spending cap.
+func (c *Command) checkAISpend() error {
+	if c.AIUsage == nil {
+		return nil
+	}
+
+	guildID := *c.MessageEvent.GuildID
+	userID := c.MessageEvent.Message.Author.ID
+	cfg := c.Config.Get().OpenRouter
+	now := time.Now()
+
+	periods := []struct {
+		name  string
+		since time.Time
+		cap   config.SpendCap
+	}{
+		{"today", usage.StartOfDay(now), cfg.DailyCap},
+		{"this month", usage.StartOfMonth(now), cfg.MonthlyCap},
+	}
+	for _, period := range periods {
+		if limit := period.cap.User; limit > 0 && c.AIUsage.Sum(period.since, func(r usage.Request) bool { return r.GuildID == guildID && r.UserID == userID }).Cost >= limit {
+			return fmt.Errorf("you've used up your AI budget of $%.2f for %s, try again later", limit, period.name)
+		}
+		if limit := period.cap.Guild; limit > 0 && c.AIUsage.Sum(period.since, func(r usage.Request) bool { return r.GuildID == guildID }).Cost >= limit {
+			return fmt.Errorf("this server has used up its AI budget of $%.2f for %s, try again later", limit, period.name)
+		}
+		if limit := period.cap.Total; limit > 0 && c.AIUsage.Sum(period.since, func(usage.Request) bool { return true }).Cost >= limit {
+			return fmt.Errorf("marcus has spent his whole AI budget for %s, try again later", period.name)
+		}
+	}
+	return nil
+}
+
+// recordAIUsage adds a completion's tokens and cost to the request log.
+// Failed questions that cost nothing aren't recorded.
+func (c *Command) recordAIUsage(result ChatResponse) {
+	if c.AIUsage == nil || result.Model == "" {
+		return
+	}
+
+	err := c.AIUsage.Record(usage.Request{
+		GuildID:          *c.MessageEvent.GuildID,
+		UserID:           c.MessageEvent.Message.Author.ID,
+		Model:            result.Model,
+		PromptTokens:     result.PromptTokens,
+		CompletionTokens: result.CompletionTokens,
+		Cost:             result.Cost,
+	})
+	if err != nil {
+		c.Logger.Error("failed to record AI usage", "err", err)
+	}
+}
+
+func (c *Command) showAIUsage() {
+	if c.AIUsage == nil {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, "AI usage tracking isn't available right now.", "failed to send usage")
+		return
+	}
+
+	guildID := *c.MessageEvent.GuildID
+	userID := c.MessageEvent.Message.Author.ID
+	cfg := c.Config.Get().OpenRouter
+	now := time.Now()
+	today, month := usage.StartOfDay(now), usage.StartOfMonth(now)
+
+	inGuild := func(r usage.Request) bool { return r.GuildID == guildID }
+	byUser := func(r usage.Request) bool { return r.GuildID == guildID && r.UserID == userID }
+	everywhere := func(usage.Request) bool { return true }
+
+	b := strings.Builder{}
+	b.WriteString("**AI usage**\n")
+	b.WriteString(fmt.Sprintf("Everywhere: %s today, %s this month\n",
+		formatSpend(c.AIUsage.Sum(today, everywhere), cfg.DailyCap.Total),
+		formatSpend(c.AIUsage.Sum(month, everywhere), cfg.MonthlyCap.Total)))
+	b.WriteString(fmt.Sprintf("This server: %s today, %s this month\n",
+		formatSpend(c.AIUsage.Sum(today, inGuild), cfg.DailyCap.Guild),
+		formatSpend(c.AIUsage.Sum(month, inGuild), cfg.MonthlyCap.Guild)))
+	b.WriteString(fmt.Sprintf("You: %s today, %s this month\n",
+		formatSpend(c.AIUsage.Sum(today, byUser), cfg.DailyCap.User),
+		formatSpend(c.AIUsage.Sum(month, byUser), cfg.MonthlyCap.User)))
+
+	if models := c.AIUsage.ByModel(guildID, month); len(models) > 0 {
+		b.WriteString("\nModels this month:\n")
+		for _, model := range slices.Sorted(maps.Keys(models)) {
+			totals := models[model]
+			b.WriteString(fmt.Sprintf("- %s: %d questions, %d tokens in, %d tokens out, $%.4f\n", model, totals.Requests, totals.PromptTokens, totals.CompletionTokens, totals.Cost))
+		}
+	}
+
+	if top := c.AIUsage.TopSpenders(guildID, month, 5); len(top) > 0 {
+		b.WriteString("\nTop spenders this month:\n")
+		for i, spender := range top {
+			b.WriteString(fmt.Sprintf("%d. <@%s> $%.4f\n", i+1, spender.UserID, spender.Amount))
+		}
+	}
+
+	util.SendMessageWithoutPings(c.MessageEvent, c.MessageEvent.ChannelID, b.String(), "failed to send usage")
+}
+
+func formatSpend(totals usage.Totals, limit float64) string {
+	if limit <= 0 {
+		return fmt.Sprintf("$%.4f (%d questions)", totals.Cost, totals.Requests)
+	}
+	return fmt.Sprintf("$%.4f of $%.2f (%d questions)", totals.Cost, limit, totals.Requests)
+}
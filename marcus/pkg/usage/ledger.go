@@ -0,0 +1,187 @@
+package usage
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// monthsKept is how much history is kept in a ledger.
+const monthsKept = 12
+
+// Month is what every guild and user spent in one calendar month.
+type Month struct {
+	Guilds map[snowflake.ID]float64                  `json:"guilds"`
+	Users  map[snowflake.ID]map[snowflake.ID]float64 `json:"users"` // guild -> user -> amount
+}
+
+// Spender is a user and how much they spent.
+type Spender struct {
+	UserID snowflake.ID
+	Amount float64
+}
+
+// Ledger records how much of something, such as characters or dollars, each
+// guild and user has spent per month, saving every change to disk.
+type Ledger struct {
+	sync.Mutex
+	path   string
+	months map[string]*Month // "2006-01" -> month
+	logger *slog.Logger
+}
+
+// NewLedger loads a ledger from path, starting empty if it does not exist yet.
+func NewLedger(path string, logger *slog.Logger) (*Ledger, error) {
+	l := &Ledger{
+		path:   path,
+		months: map[string]*Month{},
+		logger: logger,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return l, nil
+		}
+		return nil, fmt.Errorf("failed to read usage ledger: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &l.months); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal usage ledger: %w", err)
+	}
+	if l.months == nil {
+		l.months = map[string]*Month{}
+	}
+
+	return l, nil
+}
+
+func monthKey(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// Record adds amount to what the guild and user have spent this month.
+func (l *Ledger) Record(guildID, userID snowflake.ID, amount float64) error {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.record(guildID, userID, amount)
+}
+
+// Reserve records amount if check, given what the guild and user have spent
+// this month, allows it. Checking and recording together means concurrent
+// spending can't all pass the check. Unused reservations are given back by
+// recording -amount.
+func (l *Ledger) Reserve(guildID, userID snowflake.ID, amount float64, check func(guild, user float64) error) error {
+	l.Lock()
+	defer l.Unlock()
+
+	guild, user := l.spent(guildID, userID)
+	if err := check(guild, user); err != nil {
+		return err
+	}
+	return l.record(guildID, userID, amount)
+}
+
+// record adds amount to this month, the caller must hold the lock.
+func (l *Ledger) record(guildID, userID snowflake.ID, amount float64) error {
+	key := monthKey(time.Now())
+	month, ok := l.months[key]
+	if !ok {
+		month = &Month{
+			Guilds: map[snowflake.ID]float64{},
+			Users:  map[snowflake.ID]map[snowflake.ID]float64{},
+		}
+		l.months[key] = month
+		l.prune()
+	}
+
+	month.Guilds[guildID] += amount
+	if month.Users[guildID] == nil {
+		month.Users[guildID] = map[snowflake.ID]float64{}
+	}
+	month.Users[guildID][userID] += amount
+
+	return l.save()
+}
+
+// Spent returns what the guild, and the user within it, have spent this month.
+func (l *Ledger) Spent(guildID, userID snowflake.ID) (guild, user float64) {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.spent(guildID, userID)
+}
+
+// spent is Spent, the caller must hold the lock.
+func (l *Ledger) spent(guildID, userID snowflake.ID) (guild, user float64) {
+	month, ok := l.months[monthKey(time.Now())]
+	if !ok {
+		return 0, 0
+	}
+	return month.Guilds[guildID], month.Users[guildID][userID]
+}
+
+// TopSpenders returns up to n users in the guild who spent the most this month.
+func (l *Ledger) TopSpenders(guildID snowflake.ID, n int) []Spender {
+	l.Lock()
+	defer l.Unlock()
+
+	month, ok := l.months[monthKey(time.Now())]
+	if !ok {
+		return nil
+	}
+
+	spenders := make([]Spender, 0, len(month.Users[guildID]))
+	for userID, amount := range month.Users[guildID] {
+		spenders = append(spenders, Spender{UserID: userID, Amount: amount})
+	}
+	slices.SortFunc(spenders, func(a, b Spender) int {
+		return cmp.Compare(b.Amount, a.Amount)
+	})
+
+	return spenders[:min(n, len(spenders))]
+}
+
+// prune forgets months older than monthsKept, the caller must hold the lock.
+func (l *Ledger) prune() {
+	oldest := monthKey(time.Now().AddDate(0, -monthsKept, 0))
+	for key := range l.months {
+		if key < oldest {
+			delete(l.months, key)
+		}
+	}
+}
+
+// save writes the ledger atomically using a temp file + rename, the caller must hold the lock.
+func (l *Ledger) save() error {
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("failed to create usage ledger directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(l.months, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage ledger: %w", err)
+	}
+
+	tempPath := l.path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write temp usage ledger file: %w", err)
+	}
+
+	if err := os.Rename(tempPath, l.path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to rename usage ledger file: %w", err)
+	}
+
+	l.logger.Debug("saved usage ledger", "path", l.path)
+	return nil
+}
@@ -0,0 +1,185 @@
+package usage
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// Request is one paid API call, such as an AI completion.
+type Request struct {
+	Time             time.Time    `json:"time"`
+	GuildID          snowflake.ID `json:"guild_id"`
+	UserID           snowflake.ID `json:"user_id"`
+	Model            string       `json:"model"`
+	PromptTokens     int          `json:"prompt_tokens"`
+	CompletionTokens int          `json:"completion_tokens"`
+	Cost             float64      `json:"cost"` // USD
+}
+
+// Totals adds up a set of requests.
+type Totals struct {
+	Requests         int
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+}
+
+func (t *Totals) add(r Request) {
+	t.Requests++
+	t.PromptTokens += r.PromptTokens
+	t.CompletionTokens += r.CompletionTokens
+	t.Cost += r.Cost
+}
+
+// RequestLog keeps every request from the last year. Requests are appended to
+// a JSON lines file as they happen, so recording one never rewrites the file.
+type RequestLog struct {
+	sync.Mutex
+	path     string
+	requests []Request
+	logger   *slog.Logger
+}
+
+// NewRequestLog loads the request log at path, starting empty if it does not exist yet.
+func NewRequestLog(path string, logger *slog.Logger) (*RequestLog, error) {
+	l := &RequestLog{
+		path:   path,
+		logger: logger,
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return l, nil
+		}
+		return nil, fmt.Errorf("failed to open request log: %w", err)
+	}
+	defer file.Close()
+
+	oldest := time.Now().AddDate(0, -monthsKept, 0)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var r Request
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// a crash mid-write leaves a partial last line, which isn't worth failing over
+			logger.Warn("skipping unreadable request log entry", "path", path, "line", line, "err", err)
+			continue
+		}
+		if r.Time.After(oldest) {
+			l.requests = append(l.requests, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read request log: %w", err)
+	}
+
+	return l, nil
+}
+
+// Record appends a request to the log.
+func (l *RequestLog) Record(r Request) error {
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("failed to create request log directory: %w", err)
+	}
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open request log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write request log: %w", err)
+	}
+
+	l.requests = append(l.requests, r)
+	return nil
+}
+
+// Sum adds up the requests made since the given time that match.
+func (l *RequestLog) Sum(since time.Time, match func(Request) bool) Totals {
+	l.Lock()
+	defer l.Unlock()
+
+	var totals Totals
+	for _, r := range l.requests {
+		if !r.Time.Before(since) && match(r) {
+			totals.add(r)
+		}
+	}
+	return totals
+}
+
+// ByModel adds up the guild's requests made since the given time for each model.
+func (l *RequestLog) ByModel(guildID snowflake.ID, since time.Time) map[string]Totals {
+	l.Lock()
+	defer l.Unlock()
+
+	models := map[string]Totals{}
+	for _, r := range l.requests {
+		if r.GuildID != guildID || r.Time.Before(since) {
+			continue
+		}
+		totals := models[r.Model]
+		totals.add(r)
+		models[r.Model] = totals
+	}
+	return models
+}
+
+// TopSpenders returns up to n users in the guild who spent the most since the given time.
+func (l *RequestLog) TopSpenders(guildID snowflake.ID, since time.Time, n int) []Spender {
+	l.Lock()
+	defer l.Unlock()
+
+	spent := map[snowflake.ID]float64{}
+	for _, r := range l.requests {
+		if r.GuildID == guildID && !r.Time.Before(since) {
+			spent[r.UserID] += r.Cost
+		}
+	}
+
+	spenders := make([]Spender, 0, len(spent))
+	for userID, amount := range spent {
+		spenders = append(spenders, Spender{UserID: userID, Amount: amount})
+	}
+	slices.SortFunc(spenders, func(a, b Spender) int {
+		return cmp.Compare(b.Amount, a.Amount)
+	})
+
+	return spenders[:min(n, len(spenders))]
+}
+
+// StartOfDay returns midnight UTC on the day of t.
+func StartOfDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// StartOfMonth returns midnight UTC on the first of the month of t.
+func StartOfMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"errors"
+	"log/slog"
+	"marcus/pkg/config"
+	"marcus/pkg/usage"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReserveElevenLabsBudget(t *testing.T) {
+	tests := []struct {
+		name         string
+		userBudget   int
+		guildBudget  int
+		requests     int
+		release      bool
+		wantReserved int
+		wantSpent    float64
+	}{
+		{name: "no budgets", requests: 5, wantReserved: 5, wantSpent: 15},
+		{name: "concurrent requests can't overshoot the user budget", userBudget: 10, requests: 5, wantReserved: 3, wantSpent: 9},
+		{name: "concurrent requests can't overshoot the guild budget", guildBudget: 7, requests: 5, wantReserved: 2, wantSpent: 6},
+		{name: "failed generations are given back", userBudget: 3, requests: 5, release: true, wantSpent: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := slog.New(slog.DiscardHandler)
+			cfg := config.Default()
+			cfg.ElevenLabs.MonthlyUserCharacters = tt.userBudget
+			cfg.ElevenLabs.MonthlyGuildCharacters = tt.guildBudget
+			store := config.NewStaticStore(cfg)
+
+			ledger, err := usage.NewLedger(filepath.Join(t.TempDir(), "usage.json"), logger)
+			if err != nil {
+				t.Fatalf("failed to create ledger: %v", err)
+			}
+
+			var reserved atomic.Int32
+			var wg sync.WaitGroup
+			for range tt.requests {
+				wg.Go(func() {
+					err := ReserveElevenLabsBudget(store, ledger, testGuildID, testUserID, "abc")
+					if errors.Is(err, ErrOverBudget) {
+						return
+					}
+					if err != nil {
+						t.Errorf("failed to reserve: %v", err)
+						return
+					}
+					reserved.Add(1)
+					if tt.release {
+						ReleaseElevenLabsBudget(ledger, testGuildID, testUserID, "abc", logger)
+					}
+				})
+			}
+			wg.Wait()
+
+			// given back reservations make room for others, however they interleave
+			if got := int(reserved.Load()); !tt.release && got != tt.wantReserved {
+				t.Errorf("reserved %d times, want %d", got, tt.wantReserved)
+			}
+			if _, spent := ledger.Spent(testGuildID, testUserID); spent != tt.wantSpent {
+				t.Errorf("spent %v, want %v", spent, tt.wantSpent)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package util
+
+import (
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// Request is who asked for audio and how to answer them, whether they asked
+// with a message, a slash command, a button, a timer or the API.
+type Request struct {
+	GuildID     snowflake.ID
+	RequesterID snowflake.ID
+	Rest        rest.Rest
+
+	reply func(content string) error
+}
+
+// NewRequest returns a request that answers with reply, which may be nil
+// when there's nobody to tell.
+func NewRequest(restClient rest.Rest, guildID, requesterID snowflake.ID, reply func(content string) error) *Request {
+	return &Request{GuildID: guildID, RequesterID: requesterID, Rest: restClient, reply: reply}
+}
+
+// MessageRequest is a request from a message, answered in its channel.
+func MessageRequest(e *events.MessageCreate) *Request {
+	var guildID snowflake.ID
+	if e.GuildID != nil {
+		guildID = *e.GuildID
+	}
+	return NewRequest(e.Client().Rest, guildID, e.Message.Author.ID, func(content string) error {
+		_, err := SendMessageInChannel(e, e.ChannelID, content)
+		return err
+	})
+}
+
+// InteractionRequest is a request from a slash command or button, answered
+// with follow-up messages. The interaction must already have been responded
+// to, such as with a deferred response.
+func InteractionRequest(e *events.InteractionCreate) *Request {
+	var guildID snowflake.ID
+	if id := e.GuildID(); id != nil {
+		guildID = *id
+	}
+	return NewRequest(e.Client().Rest, guildID, e.User().ID, func(content string) error {
+		_, err := e.Client().Rest.CreateFollowupMessage(e.ApplicationID(), e.Token(), discord.NewMessageCreate().WithContent(content))
+		return err
+	})
+}
+
+// Reply tells the requester something, such as why their clip can't play.
+func (r *Request) Reply(content string) error {
+	if r.reply == nil {
+		return nil
+	}
+	return r.reply(content)
+}
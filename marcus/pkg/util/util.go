@@ -1,6 +1,7 @@
 package util
 
 import (
+	"github.com/disgoorg/disgo/bot"
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/events"
 	"github.com/disgoorg/snowflake/v2"
@@ -9,6 +10,7 @@ import (
 	"math/rand"
 	"reflect"
 	"runtime"
+	"slices"
 	"strings"
 )
 
@@ -44,6 +46,14 @@ func SendMessageWithError(e *events.MessageCreate, channelId snowflake.ID, conte
 	}
 }
 
+// SendMessageWithoutPings sends a message whose mentions are shown but don't notify anyone.
+func SendMessageWithoutPings(e *events.MessageCreate, channelId snowflake.ID, content, errorMessage string) {
+	_, err := e.Client().Rest.CreateMessage(channelId, discord.NewMessageCreate().WithContent(content).WithAllowedMentions(&discord.AllowedMentions{}))
+	if err != nil {
+		_, _ = SendMessageInChannel(e, channelId, fmt.Sprintf("%s: %v", errorMessage, err))
+	}
+}
+
 func EditMessageWithError(e *events.MessageCreate, msgId snowflake.ID, content, errorMessage string) (*discord.Message, error) {
 	msg, err := e.Client().Rest.UpdateMessage(e.ChannelID, msgId, discord.NewMessageUpdate().WithContent(content))
 	if err != nil {
@@ -59,23 +69,60 @@ func SendMessageInChannel(e *events.MessageCreate, channelId snowflake.ID, conte
 }
 
 func MessageInChannel(e *events.MessageCreate, channelName string) bool {
+	return MessageInChannels(e, []string{channelName})
+}
+
+// MessageInChannels reports whether the message was sent in any of the named
+// text channels. It assumes it was if the channels can't be fetched.
+func MessageInChannels(e *events.MessageCreate, channelNames []string) bool {
 	cc, err := e.Client().Rest.GetGuildChannels(*e.GuildID)
 	if err != nil {
 		return true
 	}
 
 	for _, c := range cc {
-		if strings.ToLower(c.Name()) == channelName && c.Type() == discord.ChannelTypeGuildText {
-			return e.ChannelID == c.ID()
+		if c.ID() == e.ChannelID && c.Type() == discord.ChannelTypeGuildText {
+			return slices.Contains(channelNames, strings.ToLower(c.Name()))
 		}
 	}
 	return false
 }
 
-func GetUserVoiceChannel(e *events.MessageCreate, userID snowflake.ID) (*snowflake.ID, bool) {
-	vs, err := e.Client().Rest.GetUserVoiceState(*e.GuildID, userID)
+// IsAdmin reports whether the message author can manage the guild.
+func IsAdmin(e *events.MessageCreate) bool {
+	if e.GuildID == nil || e.Message.Member == nil {
+		return false
+	}
+
+	member := *e.Message.Member
+	member.User = e.Message.Author
+	member.GuildID = *e.GuildID
+	return MemberIsAdmin(e.Client(), member)
+}
+
+// MemberIsAdmin reports whether the member can manage their guild.
+func MemberIsAdmin(client *bot.Client, member discord.Member) bool {
+	return client.Caches.MemberPermissions(member).Has(discord.PermissionManageGuild)
+}
+
+// GetUserVoiceChannel returns the voice channel the user is in, in the
+// request's guild.
+func GetUserVoiceChannel(r *Request, userID snowflake.ID) (*snowflake.ID, bool) {
+	vs, err := r.Rest.GetUserVoiceState(r.GuildID, userID)
 	if err != nil {
 		return nil, false
 	}
 	return vs.ChannelID, true
 }
+
+// FormatBytes formats a size in bytes as B, KB or MB.
+func FormatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"fmt"
+	"marcus/pkg/tts"
+	"marcus/pkg/util"
+	"strconv"
+	"strings"
+)
+
+const volumeUsage = "```\nUsage: !volume [0-200] - shows or sets the playback volume for this server\n" +
+	"       !volume-me [0-200|reset] - shows, sets or clears your own volume for the clips you request\n\n" +
+	"100 plays clips at their original level, 0 mutes them and 200 doubles them.\n```"
+
+func (c *Command) SetVolume() {
+	if c.Volumes == nil {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, "Volume control is not available right now.", "failed to send volume")
+		return
+	}
+
+	guildID := *c.MessageEvent.GuildID
+	if c.TTSOpts.Content == "" {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("The volume for this server is %d%%", c.Volumes.GuildVolume(guildID)), "failed to send volume")
+		return
+	}
+
+	volume, ok := parseVolume(c.TTSOpts.Content)
+	if !ok {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, volumeUsage, "failed to send usage for volume")
+		return
+	}
+
+	if err := c.Volumes.SetGuildVolume(guildID, volume); err != nil {
+		c.Logger.Error("failed to set guild volume", "err", err)
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("failed to set volume: %v", err), "failed to set volume")
+		return
+	}
+
+	util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("The volume for this server is now %d%%", volume), "failed to send volume")
+}
+
+func (c *Command) SetUserVolume() {
+	if c.Volumes == nil {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, "Volume control is not available right now.", "failed to send volume")
+		return
+	}
+
+	guildID := *c.MessageEvent.GuildID
+	userID := c.MessageEvent.Message.Author.ID
+	content := strings.ToLower(c.TTSOpts.Content)
+
+	switch content {
+	case "":
+		volume, ok := c.Volumes.UserVolume(guildID, userID)
+		if !ok {
+			util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("You don't have your own volume, your clips play at the server volume of %d%%", c.Volumes.GuildVolume(guildID)), "failed to send volume")
+			return
+		}
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("Your clips play at %d%%", volume), "failed to send volume")
+		return
+	case "reset":
+		if err := c.Volumes.ClearUserVolume(guildID, userID); err != nil {
+			c.Logger.Error("failed to clear user volume", "err", err)
+			util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("failed to reset volume: %v", err), "failed to reset volume")
+			return
+		}
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, "Your clips will now play at the server volume", "failed to send volume")
+		return
+	}
+
+	volume, ok := parseVolume(content)
+	if !ok {
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, volumeUsage, "failed to send usage for volume")
+		return
+	}
+
+	if err := c.Volumes.SetUserVolume(guildID, userID, volume); err != nil {
+		c.Logger.Error("failed to set user volume", "err", err)
+		util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("failed to set volume: %v", err), "failed to set volume")
+		return
+	}
+
+	util.SendMessageWithError(c.MessageEvent, c.MessageEvent.ChannelID, fmt.Sprintf("Your clips will now play at %d%%", volume), "failed to send volume")
+}
+
+// parseVolume accepts "50" or "50%" and checks it is within the supported range.
+func parseVolume(input string) (int, bool) {
+	volume, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(input), "%"))
+	if err != nil || volume < 0 || volume > tts.MaxVolume {
+		return 0, false
+	}
+	return volume, true
+}